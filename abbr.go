@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// translateAbbrTitles translates the expansions in the title attribute of
+// <abbr> elements, which readers show on hover and screen readers read out.
+// It runs before the blocks are translated, so the block around an <abbr>
+// goes to the model with the expansion already translated.
+func translateAbbrTitles(doc *goquery.Document, cfg *Config) []blockFailure {
+	var failures []blockFailure
+	doc.Find("abbr[title]").Each(func(i int, s *goquery.Selection) {
+		title := strings.TrimSpace(s.AttrOr("title", ""))
+		if !hasLetters(title) || insideNoTranslate(s.Get(0)) {
+			return
+		}
+
+		context := fmt.Sprintf("This is the expansion of the abbreviation %q. Output plain text only.", strings.TrimSpace(s.Text()))
+		translated, err := translateNode(title, context, cfg)
+		if err != nil {
+			failures = append(failures, blockFailure{Path: nodePath(s.Get(0)) + "@title", Err: err})
+			return
+		}
+		s.SetAttr("title", html.UnescapeString(translated))
+	})
+	return failures
+}
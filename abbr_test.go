@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAbbrTitles(t *testing.T) {
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(
+		`<p>The <abbr title="World Health Organization">WHO</abbr> met today.</p>`+
+			`<div><abbr title="United Nations">UN</abbr></div>`+
+			`<p translate="no"><abbr title="Do not touch">DNT</abbr></p>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		`<p>[T]The <abbr title="[T]World Health Organization">WHO</abbr> met today.</p>`,
+		`<div><abbr title="[T]United Nations">[T]UN</abbr></div>`,
+		`<abbr title="Do not touch">DNT</abbr>`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if prompt := api.systemPromptFor("World Health Organization"); !strings.Contains(prompt, `expansion of the abbreviation "WHO"`) {
+		t.Errorf("the title was sent without its abbreviation as context:\n%s", prompt)
+	}
+	if api.requested("Do not touch") > 0 {
+		t.Error("an abbreviation with translate=\"no\" was sent")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditLog writes a line of JSON for every API call to the file of
+// -audit-log, for reconciling a run with the provider's invoice. The
+// content of requests and responses is left out unless asked for; their
+// hashes identify them all the same.
+type AuditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	content bool
+}
+
+// AuditRecord is one call: a request, with its retries, that ended in a
+// translation or a failure.
+type AuditRecord struct {
+	Time         time.Time    `json:"time"`
+	RunID        string       `json:"runId"`
+	File         string       `json:"file,omitempty"`
+	Blocks       []AuditBlock `json:"blocks,omitempty"`
+	Model        string       `json:"model"`
+	Status       int          `json:"status,omitempty"`
+	Attempts     int          `json:"attempts"`
+	Retries      int          `json:"retries"`
+	LatencyMs    int64        `json:"latencyMs"`
+	InputTokens  int          `json:"inputTokens"`
+	OutputTokens int          `json:"outputTokens"`
+	RequestHash  string       `json:"requestHash"`
+	ResponseHash string       `json:"responseHash,omitempty"`
+	Request      string       `json:"request,omitempty"`
+	Response     string       `json:"response,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// AuditBlock locates a block sent in a call, like a FailureReport.
+type AuditBlock struct {
+	File  string `json:"file"`
+	Block string `json:"block"`
+}
+
+// openAuditLog opens path for appending, so the calls of several runs can
+// be collected in one file.
+func openAuditLog(path string, content bool) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	return &AuditLog{file: f, content: content}, nil
+}
+
+// record writes the line of a call. request is the body sent, response the
+// translation received, if any.
+func (a *AuditLog) record(r AuditRecord, request []byte, response string) {
+	if a == nil {
+		return
+	}
+	r.RequestHash = hashBytes(request)
+	if response != "" {
+		r.ResponseHash = hashBytes([]byte(response))
+	}
+	if a.content {
+		r.Request, r.Response = string(request), response
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file.Write(append(line, '\n'))
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// auditBlocks returns cfg for calls about the given blocks of cfg.AuditFile,
+// or cfg itself without -audit-log.
+func (cfg *Config) auditBlocks(blocks ...AuditBlock) *Config {
+	if cfg.Audit == nil {
+		return cfg
+	}
+	blockCfg := *cfg
+	blockCfg.AuditBlocks = blocks
+	return &blockCfg
+}
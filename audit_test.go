@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		if strings.Contains(content, "Broken") {
+			return http.StatusInternalServerError, ""
+		}
+		return prefixReply(content)
+	})
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(api.URL)
+	cfg.Audit = audit
+	translate(t, testBook(`<p>Secret text.</p><p>Broken text.</p>`), cfg)
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAuditLog(t, path)
+	calls := len(api.requests())
+	failed := api.requested("Broken")
+	if len(records) != calls-failed+1 {
+		t.Fatalf("got %d audit lines for %d calls with %d attempts at the failing block", len(records), calls-failed+1, failed)
+	}
+
+	var errors int
+	for _, r := range records {
+		if r.RunID != "test-run" || r.Model != "test-model" || r.Time.IsZero() || r.RequestHash == "" || r.Attempts != r.Retries+1 {
+			t.Errorf("incomplete line: %+v", r)
+		}
+		if r.Request != "" || r.Response != "" {
+			t.Errorf("content recorded without -audit-log-content: %+v", r)
+		}
+		if r.Error != "" {
+			errors++
+			if r.Attempts != failed || r.Status != http.StatusInternalServerError {
+				t.Errorf("failed call: got %d attempts and status %d, want %d and 500", r.Attempts, r.Status, failed)
+			}
+			continue
+		}
+		if r.InputTokens != 10 || r.OutputTokens != 5 || r.ResponseHash == "" || r.Attempts != 1 {
+			t.Errorf("translated call: %+v", r)
+		}
+		if r.File == chapterName(1) && len(r.Blocks) == 0 {
+			t.Errorf("no blocks recorded for a call of %s", r.File)
+		}
+	}
+	if errors != 1 {
+		t.Errorf("got %d failed calls, want 1", errors)
+	}
+}
+
+func readAuditLog(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("not a JSON line: %s", scanner.Text())
+		}
+		records = append(records, r)
+	}
+	return records
+}
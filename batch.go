@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resolveInputs expands the input argument into a list of EPUB files. It can
+// be a single file, a directory (all *.epub inside it, non-recursive) or a glob.
+func resolveInputs(arg string) ([]string, error) {
+	if info, err := os.Stat(arg); err == nil {
+		if !info.IsDir() {
+			return []string{arg}, nil
+		}
+		return epubsInDir(arg)
+	}
+
+	matches, err := filepath.Glob(arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no EPUB files match %s", arg)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// epubsInDir lists the EPUBs in dir, skipping our own output files so that a
+// directory can be used as both input and output.
+func epubsInDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(name), ".epub") || strings.HasPrefix(name, "translated-") {
+			continue
+		}
+		inputs = append(inputs, filepath.Join(dir, name))
+	}
+	return inputs, nil
+}
+
+// runBatch translates every input into outDir, continuing past failures
+// unless the run was aborted. It logs a summary at the end and returns the
+// number of failed books.
+func runBatch(inputs []string, outDir string, cfg *Config) int {
+	var failures []string
+
+	for i, input := range inputs {
+		if cfg.Abort.get() != nil {
+			log.Printf("Run aborted, skipping the remaining %d books", len(inputs)-i)
+			failures = append(failures, inputs[i:]...)
+			break
+		}
+
+		log.Printf("Processing book %d/%d: %s", i+1, len(inputs), input)
+
+		outputPath := outputPathFor(input, outDir, cfg)
+		if err := processEpub(input, outputPath, cfg); err != nil {
+			log.Printf("Error processing %s: %v", input, err)
+			failures = append(failures, input)
+			continue
+		}
+
+		if cfg.MarkPlaceholders {
+			log.Printf("Marked the blocks to translate of %s in %s", input, outputPath)
+			continue
+		}
+		log.Printf("Successfully translated %s to %s", input, outputPath)
+	}
+
+	log.Printf("Batch finished: %d translated, %d failed", len(inputs)-len(failures), len(failures))
+	for _, f := range failures {
+		log.Printf("  -> Failed: %s", f)
+	}
+
+	return len(failures)
+}
+
+// watchDir polls dir and translates EPUBs that appear in it. A file is only
+// picked up once its size stayed the same between two polls, so books that
+// are still being copied in aren't read half-written. It only returns if the
+// run was aborted.
+func watchDir(dir, outDir string, cfg *Config, interval time.Duration) error {
+	log.Printf("Watching %s for new EPUB files...", dir)
+
+	done := make(map[string]bool)
+	sizes := make(map[string]int64)
+
+	for {
+		inputs, err := epubsInDir(dir)
+		if err != nil {
+			log.Printf("Error reading %s: %v", dir, err)
+		}
+
+		var ready []string
+		for _, input := range inputs {
+			if done[input] {
+				continue
+			}
+
+			info, err := os.Stat(input)
+			if err != nil {
+				continue
+			}
+
+			if prev, seen := sizes[input]; seen && prev == info.Size() {
+				ready = append(ready, input)
+				done[input] = true
+				delete(sizes, input)
+			} else {
+				sizes[input] = info.Size()
+			}
+		}
+
+		if len(ready) > 0 {
+			runBatch(ready, outDir, cfg)
+		}
+		if err := cfg.Abort.get(); err != nil {
+			return err
+		}
+
+		time.Sleep(interval)
+	}
+}
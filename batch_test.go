@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestBatchFromDirectory(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	writeZip(t, dir, "one.epub", testBook(`<p>First book.</p>`))
+	writeZip(t, dir, "two.epub", testBook(`<p>Second book.</p>`))
+	writeZip(t, dir, "translated-20200101-0000-old.epub", testBook(`<p>Old output.</p>`))
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a book"), 0o644)
+
+	inputs, err := resolveInputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || filepath.Base(inputs[0]) != "one.epub" || filepath.Base(inputs[1]) != "two.epub" {
+		t.Fatalf("got inputs %q", inputs)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	os.Mkdir(outDir, 0o755)
+	if failed := runBatch(inputs, outDir, testConfig(api.URL)); failed != 0 {
+		t.Fatalf("%d books failed", failed)
+	}
+
+	outputs, _ := filepath.Glob(filepath.Join(outDir, "translated-*.epub"))
+	if len(outputs) != 2 {
+		t.Fatalf("got outputs %q", outputs)
+	}
+	for _, want := range []string{"one", "two"} {
+		found := false
+		for _, out := range outputs {
+			if strings.HasSuffix(out, "-"+want+".epub") {
+				found = true
+				text := readEntries(t, out)[chapterName(1)]
+				if !strings.Contains(text, "[T]") {
+					t.Errorf("%s is not translated:\n%s", out, text)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no output for %s.epub in %q", want, outputs)
+		}
+	}
+	if api.requested("Old output.") != 0 {
+		t.Error("translated an output of an earlier run")
+	}
+}
+
+func TestBatchContinuesPastFailures(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.epub")
+	os.WriteFile(bad, []byte("not a zip"), 0o644)
+	good := writeZip(t, dir, "good.epub", testBook(`<p>Text.</p>`))
+
+	if failed := runBatch([]string{bad, good}, dir, testConfig(api.URL)); failed != 1 {
+		t.Errorf("got %d failed books, want 1", failed)
+	}
+	if outputs, _ := filepath.Glob(filepath.Join(dir, "translated-*-good.epub")); len(outputs) != 1 {
+		t.Errorf("the good book wasn't translated after the bad one")
+	}
+}
+
+func TestSeriesSharesTermsAndCache(t *testing.T) {
+	// A model that leaves the name untranslated now and then, and whose
+	// wording differs from call to call
+	calls := 0
+	api := newStubAPI(t, func(content string) (int, string) {
+		calls++
+		if calls%2 == 1 {
+			content = strings.ReplaceAll(content, "Sandworm", "Sandwurm")
+		}
+		return 200, fmt.Sprintf("[T%d]%s", calls, content)
+	})
+	dir := t.TempDir()
+	one := writeZip(t, dir, "one.epub", testBook(`<p>The Sandworm rose.</p><p>The desert is silent.</p>`))
+	two := writeZip(t, dir, "two.epub", testBook(`<p>A Sandworm appeared.</p><p>The desert is silent.</p>`))
+
+	cfg := testConfig(api.URL)
+	cfg.Cache = newMemoryCache()
+	cfg.Glossary = Glossary{{Source: "Sandworm", Target: "Sandwurm", Hard: true}}
+	outDir := filepath.Join(dir, "out")
+	os.Mkdir(outDir, 0o755)
+	if failed := runBatch([]string{one, two}, outDir, cfg); failed != 0 {
+		t.Fatalf("%d books failed", failed)
+	}
+
+	if n := api.requested("The desert is silent."); n != 1 {
+		t.Errorf("the shared phrase was sent %d times, want once", n)
+	}
+	if prompt := api.systemPromptFor("A Sandworm"); !strings.Contains(prompt, "Sandworm -> Sandwurm") {
+		t.Errorf("the glossary isn't in the prompt of the second book: %s", prompt)
+	}
+
+	var desert []string
+	for _, name := range []string{"one", "two"} {
+		outputs, _ := filepath.Glob(filepath.Join(outDir, "translated-*-"+name+".epub"))
+		if len(outputs) != 1 {
+			t.Fatalf("got outputs %q for %s", outputs, name)
+		}
+		chapter := readEntries(t, outputs[0])[chapterName(1)]
+		if !strings.Contains(chapter, "Sandwurm") || strings.Contains(chapter, "Sandworm") {
+			t.Errorf("%s doesn't use the glossary's rendering of the name:\n%s", name, chapter)
+		}
+		desert = append(desert, regexp.MustCompile(`\[T\d+\]The desert is silent\.`).FindString(chapter))
+	}
+	if desert[0] == "" || desert[0] != desert[1] {
+		t.Errorf("the shared phrase is rendered as %q and %q", desert[0], desert[1])
+	}
+}
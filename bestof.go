@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// tagStructure returns the sequence of tags in fragment, closing tags with a
+// leading slash, e.g. [em /em br] for "Hello <em>world</em><br/>".
+func tagStructure(fragment string) []string {
+	var tags []string
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return tags
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tags = append(tags, string(name))
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tags = append(tags, "/"+string(name))
+		}
+	}
+}
+
+// pickChoice returns the translation to use from the choices of a response,
+// in the order the API returned them. It is the first choice, unless
+// -best-of asked for several: then it is the first one with the tags of
+// content in the same order, since a choice that dropped or moved markup is
+// the likeliest to be wrong, or the first if none has them.
+func pickChoice(choices []string, content string, cfg *Config) string {
+	if cfg.BestOf <= 1 || len(choices) == 1 {
+		return choices[0]
+	}
+
+	want := tagStructure(content)
+	for _, c := range choices {
+		if slices.Equal(tagStructure(c), want) {
+			return c
+		}
+	}
+	cfg.logf("  -> None of the %d choices keeps the tags of the source, using the first", len(choices))
+	return choices[0]
+}
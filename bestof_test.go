@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestBestOf(t *testing.T) {
+	body := `{"choices":[` +
+		`{"message":{"content":"Hallo Welt"}},` +
+		`{"message":{"content":"Hallo <b>Welt</b>"}},` +
+		`{"message":{"content":"Hallo <b>Erde</b>"}}]}`
+	tests := []struct {
+		bestOf int
+		want   string
+	}{
+		{1, "Hallo Welt"},
+		{3, "Hallo <b>Welt</b>"},
+	}
+	for _, tt := range tests {
+		calls := 0
+		cfg := testConfig("http://api.invalid/v1/chat/completions")
+		cfg.BestOf = tt.bestOf
+		cfg.DoRequest = fakeAPI([]fakeResponse{{status: http.StatusOK, body: body}}, &calls)
+		got, err := requestTranslation("Translate.", "Hello <b>world</b>", nil, cfg)
+		if err != nil || got != tt.want {
+			t.Errorf("-best-of %d: got %q, %v, want %q", tt.bestOf, got, err, tt.want)
+		}
+	}
+
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.BestOf = 3
+	if n := buildPayload("Translate.", "Hello", cfg)["n"]; n != 3 {
+		t.Errorf("got n %v, want 3", n)
+	}
+	if n, ok := buildPayload("Translate.", "Hello", testConfig(cfg.APIURL))["n"]; ok {
+		t.Errorf("n %v sent without -best-of", n)
+	}
+}
+
+func TestBestOfFallsBackToFirst(t *testing.T) {
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.BestOf = 2
+	if got := pickChoice([]string{"Hallo", "Hallo Welt"}, "Hello <i>world</i>", cfg); got != "Hallo" {
+		t.Errorf("got %q, want the first choice when none keeps the tags", got)
+	}
+}
+
+func TestEmptyChoices(t *testing.T) {
+	calls := 0
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.DoRequest = fakeAPI([]fakeResponse{{status: http.StatusOK, body: `{"choices":[]}`}}, &calls)
+	if got, err := requestTranslation("Translate.", "Hello", nil, cfg); got != "" || !errors.Is(err, ErrTranslation) {
+		t.Errorf("got %q, %v, want ErrTranslation", got, err)
+	}
+}
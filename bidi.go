@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	lrm = '\u200e' // left-to-right mark
+	rlm = '\u200f' // right-to-left mark
+)
+
+// sentencePunctuation ends a sentence or clause rather than a word.
+const sentencePunctuation = ".,;:!?…،؛؟"
+
+// isRTLRune reports whether r is a strong right-to-left character.
+func isRTLRune(r rune) bool {
+	return unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko)
+}
+
+func isLTRRune(r rune) bool {
+	return unicode.IsLetter(r) && !isRTLRune(r)
+}
+
+// isTokenSymbol reports whether r is a neutral character that, written right
+// after a Latin word, belongs to it: the "++" of "C++" or the "#" of "C#".
+// Brackets, quotes and sentence punctuation take the direction of the
+// surrounding text and are left alone.
+func isTokenSymbol(r rune) bool {
+	if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) {
+		return false
+	}
+	if unicode.In(r, unicode.Ps, unicode.Pe, unicode.Pi, unicode.Pf) || strings.ContainsRune(sentencePunctuation+"\"'", r) {
+		return false
+	}
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// bidiPosition is a rune of the text of a block, located in its text node.
+type bidiPosition struct {
+	node  int
+	start int // byte offsets in the node's text
+	end   int
+	r     rune
+}
+
+// fixBidi adds directional marks to the translated block n where the
+// bidirectional algorithm of a reading system would otherwise get the order
+// of mixed right-to-left and Latin text wrong:
+//
+//   - a block that starts with a Latin word, but is right-to-left text,
+//     gets an RLM in front, so it isn't laid out left to right as a whole;
+//   - symbols attached to the end of a Latin word ("C++", "C#") get an LRM
+//     after them, so they stay on the word's right instead of jumping to its
+//     left.
+//
+// Code is left untouched.
+func fixBidi(n *html.Node) {
+	var nodes []*html.Node
+	var text []bidiPosition
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				for off, r := range c.Data {
+					text = append(text, bidiPosition{len(nodes), off, off + len(string(r)), r})
+				}
+				nodes = append(nodes, c)
+			case c.Type == html.ElementNode && (c.Data == "code" || c.Data == "pre"):
+			case c.Type == html.ElementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+
+	type insertion struct {
+		node, offset int
+		mark         rune
+	}
+	var insertions []insertion
+
+	hasRTL := false
+	for _, p := range text {
+		if isRTLRune(p.r) {
+			hasRTL = true
+			break
+		}
+	}
+	if !hasRTL {
+		return
+	}
+
+	for _, p := range text {
+		if isRTLRune(p.r) || p.r == rlm {
+			break
+		}
+		if isLTRRune(p.r) {
+			insertions = append(insertions, insertion{p.node, p.start, rlm})
+			break
+		}
+	}
+
+	for i := 1; i < len(text); i++ {
+		if !isLTRRune(text[i-1].r) || !isTokenSymbol(text[i].r) {
+			continue
+		}
+		k := i
+		for k < len(text) && isTokenSymbol(text[k].r) {
+			k++
+		}
+		if k == len(text) || unicode.IsSpace(text[k].r) || isRTLRune(text[k].r) || strings.ContainsRune(sentencePunctuation, text[k].r) {
+			last := text[k-1]
+			insertions = append(insertions, insertion{last.node, last.end, lrm})
+		}
+		i = k
+	}
+
+	// Insert back to front so earlier offsets stay valid
+	sort.Slice(insertions, func(a, b int) bool {
+		if insertions[a].node != insertions[b].node {
+			return insertions[a].node > insertions[b].node
+		}
+		return insertions[a].offset > insertions[b].offset
+	})
+	for _, ins := range insertions {
+		t := nodes[ins.node]
+		t.Data = t.Data[:ins.offset] + string(ins.mark) + t.Data[ins.offset:]
+	}
+}
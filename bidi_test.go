@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBidiFixup(t *testing.T) {
+	const salam = "\u0645\u0631\u062d\u0628\u0627" // an Arabic word
+	lrm, rlm := string(lrm), string(rlm)
+	tests := []struct {
+		source, reply, want string
+	}{
+		// Starts with a Latin word, but the block is right to left
+		{"Uses C++ a lot.", "C++ " + salam + ".", rlm + "C++" + lrm + " " + salam + "."},
+		{"Written in C# today.", salam + " C# " + salam, salam + " C#" + lrm + " " + salam},
+		{"Symbols at the end C++", salam + " <em>C++</em>", salam + " <em>C++" + lrm + "</em>"},
+		// Brackets, numbers and sentence punctuation are left to the reader
+		{"In brackets (Go) since 2024.", salam + " (Go) 2024.", salam + " (Go) 2024."},
+		{"Code is left alone.", salam + " <code>i++</code>", salam + " <code>i++</code>"},
+		// Without right-to-left text there is nothing to fix
+		{"Latin only C++", "Hello C++", "Hello C++"},
+	}
+
+	replies := make(map[string]string)
+	var body strings.Builder
+	for _, tt := range tests {
+		replies[tt.source] = tt.reply
+		body.WriteString("<p>" + tt.source + "</p>")
+	}
+	api := newStubAPI(t, func(content string) (int, string) {
+		if reply, ok := replies[content]; ok {
+			return http.StatusOK, reply
+		}
+		return prefixReply(content)
+	})
+	cfg := testConfig(api.URL)
+	cfg.TargetLang = "Arabic"
+	cfg.BidiFixup = true
+	out, err := translate(t, testBook(body.String()), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, tt := range tests {
+		if !strings.Contains(chapter, "<p>"+tt.want+"</p>") {
+			t.Errorf("%s: chapter lacks %q", tt.source, "<p>"+tt.want+"</p>")
+		}
+	}
+	if t.Failed() {
+		t.Logf("chapter: %q", chapter)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// bilingualPairs finds the blocks of already bilingual content: the elements
+// with the -original-class and the translation next to each, the following
+// element unless that is an original too. With -drop-originals, the originals are
+// removed from the document and only the translations are kept.
+func bilingualPairs(doc *goquery.Document, cfg *Config) (kept map[*html.Node]bool, originals int) {
+	if cfg.OriginalClass == "" {
+		return nil, 0
+	}
+	isOriginal := func(n *html.Node) bool {
+		return n != nil && n.Type == html.ElementNode && hasClass(n, cfg.OriginalClass)
+	}
+
+	kept = make(map[*html.Node]bool)
+	var dropped []*html.Node
+	doc.Find("." + cfg.OriginalClass).Each(func(i int, s *goquery.Selection) {
+		n := s.Get(0)
+		if hasSelectedAncestor(n, kept) {
+			return
+		}
+		originals++
+		if next := nextElement(n); next != nil && !isOriginal(next) {
+			kept[next] = true
+		}
+		if cfg.DropOriginals {
+			dropped = append(dropped, n)
+		} else {
+			kept[n] = true
+		}
+	})
+	for _, n := range dropped {
+		n.Parent.RemoveChild(n)
+	}
+	return kept, originals
+}
+
+// skipBilingual leaves out the blocks of selection that are, are inside or
+// contain a block kept by bilingualPairs, so they aren't translated a second
+// time.
+func skipBilingual(selection *goquery.Selection, kept map[*html.Node]bool) *goquery.Selection {
+	if len(kept) == 0 {
+		return selection
+	}
+	return selection.FilterFunction(func(i int, s *goquery.Selection) bool {
+		n := s.Get(0)
+		return !kept[n] && !hasSelectedAncestor(n, kept) && !containsSelected(n, kept)
+	})
+}
+
+func nextElement(n *html.Node) *html.Node {
+	for s := n.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, a := range n.Attr {
+		if a.Key == "class" {
+			for _, c := range strings.Fields(a.Val) {
+				if c == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBilingualSource(t *testing.T) {
+	body := func(class string) string {
+		return `<p class="` + class + `">Hello.</p><p>Hallo.</p>` +
+			`<div class="` + class + `"><p>Good <em>bye</em>.</p></div><div><p>Auf <em>Wiedersehen</em>.</p></div>` +
+			`<p>A new paragraph.</p>`
+	}
+
+	tests := []struct {
+		name, class, configured string
+		drop                    bool
+		want                    []string
+		requested               int
+	}{
+		{"kept", "original", "original", false, []string{
+			`<p class="original">Hello.</p><p>Hallo.</p>`,
+			`<div class="original"><p>Good <em>bye</em>.</p></div><div><p>Auf <em>Wiedersehen</em>.</p></div>`,
+			`<p>[T]A new paragraph.</p>`,
+		}, 1},
+		{"custom class", "source-text", "source-text", false, []string{
+			`<p class="source-text">Hello.</p><p>Hallo.</p>`,
+			`<p>[T]A new paragraph.</p>`,
+		}, 1},
+		{"dropped", "original", "original", true, []string{
+			`<body><p>Hallo.</p><div><p>Auf <em>Wiedersehen</em>.</p></div><p>[T]A new paragraph.</p>`,
+		}, 1},
+		{"no detection", "original", "", false, []string{
+			`<p class="original">[T]Hello.</p><p>[T]Hallo.</p>`,
+		}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStubAPI(t, nil)
+			cfg := testConfig(api.URL)
+			cfg.OriginalClass = tt.configured
+			cfg.DropOriginals = tt.drop
+			out, err := translate(t, testBook(body(tt.class)), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chapter := out[chapterName(1)]
+			for _, want := range tt.want {
+				if !strings.Contains(chapter, want) {
+					t.Errorf("chapter lacks %s:\n%s", want, chapter)
+				}
+			}
+			n := 0
+			for _, text := range []string{"Hello.", "Hallo.", "bye", "Wiedersehen", "A new paragraph."} {
+				n += api.requested(text)
+			}
+			if n != tt.requested {
+				t.Errorf("sent %d blocks of the chapter, want %d: %q", n, tt.requested, api.requests())
+			}
+		})
+	}
+}
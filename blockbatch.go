@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// batchPrompt is added to the system prompt when several blocks are sent in
+// one request.
+const batchPrompt = ` The input consists of several independent blocks, each wrapped in an <x-block id="N"> element. Translate the content of every block and return all blocks in the same order, each in its wrapper with the id unchanged.`
+
+var batchBlockPattern = regexp.MustCompile(`(?s)<x-block id="(\d+)">(.*?)</x-block>`)
+
+// batchItem is a block waiting in a batch. Blocks of different files can
+// share a batch (-merge-small-files), so each carries its file and settings.
+type batchItem struct {
+	sel     *goquery.Selection
+	content string
+	key     string
+	file    string
+	cfg     *Config
+}
+
+// translateBatched translates the selected blocks, sending as many as fit
+// into cfg.BatchTokenBudget estimated tokens in one request, so short blocks
+// share requests while long ones still go on their own. Blocks that need
+// their own prompt (figure context, index terms, media and translate="no"
+// placeholders, -tokenize-tags), that
+// are cached or found in an imported memory, and all blocks with
+// -pivot-lang take the normal per-node path, as does every block of a batch
+// whose response doesn't match it.
+func translateBatched(selection *goquery.Selection, cfg *Config) []blockFailure {
+	b := &batcher{budget: cfg.BatchTokenBudget}
+	b.add(selection, "", cfg)
+	b.flush()
+	return b.failures
+}
+
+// batcher collects blocks into batches of up to budget estimated tokens.
+type batcher struct {
+	budget   int
+	pending  []batchItem
+	tokens   int
+	failures []blockFailure
+}
+
+// add queues the blocks of selection, which belong to file, sending a batch
+// whenever the next block doesn't fit.
+func (b *batcher) add(selection *goquery.Selection, file string, cfg *Config) {
+	selection.Each(func(i int, s *goquery.Selection) {
+		item, ok := batchable(s, cfg)
+		if !ok {
+			// A session needs the blocks before it translated first
+			if cfg.Session != nil {
+				b.flush()
+			}
+			if f := translateBlock(s, cfg); f != nil {
+				f.File = file
+				b.failures = append(b.failures, *f)
+			}
+			return
+		}
+		item.file, item.cfg = file, cfg
+
+		size := estimateTokens(item.content)
+		if b.tokens+size > b.budget || len(b.pending) > 0 && b.pending[0].cfg.Model != cfg.Model {
+			b.flush()
+		}
+		b.pending = append(b.pending, item)
+		b.tokens += size
+	})
+}
+
+func (b *batcher) flush() {
+	if len(b.pending) > 0 {
+		b.failures = append(b.failures, translateBatch(b.pending)...)
+	}
+	b.pending, b.tokens = nil, 0
+}
+
+// batchable prepares s for a batch, or reports that it has to be translated
+// on its own.
+func batchable(s *goquery.Selection, cfg *Config) (batchItem, bool) {
+	if !hasTranslatableText(s, cfg) || cfg.PivotLang != "" {
+		return batchItem{}, false
+	}
+	if cfg.Session != nil && s.Is(captionSelector) {
+		return batchItem{}, false
+	}
+	if cfg.FigureContext && goquery.NodeName(s) == "figcaption" {
+		return batchItem{}, false
+	}
+	if cfg.TranslateIndex && insideIndex(s.Get(0)) {
+		return batchItem{}, false
+	}
+	if cfg.KeepMediaStructure && containsMedia(s.Get(0)) || containsNoTranslate(s.Get(0)) {
+		return batchItem{}, false
+	}
+	if cfg.TokenizeTags && hasChildElements(s.Get(0)) {
+		return batchItem{}, false
+	}
+
+	inner, err := s.Html()
+	if err != nil {
+		return batchItem{}, false
+	}
+	content := encodeNbsp(inner)
+
+	// Cached under the same key as a single block, so batching doesn't
+	// invalidate earlier runs
+	key := cacheKey(content, cfg.TargetLang, cfg.Model, blockPrompt(content, "", cfg)+cfg.Examples.cacheSuffix())
+	if cfg.Cache != nil {
+		if _, ok := cfg.Cache.Get(key); ok {
+			return batchItem{}, false
+		}
+	}
+	if _, ok := cfg.ImportedMemory[content]; ok {
+		return batchItem{}, false
+	}
+
+	return batchItem{sel: s, content: content, key: key}, true
+}
+
+// translateBatch sends the items in one request, with the settings of the
+// first. A batch of one is just a block.
+func translateBatch(items []batchItem) []blockFailure {
+	cfg := items[0].cfg
+	if len(items) == 1 {
+		return perNode(items)
+	}
+
+	var b, all strings.Builder
+	for i, item := range items {
+		fmt.Fprintf(&b, "<x-block id=\"%d\">%s</x-block>\n", i+1, item.content)
+		all.WriteString(item.content)
+		all.WriteString("\n")
+	}
+
+	systemPrompt := blockPrompt(all.String(), "", cfg) + batchPrompt
+	if cfg.Audit != nil {
+		blocks := make([]AuditBlock, len(items))
+		for i, item := range items {
+			blocks[i] = AuditBlock{File: item.cfg.AuditFile, Block: nodePath(item.sel.Get(0))}
+		}
+		cfg = cfg.auditBlocks(blocks...)
+	}
+	response, err := requestTranslation(systemPrompt, b.String(), nil, cfg)
+	if err != nil {
+		failures := make([]blockFailure, 0, len(items))
+		for _, item := range items {
+			item.sel.SetHtml(item.content + failureMarker)
+			failures = append(failures, blockFailure{File: item.file, Path: nodePath(item.sel.Get(0)), Err: err})
+		}
+		return failures
+	}
+
+	translations, err := splitBatch(response, len(items))
+	if err != nil {
+		cfg.logf("  -> Batch of %d blocks didn't match the response (%v), translating them one by one", len(items), err)
+		return perNode(items)
+	}
+
+	var retry []batchItem
+	for i, item := range items {
+		translated := item.cfg.restrictTags(item.content, translations[i])
+		if err := checkFragment(item.content, translated); err != nil {
+			retry = append(retry, item)
+			continue
+		}
+
+		if cfg.Cache != nil {
+			cfg.Cache.Put(item.key, translated)
+		}
+		item.cfg.remember(item.content, translated)
+		checkLength(item.sel, item.content, translated, item.cfg)
+		translated = item.cfg.Glossary.enforce(translated, item.cfg.GlossaryEnforce)
+		if item.cfg.QuoteStyle != nil {
+			translated = localizeQuotes(translated, *item.cfg.QuoteStyle)
+		}
+		translated = item.cfg.Replacements.apply(translated)
+		quoted := hasQuotedQ(item.sel.Get(0))
+		item.sel.SetHtml(translated)
+		if !quoted {
+			unquoteQ(item.sel.Get(0))
+		}
+		if item.cfg.NormalizeWhitespace {
+			normalizeWhitespace(item.sel.Get(0))
+		}
+		if item.cfg.BidiFixup {
+			fixBidi(item.sel.Get(0))
+		}
+	}
+
+	return perNode(retry)
+}
+
+func perNode(items []batchItem) []blockFailure {
+	var failures []blockFailure
+	for _, item := range items {
+		if f := translateBlock(item.sel, item.cfg); f != nil {
+			f.File = item.file
+			failures = append(failures, *f)
+		}
+	}
+	return failures
+}
+
+// splitBatch extracts the translated blocks from a batch response. Every id
+// has to appear once and in order.
+func splitBatch(response string, n int) ([]string, error) {
+	matches := batchBlockPattern.FindAllStringSubmatch(response, -1)
+	if len(matches) != n {
+		return nil, fmt.Errorf("expected %d blocks, got %d", n, len(matches))
+	}
+
+	translations := make([]string, n)
+	for i, m := range matches {
+		if m[1] != fmt.Sprint(i+1) {
+			return nil, fmt.Errorf("block %d has id %s", i+1, m[1])
+		}
+		translations[i] = strings.TrimSpace(m[2])
+	}
+	return translations, nil
+}
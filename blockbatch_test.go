@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var stubBlockPattern = regexp.MustCompile(`(<x-block id="\d+">)`)
+
+// batchReply translates every block of a batch like prefixReply.
+func batchReply(content string) (int, string) {
+	if strings.Contains(content, "<x-block") {
+		return 200, stubBlockPattern.ReplaceAllString(content, "$1[T]")
+	}
+	return prefixReply(content)
+}
+
+func TestBatchTokenBudget(t *testing.T) {
+	var short, long strings.Builder
+	for i := range 12 {
+		fmt.Fprintf(&short, "<p>Short %d.</p>", i)
+		fmt.Fprintf(&long, "<p>Long %d: %s</p>", i, strings.Repeat("many words in a long paragraph ", 6))
+	}
+
+	requestsPerBlock := func(body string) float64 {
+		t.Helper()
+		api := newStubAPI(t, batchReply)
+		cfg := testConfig(api.URL)
+		cfg.BatchTokenBudget = 200
+		out, err := translate(t, testBook(body), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(out[chapterName(1)], "<p>[T]"); n != 12 {
+			t.Fatalf("%d of 12 blocks translated:\n%s", n, out[chapterName(1)])
+		}
+		sent := 0
+		for _, c := range api.requests() {
+			if strings.Contains(c, "Short") || strings.Contains(c, "Long") {
+				sent++
+			}
+		}
+		return float64(sent) / 12
+	}
+
+	shortRate, longRate := requestsPerBlock(short.String()), requestsPerBlock(long.String())
+	if shortRate > 0.25 {
+		t.Errorf("short blocks took %.2f requests each, want them batched", shortRate)
+	}
+	if longRate <= 2*shortRate {
+		t.Errorf("long blocks took %.2f requests each, short ones %.2f", longRate, shortRate)
+	}
+}
+
+func TestBatchMismatchFallsBackToSingleBlocks(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		if strings.Contains(content, "<x-block") {
+			return 200, `<x-block id="1">Nur einer</x-block>`
+		}
+		return prefixReply(content)
+	})
+	cfg := testConfig(api.URL)
+	cfg.BatchTokenBudget = 200
+	out, err := translate(t, testBook(`<p>One.</p><p>Two.</p><p>Three.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<p>[T]One.</p>", "<p>[T]Two.</p>", "<p>[T]Three.</p>"} {
+		if !strings.Contains(out[chapterName(1)], want) {
+			t.Errorf("got:\n%s\nwant %s", out[chapterName(1)], want)
+		}
+	}
+	if api.requested("<x-block") != 1 {
+		t.Errorf("got %d batch requests, want 1", api.requested("<x-block"))
+	}
+}
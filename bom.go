@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Values of -bom.
+const (
+	bomStrip    = "strip"
+	bomPreserve = "preserve"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func validateBOM(mode string) error {
+	switch mode {
+	case bomStrip, bomPreserve:
+		return nil
+	}
+	return fmt.Errorf("unknown BOM mode %q, expected strip or preserve", mode)
+}
+
+// stripBOM removes a leading UTF-8 byte order mark. Left in, the parser
+// treats it as text and it ends up inside the body of the output.
+func stripBOM(data []byte) ([]byte, bool) {
+	if bytes.HasPrefix(data, utf8BOM) {
+		return data[len(utf8BOM):], true
+	}
+	return data, false
+}
+
+// restoreBOM puts the byte order mark back in front of a translated file
+// whose source had one, if mode is "preserve".
+func restoreBOM(data []byte, hadBOM bool, mode string) []byte {
+	if !hadBOM || mode != bomPreserve || bytes.HasPrefix(data, utf8BOM) {
+		return data
+	}
+	return append(append([]byte{}, utf8BOM...), data...)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestBOM(t *testing.T) {
+	const bom = "\ufeff"
+	book := replaceEntry(testBook(`<p>Text.</p>`), chapterName(1), bom+xhtml(`<p>Text.</p>`))
+
+	for _, mode := range []string{bomStrip, bomPreserve} {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.BOM = mode
+		out, err := translate(t, book, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chapter := out[chapterName(1)]
+		if got := strings.HasPrefix(chapter, bom); got != (mode == bomPreserve) {
+			t.Errorf("-bom %s: output starts with a BOM: %v", mode, got)
+		}
+		if strings.Count(chapter, bom) > 1 || !strings.HasPrefix(chapter, bom) && strings.Contains(chapter, bom) {
+			t.Errorf("-bom %s: stray BOM in the output:\n%q", mode, chapter)
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(strings.TrimPrefix(chapter, bom)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := doc.Find("body").Text(); body != "[T]Text." {
+			t.Errorf("-bom %s: got body text %q, want %q", mode, body, "[T]Text.")
+		}
+		if api.requested(bom) != 0 {
+			t.Errorf("-bom %s: the BOM was sent to the API", mode)
+		}
+	}
+}
+
+func TestValidateBOM(t *testing.T) {
+	for _, mode := range []string{bomStrip, bomPreserve} {
+		if err := validateBOM(mode); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+	if err := validateBOM("keep"); err == nil {
+		t.Error("no error for an unknown mode")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cache is a persistent map from block hash to translated HTML. It is keyed by
+// content rather than by file or position, so editing one chapter only
+// re-translates the blocks that actually changed.
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]string
+	dirty   bool
+}
+
+// newMemoryCache returns a cache that is never written to disk.
+func newMemoryCache() *Cache {
+	return &Cache{entries: make(map[string]string)}
+}
+
+// loadCache reads the cache at path. A missing file yields an empty cache.
+func loadCache(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.entries[key]
+	return v, ok
+}
+
+func (c *Cache) Put(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = value
+	c.dirty = true
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Save writes the cache to disk if it changed. The file is replaced atomically
+// so an interrupted run never leaves a truncated cache behind.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty || c.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// cacheKey hashes everything that influences a translation. Whitespace in the
+// source is normalized so reformatting a file doesn't invalidate its blocks,
+// while any change to the prompt does.
+func cacheKey(sourceHTML, targetLang, model, prompt string) string {
+	h := sha256.New()
+	for _, part := range []string{strings.Join(strings.Fields(sourceHTML), " "), targetLang, model, prompt} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
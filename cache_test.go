@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheServesUnchangedBlocks(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+	run := func(body, tone string) map[string]string {
+		t.Helper()
+		cache, err := loadCache(cachePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := testConfig(api.URL)
+		cfg.Cache = cache
+		cfg.Tone = tone
+		out, err := translate(t, testBook(body), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	first := run(`<p>Unchanged block.</p><p>Edited block.</p>`, "")
+	sent := len(api.requests())
+
+	// Reflowing a block doesn't make it a different one
+	second := run("<p>Unchanged\n  block.</p><p>Edited block, now longer.</p>", "")
+	if got := api.requests()[sent:]; len(got) != 1 || got[0] != "Edited block, now longer." {
+		t.Errorf("re-run sent %q, want only the edited block", got)
+	}
+	if first["OEBPS/toc.ncx"] != second["OEBPS/toc.ncx"] {
+		t.Errorf("the NCX changed between the runs")
+	}
+	sent = len(api.requests())
+
+	// A different prompt invalidates the cached translations
+	run(`<p>Unchanged block.</p>`, "formal")
+	if api.requested("Unchanged block.") != 2 {
+		t.Errorf("a changed prompt was served from the cache: %q", api.requests()[sent:])
+	}
+}
+
+func TestConcurrentRepeatedBlockRequestedOnce(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		// Long enough for every worker to ask for the block meanwhile
+		time.Sleep(200 * time.Millisecond)
+		return prefixReply(content)
+	})
+	cfg := testConfig(api.URL)
+	cfg.Cache = newMemoryCache()
+
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			translated, err := translateNode("A running header", "", cfg)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = translated
+		}()
+	}
+	wg.Wait()
+
+	if n := len(api.requests()); n != 1 {
+		t.Errorf("got %d API calls for the same block, want 1", n)
+	}
+	for i, r := range results {
+		if r != "[T]A running header" {
+			t.Errorf("worker %d got %q", i, r)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkpointPath is where -checkpoint-every keeps the partial translation of
+// the book written to outputPath.
+func checkpointPath(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + ".partial" + ext
+}
+
+// checkpointEntry is an entry written to the output since the last save:
+// either its data, or the input file it was copied from.
+type checkpointEntry struct {
+	name string
+	data []byte
+	file *zip.File
+}
+
+// checkpoint remembers what was written to the output so that, every few
+// translated files, the book so far can be saved as a complete EPUB of its
+// own: the files done, followed by the rest as they are in the input. Its
+// manifest lists the translated sources, so a later run started with it as
+// -reference only translates the others.
+//
+// Only the entries written since the last save are kept; those before are
+// copied from the previous checkpoint, where they are the first done
+// entries, so a large book isn't held in memory until the end of the run.
+type checkpoint struct {
+	path    string
+	every   int
+	entries []checkpointEntry
+	done    int // entries of the files done at the front of the saved checkpoint
+	pending int // translated files since the last save
+	saved   bool
+}
+
+// newCheckpoint returns the checkpoint of outputPath, or nil if every isn't
+// positive. All methods can be called on nil.
+func newCheckpoint(outputPath string, every int) *checkpoint {
+	if every <= 0 {
+		return nil
+	}
+	return &checkpoint{path: checkpointPath(outputPath), every: every}
+}
+
+// add records an entry written with data.
+func (c *checkpoint) add(name string, data []byte) {
+	if c != nil {
+		c.entries = append(c.entries, checkpointEntry{name: name, data: data})
+	}
+}
+
+// addCopy records an input file that was copied without translating it.
+func (c *checkpoint) addCopy(file *zip.File) {
+	if c != nil {
+		c.entries = append(c.entries, checkpointEntry{file: file})
+	}
+}
+
+// translated counts a translated file and reports whether it's time to save.
+func (c *checkpoint) translated() bool {
+	if c == nil {
+		return false
+	}
+	c.pending++
+	return c.pending >= c.every
+}
+
+// save writes the checkpoint: the files done of the previous one, then the
+// entries recorded since, then rest written by copyEntry, then manifest. It
+// goes through a temporary file, so the checkpoint on disk is always a
+// complete EPUB.
+func (c *checkpoint) save(rest []*zip.File, copyEntry func(*zip.File, *entryWriter) error, manifest translatorManifest, cfg *Config) error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".checkpoint-*.epub")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := newEntryWriter(tmp, cfg)
+	if err := c.copyDone(writer); err != nil {
+		return err
+	}
+	for _, e := range c.entries {
+		if e.file != nil {
+			err = copyEntry(e.file, writer)
+		} else {
+			err = writeEntry(writer, e.name, e.data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	done := c.done + writer.created
+	for _, file := range rest {
+		if file.Name == translatorManifestName {
+			continue
+		}
+		if err := copyEntry(file, writer); err != nil {
+			return err
+		}
+	}
+	if err := writeTranslatorManifest(writer, manifest); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("could not replace %s: %w", c.path, err)
+	}
+	c.entries = nil
+	c.done = done
+	c.pending = 0
+	c.saved = true
+	return nil
+}
+
+// copyDone copies the entries of the files done from the saved checkpoint,
+// as they are stored there.
+func (c *checkpoint) copyDone(w *entryWriter) error {
+	if c.done == 0 {
+		return nil
+	}
+	previous, err := zip.OpenReader(c.path)
+	if err != nil {
+		return fmt.Errorf("could not read the previous checkpoint: %w", err)
+	}
+	defer previous.Close()
+	if len(previous.File) < c.done {
+		return fmt.Errorf("the previous checkpoint %s has %d entries, expected at least %d", c.path, len(previous.File), c.done)
+	}
+	for _, file := range previous.File[:c.done] {
+		if err := w.Copy(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the checkpoint once the output is complete.
+func (c *checkpoint) remove() {
+	if c != nil && c.saved {
+		os.Remove(c.path)
+	}
+}
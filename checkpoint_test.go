@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointEvery(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.epub")
+	partial := checkpointPath(output)
+	if partial != filepath.Join(dir, "out.partial.epub") {
+		t.Fatalf("got checkpoint path %s", partial)
+	}
+
+	// The last chapter waits for the checkpoint with the first two, and takes
+	// a copy of it as a crash would leave it
+	var snapshot []byte
+	api := newStubAPI(t, func(content string) (int, string) {
+		if strings.Contains(content, "Third") {
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if data, err := os.ReadFile(partial); err == nil && hasTranslated(data, chapterName(2)) {
+					snapshot = data
+					break
+				}
+			}
+		}
+		return prefixReply(content)
+	})
+
+	cfg := testConfig(api.URL)
+	cfg.CheckpointEvery = 1
+	input := writeZip(t, dir, "book.epub", testBook(`<p>First.</p>`, `<p>Second.</p>`, `<p>Third.</p>`))
+	if err := processEpub(input, output, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == nil {
+		t.Fatal("no checkpoint with the first two chapters was saved before the third was done")
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("the checkpoint is left after the run finished: %v", err)
+	}
+
+	path := filepath.Join(dir, "snapshot.epub")
+	os.WriteFile(path, snapshot, 0o644)
+	if names := entryNames(t, path); names[0] != "mimetype" {
+		t.Errorf("mimetype isn't the first entry of the checkpoint: %q", names)
+	}
+	entries := readEntries(t, path)
+	for _, name := range []string{"mimetype", "META-INF/container.xml", "OEBPS/content.opf", "OEBPS/img/a.png"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("checkpoint lacks %s", name)
+		}
+	}
+	if !strings.Contains(entries[chapterName(1)], "<p>[T]First.</p>") {
+		t.Errorf("checkpoint lacks the translated first chapter:\n%s", entries[chapterName(1)])
+	}
+	if entries[chapterName(3)] != xhtml(`<p>Third.</p>`) {
+		t.Errorf("the chapter not done yet isn't the original:\n%s", entries[chapterName(3)])
+	}
+
+	var manifest translatorManifest
+	if err := json.Unmarshal([]byte(entries[translatorManifestName]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manifest.Sources[chapterName(2)]; !ok {
+		t.Errorf("the checkpoint's manifest doesn't list the translated chapters: %v", manifest.Sources)
+	}
+	if _, ok := manifest.Sources[chapterName(3)]; ok {
+		t.Errorf("the checkpoint's manifest lists the chapter not done yet: %v", manifest.Sources)
+	}
+}
+
+// hasTranslated reports whether the entry name of the zip file data has
+// been translated.
+func hasTranslated(data []byte, name string) bool {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	f := findZipFile(r.File, name)
+	if f == nil {
+		return false
+	}
+	content, err := readZipFile(f)
+	return err == nil && strings.Contains(string(content), "[T]")
+}
+
+func TestCheckpointKeepsOnlyUnsavedEntries(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	c := newCheckpoint(filepath.Join(dir, "out.epub"), 1)
+	copyEntry := func(file *zip.File, w *entryWriter) error { return copyFile(file, w) }
+	manifest := translatorManifest{Sources: map[string]string{}}
+
+	c.add("mimetype", []byte("application/epub+zip"))
+	c.add(chapterName(1), []byte("first"))
+	if err := c.save(nil, copyEntry, manifest, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 || c.done != 2 {
+		t.Fatalf("after saving, %d entries are kept and %d counted as done, want 0 and 2", len(c.entries), c.done)
+	}
+
+	c.add(chapterName(2), []byte("second"))
+	if err := c.save(nil, copyEntry, manifest, cfg); err != nil {
+		t.Fatal(err)
+	}
+	names := entryNames(t, c.path)
+	if want := []string{"mimetype", chapterName(1), chapterName(2), translatorManifestName}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("got entries %q, want %q", names, want)
+	}
+	entries := readEntries(t, c.path)
+	if entries[chapterName(1)] != "first" || entries[chapterName(2)] != "second" {
+		t.Errorf("the chapters aren't kept across saves: %q, %q", entries[chapterName(1)], entries[chapterName(2)])
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+)
+
+// newHTTPClient builds the client shared by all requests of a run. maxConns
+// caps the connections to the API host independently of -concurrency:
+// workers beyond the cap wait for a free connection instead of opening a new
+// one. Zero keeps Go's defaults (no cap).
+func newHTTPClient(maxConns int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if maxConns > 0 {
+		transport.MaxConnsPerHost = maxConns
+		transport.MaxIdleConnsPerHost = maxConns
+	}
+	return &http.Client{Transport: transport}
+}
+
+// doRequest sends an API call with cfg.DoRequest, or else cfg.HTTPClient.
+func (cfg *Config) doRequest(req *http.Request) (*http.Response, error) {
+	if cfg.DoRequest != nil {
+		return cfg.DoRequest(req)
+	}
+	return cfg.HTTPClient.Do(req)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientMaxConns(t *testing.T) {
+	transport := newHTTPClient(3).Transport.(*http.Transport)
+	if transport.MaxConnsPerHost != 3 || transport.MaxIdleConnsPerHost != 3 {
+		t.Errorf("got MaxConnsPerHost %d, MaxIdleConnsPerHost %d, want 3", transport.MaxConnsPerHost, transport.MaxIdleConnsPerHost)
+	}
+	if transport == http.DefaultTransport {
+		t.Error("the default transport was changed")
+	}
+	if unlimited := newHTTPClient(0).Transport.(*http.Transport); unlimited.MaxConnsPerHost != 0 {
+		t.Errorf("got MaxConnsPerHost %d without a limit", unlimited.MaxConnsPerHost)
+	}
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	client := newHTTPClient(2)
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("%d requests were served at once, the limit is 2", peak)
+	}
+}
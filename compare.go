@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// errNotCached is the failure of a block with -compare when the cache has no
+// translation for it.
+var errNotCached = errors.New("not in the cache")
+
+// compareEpub reports the blocks whose translation from the cache differs
+// from the block in existingPath, a previous translation of inputPath, and
+// the blocks the cache has no translation for. Nothing is requested from the
+// API and nothing is written. It returns the number of differing blocks.
+func compareEpub(inputPath, existingPath string, cfg *Config, out io.Writer) (int, error) {
+	reader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return 0, fmt.Errorf("could not open input epub: %w: %w", ErrInvalidEpub, err)
+	}
+	defer reader.Close()
+
+	existing, err := zip.OpenReader(existingPath)
+	if err != nil {
+		return 0, fmt.Errorf("could not open %s: %w: %w", existingPath, ErrInvalidEpub, err)
+	}
+	defer existing.Close()
+
+	compareCfg := *cfg
+	compareCfg.CacheOnly = true
+	compareCfg.ExportMemory = nil
+	compareCfg.Stats = nil
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tBLOCK\tSTATUS")
+
+	same, differ, uncached, missing := 0, 0, 0, 0
+	for _, file := range safeEntries(reader.File) {
+		if !isTranslatable(file.Name) {
+			continue
+		}
+
+		other := findZipFile(existing.File, file.Name)
+		if other == nil {
+			fmt.Fprintf(tw, "%s\t-\tmissing in %s\n", file.Name, existingPath)
+			continue
+		}
+
+		source, err := readZipFile(file)
+		if err != nil {
+			return differ, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+		}
+		previous, err := readZipFile(other)
+		if err != nil {
+			return differ, fmt.Errorf("could not read %s from %s: %w", other.Name, existingPath, err)
+		}
+		prevDoc, err := goquery.NewDocumentFromReader(bytes.NewReader(previous))
+		if err != nil {
+			return differ, fmt.Errorf("error processing file %s: %w", other.Name, err)
+		}
+
+		compareCfg.logf("Comparing %s...", file.Name)
+		_, selection, err := parseHTML(source, &compareCfg)
+		if err != nil {
+			return differ, fmt.Errorf("error processing file %s: %w", file.Name, err)
+		}
+
+		selection.Each(func(i int, s *goquery.Selection) {
+			if !hasTranslatableText(s, cfg) {
+				return
+			}
+			path := nodePath(s.Get(0))
+
+			if f := translateBlock(s, &compareCfg); f != nil {
+				if errors.Is(f.Err, errNotCached) {
+					uncached++
+					fmt.Fprintf(tw, "%s\t%s\tnot cached\n", file.Name, path)
+				}
+				return
+			}
+			translated, _ := s.Html()
+
+			n := findNodeByPath(prevDoc.Get(0), path)
+			if n == nil {
+				missing++
+				fmt.Fprintf(tw, "%s\t%s\tmissing in %s\n", file.Name, path, existingPath)
+				return
+			}
+			before, _ := goquery.NewDocumentFromNode(n).Html()
+
+			if sameBlock(before, translated) {
+				same++
+				return
+			}
+			differ++
+			fmt.Fprintf(tw, "%s\t%s\tdiffers\n", file.Name, path)
+		})
+	}
+
+	if err := tw.Flush(); err != nil {
+		return differ, err
+	}
+	log.Printf("Compared %d blocks: %d identical, %d differ, %d not cached, %d missing", same+differ+uncached+missing, same, differ, uncached, missing)
+	return differ, nil
+}
+
+// sameBlock compares two block contents up to whitespace, which a rendered
+// document may lay out differently.
+func sameBlock(a, b string) bool {
+	return strings.Join(strings.Fields(html.UnescapeString(a)), " ") == strings.Join(strings.Fields(html.UnescapeString(b)), " ")
+}
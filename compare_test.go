@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareCaches(t *testing.T) {
+	dir := t.TempDir()
+	body := `<p>First text.</p><p>Second text.</p><p>Third text.</p>`
+	input := writeZip(t, dir, "book.epub", testBook(body))
+
+	// The earlier output, made with its own cache
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.Cache = newMemoryCache()
+	existing := filepath.Join(dir, "existing.epub")
+	if err := processEpub(input, existing, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second cache, from a model that words the second block differently
+	// and from a book that didn't have the third yet
+	api = newStubAPI(t, func(content string) (int, string) {
+		if content == "Second text." {
+			return http.StatusOK, "[U]Second text."
+		}
+		return prefixReply(content)
+	})
+	cfg = testConfig(api.URL)
+	cfg.Cache = newMemoryCache()
+	if _, err := translate(t, testBook(`<p>First text.</p><p>Second text.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	api = newStubAPI(t, nil)
+	cache := cfg.Cache
+	cfg = testConfig(api.URL)
+	cfg.Cache = cache
+	var out strings.Builder
+	differ, err := compareEpub(input, existing, cfg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ != 1 {
+		t.Errorf("got %d differing blocks, want 1", differ)
+	}
+
+	var rows []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		rows = append(rows, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		chapterName(1) + " html/body/p[2] differs",
+		chapterName(1) + " html/body/p[3] not cached",
+	}
+	if strings.Join(rows, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(want, "\n"))
+	}
+	if n := len(api.requests()); n != 0 {
+		t.Errorf("comparing sent %d requests", n)
+	}
+}
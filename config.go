@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfigFile sets the flags listed in the YAML file at path, e.g.
+//
+//	model: gpt-4o
+//	concurrency: 4
+//	keep-tags-list: [em, strong, a]
+//
+// Keys are flag names without the dash. Flags given on the command line
+// take precedence, so the file has to be applied after fs was parsed.
+func applyConfigFile(path string, fs *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config: %w", err)
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+
+	// An alias (-concurrency for -file-concurrency) sets the same variable,
+	// so a flag counts as given if any of its names was
+	given := make(map[uintptr]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[flagTarget(f)] = true
+	})
+
+	for key, value := range settings {
+		if fs.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if given[flagTarget(fs.Lookup(key))] {
+			continue
+		}
+
+		s, err := configValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		if err := fs.Set(key, s); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", path, s, key, err)
+		}
+	}
+	return nil
+}
+
+// flagTarget identifies the variable flag f sets, which its aliases share.
+func flagTarget(f *flag.Flag) uintptr {
+	if v := reflect.ValueOf(f.Value); v.Kind() == reflect.Pointer {
+		return v.Pointer()
+	}
+	return reflect.ValueOf(f).Pointer()
+}
+
+// configValue turns a YAML value into the string the flag would be given on
+// the command line. Lists become comma-separated values.
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	case nil:
+		return "", fmt.Errorf("no value")
+	}
+	return "", fmt.Errorf("expected a value or a list, got %T", value)
+}
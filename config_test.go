@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("model: gpt-4o\nconcurrency: 8\nkeep-tags-list: [em, strong]\nreproducible: true\nlength-ratio-max: 2.5\n"), 0o644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	model := fs.String("model", "", "")
+	concurrency := fs.Int("file-concurrency", 1, "")
+	fs.IntVar(concurrency, "concurrency", 1, "")
+	keepTags := fs.String("keep-tags-list", "", "")
+	reproducible := fs.Bool("reproducible", false, "")
+	ratio := fs.Float64("length-ratio-max", 3, "")
+	fs.String("config", "", "")
+
+	if err := fs.Parse([]string{"-file-concurrency", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(path, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if *concurrency != 2 {
+		t.Errorf("concurrency: the config's alias overrode the command line, got %d", *concurrency)
+	}
+	if *model != "gpt-4o" || *keepTags != "em,strong" || !*reproducible || *ratio != 2.5 {
+		t.Errorf("got model %q, keep-tags-list %q, reproducible %v, length-ratio-max %v", *model, *keepTags, *reproducible, *ratio)
+	}
+}
+
+func TestApplyConfigFileAliasGivenOnCommandLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("file-concurrency: 8\n"), 0o644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	concurrency := fs.Int("file-concurrency", 1, "")
+	fs.IntVar(concurrency, "concurrency", 1, "")
+	fs.Parse([]string{"-concurrency", "3"})
+
+	if err := applyConfigFile(path, fs); err != nil {
+		t.Fatal(err)
+	}
+	if *concurrency != 3 {
+		t.Errorf("got %d, want the 3 of the command line", *concurrency)
+	}
+}
+
+func TestApplyConfigFileUnknownSetting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("modle: gpt-4o\n"), 0o644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("model", "", "")
+	if err := applyConfigFile(path, fs); err == nil {
+		t.Error("accepted an unknown setting")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// cssContentPattern matches the string value of a CSS content property,
+// e.g. content: "Chapter ". Group 1 is the quote character, group 2 the text.
+var cssContentPattern = regexp.MustCompile(`content\s*:\s*(["'])((?:\\.|[^\\])*?)["']`)
+
+// cssEscapePattern matches CSS escapes like \201C, which must not count as letters.
+var cssEscapePattern = regexp.MustCompile(`\\[0-9a-fA-F]{1,6}\s?|\\.`)
+
+// handleStyleContent looks for visible text in the content: strings of the
+// document's <style> blocks. Such text is generated by pseudo-elements and
+// isn't reached by the normal selection, so it is reported, and with
+// -translate-css-content also translated.
+func handleStyleContent(doc *goquery.Document, cfg *Config) []blockFailure {
+	var failures []blockFailure
+
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		css := s.Text()
+		changed := false
+
+		css = cssContentPattern.ReplaceAllStringFunc(css, func(match string) string {
+			m := cssContentPattern.FindStringSubmatch(match)
+			quote, value := m[1], m[2]
+			if !hasLetters(cssEscapePattern.ReplaceAllString(value, "")) {
+				return match
+			}
+
+			if !cfg.TranslateCSSContent {
+				shown := quote + value + quote
+				if cfg.RedactLog {
+					shown = "(redacted)"
+				}
+				cfg.logf("  -> Warning: <style> contains visible text in content: %s (use -translate-css-content to translate it)", shown)
+				return match
+			}
+
+			translated, err := translateNode(value, "This is the text of a CSS content property. Output plain text only.", cfg)
+			if err != nil {
+				failures = append(failures, blockFailure{Path: nodePath(s.Get(0)), Err: err})
+				return match
+			}
+
+			// Keep the spacing around the value, "Chapter " is usually followed by a counter
+			lead := value[:len(value)-len(strings.TrimLeft(value, " "))]
+			trail := value[len(strings.TrimRight(value, " ")):]
+
+			changed = true
+			return strings.Replace(match, quote+value+quote, quote+lead+escapeCSSString(translated, quote)+trail+quote, 1)
+		})
+
+		// SetText would escape the CSS, but <style> is raw text
+		if changed {
+			n := s.Get(0)
+			for n.FirstChild != nil {
+				n.RemoveChild(n.FirstChild)
+			}
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+		}
+	})
+
+	return failures
+}
+
+func hasLetters(s string) bool {
+	return strings.IndexFunc(s, unicode.IsLetter) >= 0
+}
+
+// escapeCSSString makes model output safe to put back between quotes.
+func escapeCSSString(s, quote string) string {
+	s = strings.Trim(strings.TrimSpace(s), `"'`)
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\A `)
+	return strings.ReplaceAll(s, quote, `\`+quote)
+}
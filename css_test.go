@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStyleContent(t *testing.T) {
+	body := `<style>p.note::before { content: "Note: "; } li::marker { content: "\2022 "; }</style><p class="note">Keep it short.</p>`
+
+	t.Run("warning", func(t *testing.T) {
+		api := newStubAPI(t, nil)
+		var logged bytes.Buffer
+		cfg := testConfig(api.URL)
+		cfg.Logger = log.New(&logged, "", 0)
+		out, err := translate(t, testBook(body), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(logged.String(), `<style> contains visible text in content: "Note: "`) {
+			t.Errorf("no warning in the log:\n%s", logged.String())
+		}
+		if strings.Contains(logged.String(), `\2022`) {
+			t.Errorf("warned about an escape without letters:\n%s", logged.String())
+		}
+		if api.requested("Note:") != 0 || !strings.Contains(out[chapterName(1)], `content: "Note: "`) {
+			t.Errorf("the string was translated without -translate-css-content")
+		}
+	})
+
+	t.Run("translated", func(t *testing.T) {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.TranslateCSSContent = true
+		out, err := translate(t, testBook(body), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chapter := out[chapterName(1)]
+		if !strings.Contains(chapter, `p.note::before { content: "[T]Note: "; }`) {
+			t.Errorf("the string isn't translated:\n%s", chapter)
+		}
+		if !strings.Contains(chapter, `content: "\2022 "`) || api.requested("2022") != 0 {
+			t.Errorf("an escape without letters was translated:\n%s", chapter)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits of downloading an input given as URL.
+var maxDownloadSize int64 = 1 << 30 // 1 GiB
+
+const downloadTimeout = 10 * time.Minute
+
+// epubContentTypes are the content types an EPUB may be served with. Any
+// other type is only accepted if the URL ends in .epub.
+var epubContentTypes = map[string]bool{
+	"application/epub+zip": true, "application/zip": true, "application/x-zip-compressed": true,
+	"application/octet-stream": true, "binary/octet-stream": true,
+}
+
+func isURL(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
+}
+
+// downloadEpub saves the EPUB at rawURL in dir, under the name of the URL's
+// last path element, so the output is named after it as for a local file.
+func downloadEpub(rawURL, dir string, cfg *Config) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+
+	log.Printf("Downloading %s", rawURL)
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not download %s: status %d", rawURL, resp.StatusCode)
+	}
+	if resp.ContentLength > maxDownloadSize {
+		return "", fmt.Errorf("%s is larger than %d bytes", rawURL, maxDownloadSize)
+	}
+	named := strings.EqualFold(path.Ext(u.Path), ".epub")
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); !named && !epubContentTypes[mediaType] {
+		return "", fmt.Errorf("%s is served as %q, not as an EPUB", rawURL, mediaType)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if magic, _ := body.Peek(4); !bytes.Equal(magic, []byte("PK\x03\x04")) {
+		return "", fmt.Errorf("%w: %s is not a zip file", ErrInvalidEpub, rawURL)
+	}
+
+	name := "download.epub"
+	if named {
+		name = path.Base(u.Path)
+	}
+	target := filepath.Join(dir, name)
+	for i := 2; fileExists(target); i++ {
+		target = filepath.Join(dir, fmt.Sprintf("%d-%s", i, name))
+	}
+
+	// Streamed to the file, so a large book isn't held in memory
+	f, err := os.Create(target)
+	if err != nil {
+		return "", fmt.Errorf("could not save %s: %w", rawURL, err)
+	}
+	n, err := io.Copy(f, io.LimitReader(body, maxDownloadSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		os.Remove(target)
+		return "", fmt.Errorf("could not download %s: %w", rawURL, err)
+	case n > maxDownloadSize:
+		os.Remove(target)
+		return "", fmt.Errorf("%s is larger than %d bytes", rawURL, maxDownloadSize)
+	}
+	return target, nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
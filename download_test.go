@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func serveFile(t *testing.T, path, contentType string) *httptest.Server {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Without a Content-Length, so the size is only known once it's read
+		w.Header().Set("Content-Type", contentType)
+		w.(http.Flusher).Flush()
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTranslateFromURL(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	book := writeZip(t, dir, "source.epub", testBook(`<p>Hello from the web.</p>`))
+	srv := serveFile(t, book, "application/epub+zip")
+
+	downloads := t.TempDir()
+	input, err := downloadEpub(srv.URL+"/books/novel.epub", downloads, testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(input) != "novel.epub" {
+		t.Errorf("downloaded to %s, want it named after the URL", input)
+	}
+
+	output := filepath.Join(dir, "out.epub")
+	if err := processEpub(input, output, testConfig(api.URL)); err != nil {
+		t.Fatal(err)
+	}
+	if chapter := readEntries(t, output)[chapterName(1)]; !strings.Contains(chapter, "[T]Hello from the web.") {
+		t.Errorf("chapter not translated:\n%s", chapter)
+	}
+}
+
+func TestDownloadLimits(t *testing.T) {
+	dir := t.TempDir()
+	book := writeZip(t, dir, "source.epub", testBook(`<p>Hello.</p>`))
+	notZip := filepath.Join(dir, "page.html")
+	os.WriteFile(notZip, []byte("<html>Not found</html>"), 0o644)
+
+	t.Run("too large", func(t *testing.T) {
+		defer func(size int64) { maxDownloadSize = size }(maxDownloadSize)
+		maxDownloadSize = 100
+
+		downloads := t.TempDir()
+		srv := serveFile(t, book, "application/epub+zip")
+		if _, err := downloadEpub(srv.URL+"/big.epub", downloads, testConfig("")); err == nil || !strings.Contains(err.Error(), "larger than") {
+			t.Errorf("got %v, want a size error", err)
+		}
+		if entries, _ := os.ReadDir(downloads); len(entries) != 0 {
+			t.Errorf("left %d files behind", len(entries))
+		}
+	})
+
+	t.Run("not a zip", func(t *testing.T) {
+		srv := serveFile(t, notZip, "application/octet-stream")
+		if _, err := downloadEpub(srv.URL+"/book.epub", t.TempDir(), testConfig("")); !errors.Is(err, ErrInvalidEpub) {
+			t.Errorf("got %v, want ErrInvalidEpub", err)
+		}
+	})
+
+	t.Run("not an EPUB type", func(t *testing.T) {
+		srv := serveFile(t, book, "text/html")
+		if _, err := downloadEpub(srv.URL+"/download", t.TempDir(), testConfig("")); err == nil {
+			t.Error("accepted a download served as text/html")
+		}
+	})
+}
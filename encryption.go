@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/url"
+)
+
+const encryptionXMLName = "META-INF/encryption.xml"
+
+// fontObfuscation lists the algorithms that only obfuscate embedded fonts.
+// Files using them are fonts the tool copies anyway, so they don't make a
+// book unreadable.
+var fontObfuscation = map[string]bool{
+	"http://www.idpf.org/2008/embedding": true,
+	"http://ns.adobe.com/pdf/enc#RC":     true,
+}
+
+type encryptionXML struct {
+	EncryptedData []struct {
+		Method struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"EncryptionMethod"`
+		Reference struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"CipherData>CipherReference"`
+	} `xml:"EncryptedData"`
+}
+
+// encryptedEntries returns the entries META-INF/encryption.xml declares as
+// encrypted by DRM (Adobe ADEPT, Readium LCP, ...), i.e. other than by font
+// obfuscation. Books without encryption.xml have none.
+func encryptedEntries(files []*zip.File) (map[string]bool, error) {
+	if findZipFile(files, encryptionXMLName) == nil {
+		return nil, nil
+	}
+
+	var enc encryptionXML
+	if err := decodeZipXML(files, encryptionXMLName, &enc); err != nil {
+		return nil, err
+	}
+
+	encrypted := make(map[string]bool)
+	for _, d := range enc.EncryptedData {
+		if fontObfuscation[d.Method.Algorithm] || d.Reference.URI == "" {
+			continue
+		}
+		// URIs are relative to the root of the container
+		name := d.Reference.URI
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+		encrypted[name] = true
+	}
+	return encrypted, nil
+}
+
+// checkEncryption fails for DRM-protected books unless -ignore-encryption
+// is set, in which case the encrypted entries are returned so they can be
+// copied through untranslated.
+func checkEncryption(files []*zip.File, cfg *Config) (map[string]bool, error) {
+	encrypted, err := encryptedEntries(files)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	if len(encrypted) == 0 {
+		return nil, nil
+	}
+
+	var example string
+	for _, f := range files {
+		if encrypted[f.Name] {
+			example = f.Name
+			break
+		}
+	}
+
+	if !cfg.IgnoreEncryption {
+		return nil, fmt.Errorf("%w: the EPUB is DRM-protected (%d encrypted files, e.g. %s) and cannot be translated; use a DRM-free copy, or pass -ignore-encryption to copy the encrypted files untranslated", ErrInvalidEpub, len(encrypted), example)
+	}
+	return encrypted, nil
+}
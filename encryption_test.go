@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testEncryptionXML returns an encryption.xml declaring uri encrypted with
+// algorithm.
+func testEncryptionXML(algorithm, uri string) string {
+	return `<?xml version="1.0"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#"><enc:EncryptedData><enc:EncryptionMethod Algorithm="` + algorithm + `"/><enc:CipherData><enc:CipherReference URI="` + uri + `"/></enc:CipherData></enc:EncryptedData></encryption>`
+}
+
+func TestEncryptedEpub(t *testing.T) {
+	ciphertext := "\x8f\x03\xd1<p\x00\xfe garbage \x7f"
+	book := testBook(`<p>Readable text.</p>`, `<p>Secret.</p>`)
+	book = replaceEntry(book, chapterName(2), ciphertext)
+	book = replaceEntry(book, encryptionXMLName, testEncryptionXML("http://www.w3.org/2001/04/xmlenc#aes128-cbc", "OEBPS/text/ch2.xhtml"))
+
+	api := newStubAPI(t, nil)
+	out, err := translate(t, book, testConfig(api.URL))
+	if !errors.Is(err, ErrInvalidEpub) || !strings.Contains(err.Error(), "DRM-protected") {
+		t.Fatalf("got %v, want an error that the EPUB is DRM-protected", err)
+	}
+	if out != nil || len(api.requests()) != 0 {
+		t.Errorf("a DRM-protected book was translated: %d requests", len(api.requests()))
+	}
+
+	cfg := testConfig(api.URL)
+	cfg.IgnoreEncryption = true
+	out, err = translate(t, book, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[chapterName(2)] != ciphertext {
+		t.Errorf("the encrypted file wasn't copied untouched: %q", out[chapterName(2)])
+	}
+	if !strings.Contains(out[chapterName(1)], "[T]Readable text.") {
+		t.Errorf("the readable file wasn't translated:\n%s", out[chapterName(1)])
+	}
+	if out[encryptionXMLName] == "" {
+		t.Errorf("encryption.xml was dropped")
+	}
+}
+
+func TestFontObfuscationIsNotDRM(t *testing.T) {
+	book := testBook(`<p>Readable text.</p>`)
+	book = replaceEntry(book, "OEBPS/fonts/serif.otf", "\x00\x01obfuscated")
+	book = replaceEntry(book, encryptionXMLName, testEncryptionXML("http://www.idpf.org/2008/embedding", "OEBPS/fonts/serif.otf"))
+
+	api := newStubAPI(t, nil)
+	out, err := translate(t, book, testConfig(api.URL))
+	if err != nil {
+		t.Fatalf("a book with obfuscated fonts was refused: %v", err)
+	}
+	if !strings.Contains(out[chapterName(1)], "[T]Readable text.") {
+		t.Errorf("the book wasn't translated:\n%s", out[chapterName(1)])
+	}
+}
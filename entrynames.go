@@ -0,0 +1,35 @@
+package main
+
+import (
+	"archive/zip"
+	"log"
+	"path"
+	"strings"
+)
+
+// unsafeEntryName reports whether a zip entry name would leave the directory
+// the book is extracted to: absolute paths, drive letters and ".." segments,
+// also when written with backslashes.
+func unsafeEntryName(name string) bool {
+	n := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(n, "/") || len(n) >= 2 && n[1] == ':' {
+		return true
+	}
+	clean := path.Clean(n)
+	return clean == ".." || strings.HasPrefix(clean, "../")
+}
+
+// safeEntries drops the entries with unsafe names, so they aren't passed on
+// to the output where whoever unpacks it would write outside the target
+// directory.
+func safeEntries(files []*zip.File) []*zip.File {
+	safe := make([]*zip.File, 0, len(files))
+	for _, f := range files {
+		if unsafeEntryName(f.Name) {
+			log.Printf("Warning: skipping entry %q, its path leaves the book", f.Name)
+			continue
+		}
+		safe = append(safe, f)
+	}
+	return safe
+}
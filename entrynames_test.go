@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnsafeEntryName(t *testing.T) {
+	for name, unsafe := range map[string]bool{
+		"OEBPS/text/ch1.xhtml":     false,
+		"OEBPS/../mimetype2":       false,
+		"..data/file":              false,
+		"../evil":                  true,
+		"OEBPS/../../evil":         true,
+		"/etc/passwd":              true,
+		`..\evil`:                  true,
+		`C:\Windows\evil.dll`:      true,
+		"OEBPS/text/../../../evil": true,
+	} {
+		if got := unsafeEntryName(name); got != unsafe {
+			t.Errorf("unsafeEntryName(%q) = %v, want %v", name, got, unsafe)
+		}
+	}
+}
+
+func TestUnsafeEntriesAreDropped(t *testing.T) {
+	book := append(testBook(`<p>Text.</p>`), zipEntry{"../evil", "<p>Evil text.</p>"}, zipEntry{"/abs/evil.xhtml", xhtml(`<p>Evil text.</p>`)})
+	api := newStubAPI(t, nil)
+	logged := captureLog(t)
+	out, err := translate(t, book, testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range out {
+		if strings.Contains(name, "evil") {
+			t.Errorf("the output has the entry %s", name)
+		}
+	}
+	if api.requested("Evil text.") != 0 {
+		t.Errorf("translated an entry outside the book")
+	}
+	if !strings.Contains(out[chapterName(1)], "[T]Text.") {
+		t.Errorf("the rest of the book wasn't translated:\n%s", out[chapterName(1)])
+	}
+	log := strings.Join(logged.lines(), "\n")
+	if !strings.Contains(log, `skipping entry "../evil"`) || !strings.Contains(log, `skipping entry "/abs/evil.xhtml"`) {
+		t.Errorf("the dropped entries weren't logged:\n%s", log)
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fileResult is the translated content of one file, waiting to be written.
+type fileResult struct {
+	data       []byte
+	sourceHash string
+	counts     TextCounts
+	warnings   []blockWarning
+	failures   []blockFailure
+	err        error
+}
+
+func processEpub(inputPath, outputPath string, cfg *Config) (err error) {
+	var failures []blockFailure
+	var warnings []blockWarning
+	counts := make(map[string]TextCounts)
+	if cfg.Report != nil {
+		defer func() {
+			b := newBookReport(inputPath, outputPath, cfg, counts, failures, err)
+			b.addWarnings(warnings)
+			cfg.Report.addBook(b)
+		}()
+	}
+
+	// Checked before anything else, for every mode of the run: creating the
+	// output over the input would truncate it while it is being read
+	if err := checkNotInput(inputPath, outputPath); err != nil {
+		return err
+	}
+
+	if cfg.ExportMemory != nil {
+		defer func() {
+			if err := cfg.ExportMemory.Save(); err != nil {
+				log.Printf("Could not write translation memory: %v", err)
+			}
+		}()
+	}
+
+	reader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return fmt.Errorf("could not open input epub: %w: %w", ErrInvalidEpub, err)
+	}
+	defer reader.Close()
+	files := safeEntries(reader.File)
+
+	encrypted, err := checkEncryption(files, cfg)
+	if err != nil {
+		return err
+	}
+	if len(encrypted) > 0 {
+		log.Printf("Warning: %d files are DRM-encrypted and will be copied untranslated", len(encrypted))
+	}
+
+	// The spine is optional for translating; without it, files just have no
+	// reading position
+	pkg, pkgErr := readPackage(files)
+	if pkgErr != nil {
+		log.Printf("Warning: could not read the spine: %v", pkgErr)
+	}
+
+	entries := files
+	if cfg.ReorderBySpine && pkg != nil {
+		entries = spineOrder(files, pkg)
+	} else if cfg.Reproducible {
+		entries = mimetypeFirst(files)
+	}
+
+	renames := extensionRenames(files, cfg.FlattenExtensions, encrypted)
+	if len(renames) > 0 {
+		log.Printf("Renaming %d files to .%s", len(renames), cfg.FlattenExtensions)
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w: %w", ErrWrite, err)
+	}
+	defer outputFile.Close()
+
+	writer := newEntryWriter(outputFile, cfg)
+	defer writer.Close()
+
+	// -start-at copies the spine before it as it is
+	start := 0
+	if cfg.StartAt != "" {
+		if start, err = startPosition(cfg.StartAt, pkg); err != nil {
+			return err
+		}
+		log.Printf("Starting at %s (%d/%d), copying the %d files before it", pkg.Spine[start-1], start, len(pkg.Spine), start-1)
+	}
+
+	// One result slot per translatable file. Workers fill them in any order,
+	// the loop below drains them in the order they are written.
+	results := make(map[*zip.File]chan fileResult)
+	for _, file := range entries {
+		if !shouldTranslate(file.Name, pkg, cfg) || encrypted[file.Name] {
+			continue
+		}
+		if p := spinePosition(pkg, file.Name); p > 0 && p < start {
+			continue
+		}
+		if tooLarge(file, cfg) && cfg.OversizedAction == oversizedCopy {
+			log.Printf("Warning: %s is larger than -max-file-size (%d bytes), copying it untranslated", file.Name, file.UncompressedSize64)
+			continue
+		}
+		results[file] = make(chan fileResult, 1)
+	}
+	numberOfXml := len(results)
+
+	var edition *originalEdition
+	if cfg.KeepOriginalFile && pkg != nil {
+		edition = newOriginalEdition(files, pkg, func(name string) bool {
+			_, ok := results[findZipFile(files, name)]
+			return ok
+		}, renames)
+		log.Printf("Keeping the original of %d files", len(edition.copies))
+	}
+
+	log.Printf("Found %d files to translate.", numberOfXml)
+
+	budget := newMemoryBudget(cfg.MaxMemory)
+	dispatched := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// On an early return, unblock the dispatcher and let running workers
+	// finish before the reader is closed underneath them.
+	defer func() {
+		budget.close()
+		<-dispatched
+		wg.Wait()
+	}()
+
+	go func() {
+		defer close(dispatched)
+
+		workers := make(chan struct{}, max(cfg.Concurrency, 1))
+		xmlIndex := 0
+
+		type job struct {
+			file  *zip.File
+			slot  chan fileResult
+			index int
+		}
+
+		// run translates jobs on one worker, as a group if there are several.
+		// It returns false once the run is over.
+		run := func(jobs []job) bool {
+			var reservation int64
+			for _, j := range jobs {
+				reservation += reservationFor(j.file)
+			}
+
+			// Reserving in write order guarantees the file the writer waits for
+			// always has its share, so the budget can't deadlock.
+			if !budget.acquire(reservation) {
+				return false
+			}
+			workers <- struct{}{}
+			if budget.closed() {
+				return false
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-workers }()
+
+				files := make([]*zip.File, len(jobs))
+				cfgs := make([]*Config, len(jobs))
+				flushes := make([]func(), len(jobs))
+				for i, j := range jobs {
+					fileCfg, flush := fileLogger(j.file.Name, cfg)
+					fileCfg.logf("Translating %s... (%v/%v)", j.file.Name, j.index, numberOfXml)
+					statsCfg := *fileCfg
+					statsCfg.Stats = &textStats{}
+					statsCfg.AuditFile = j.file.Name
+					if cfg.FileSession {
+						statsCfg.Session = &fileSession{}
+					}
+					files[i], cfgs[i], flushes[i] = j.file, &statsCfg, flush
+				}
+
+				var res []fileResult
+				if len(jobs) == 1 {
+					res = []fileResult{translateFile(files[0], cfgs[0])}
+				} else {
+					res = translateFileGroup(files, cfgs)
+				}
+
+				for i, r := range res {
+					r.counts = cfgs[i].Stats.get()
+					r.warnings = cfgs[i].Stats.getWarnings()
+					if r.err == nil {
+						cfgs[i].logf("  -> Translated %s", r.counts)
+						if cfg.SaveIntermediate != "" {
+							if err := saveIntermediate(cfg.SaveIntermediate, jobs[i].file.Name, r.data); err != nil {
+								cfgs[i].logf("  -> Could not save intermediate copy: %v", err)
+							}
+						}
+					}
+					flushes[i]()
+					jobs[i].slot <- r
+				}
+			}()
+			return true
+		}
+
+		var group []job
+		for _, file := range entries {
+			slot, ok := results[file]
+			if !ok {
+				continue
+			}
+			xmlIndex++
+			j := job{file: file, slot: slot, index: xmlIndex}
+
+			if groupable(file, cfg) {
+				group = append(group, j)
+				if len(group) == maxGroupFiles {
+					if !run(group) {
+						return
+					}
+					group = nil
+				}
+				continue
+			}
+
+			if len(group) > 0 && !run(group) {
+				return
+			}
+			group = nil
+			if !run([]job{j}) {
+				return
+			}
+		}
+		if len(group) > 0 {
+			run(group)
+		}
+	}()
+
+	manifest := translatorManifest{TargetLang: cfg.TargetLang, Sources: make(map[string]string)}
+	untranslated := 0 // files copied for -continue-on-file-error
+
+	// The package records when the translation was made
+	modified := modificationTime(cfg)
+	touch := func(name string, data []byte) []byte {
+		if !touchesPackage(name, pkg, cfg) {
+			return data
+		}
+		return touchModified(data, pkg.Version, modified, cfg)
+	}
+
+	checkpoint := newCheckpoint(outputPath, cfg.CheckpointEvery)
+	translated := 0
+	translatedFiles := make(map[string]bool) // for -toc-from-headings
+
+	// copyEntry writes a file that isn't translated, adjusted to the renames
+	// and the kept originals. A checkpoint writes the files not done yet
+	// with it as well.
+	copyEntry := func(file *zip.File, w *entryWriter) error {
+		outName := file.Name
+		if name, ok := renames[file.Name]; ok {
+			outName = name
+		}
+		if (len(renames) > 0 && hasContentLinks(file.Name) || edition.edits(file.Name) || touchesPackage(file.Name, pkg, cfg)) && !encrypted[file.Name] {
+			data, err := readZipFile(file)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+			}
+			if data, err = edition.edit(file.Name, rewriteLinks(file.Name, data, renames)); err != nil {
+				return err
+			}
+			if err := writeEntry(w, outName, touch(file.Name, data)); err != nil {
+				return err
+			}
+		} else if err := copyFile(file, w); err != nil {
+			return err
+		}
+		if c, ok := edition.copyOf(file.Name); ok {
+			data, err := edition.original(file)
+			if err == nil {
+				err = writeEntry(w, c, data)
+			}
+			return err
+		}
+		return nil
+	}
+
+	for i, file := range entries {
+		// Written fresh below; an input that is itself a translation must not end up with two
+		if file.Name == translatorManifestName {
+			continue
+		}
+
+		// Failures and counts are recorded under the name in the output,
+		// where -retry-report looks for them
+		outName := file.Name
+		if name, ok := renames[file.Name]; ok {
+			outName = name
+		}
+
+		slot, ok := results[file]
+		if !ok {
+			if err := copyEntry(file, writer); err != nil {
+				return fmt.Errorf("error processing file %s: %w", file.Name, err)
+			}
+			checkpoint.addCopy(file)
+			continue
+		}
+
+		res := <-slot
+		if abortErr := cfg.Abort.get(); abortErr != nil {
+			// A partial book is of no use; nothing else is going to be translated
+			writer.Close()
+			outputFile.Close()
+			os.Remove(outputPath)
+			return fmt.Errorf("run aborted: %w", abortErr)
+		}
+
+		if res.err != nil && cfg.ContinueOnFileError {
+			// The book stays complete, with this file as it was
+			log.Printf("Warning: could not translate %s, copying it untranslated: %v", file.Name, res.err)
+			warnings = append(warnings, blockWarning{File: outName, Spine: spinePosition(pkg, file.Name), Message: "file copied untranslated: " + res.err.Error()})
+			untranslated++
+			data, readErr := readZipFile(file)
+			res = fileResult{data: data}
+			if readErr != nil {
+				res.err = fmt.Errorf("%w: %w", ErrInvalidEpub, readErr)
+			}
+		}
+
+		err := res.err
+		if err == nil {
+			if len(renames) > 0 {
+				res.data = rewriteLinks(file.Name, res.data, renames)
+			}
+			res.data, err = edition.edit(file.Name, res.data)
+			res.data = touch(file.Name, res.data)
+		}
+		if err == nil {
+			err = writeEntry(writer, outName, res.data)
+			checkpoint.add(outName, res.data)
+		}
+		if c, ok := edition.copyOf(file.Name); ok && err == nil {
+			var data []byte
+			if data, err = edition.original(file); err == nil {
+				err = writeEntry(writer, c, data)
+				checkpoint.add(c, data)
+			}
+		}
+		budget.release(reservationFor(file))
+
+		if err != nil {
+			return fmt.Errorf("error processing file %s: %w", file.Name, err)
+		}
+		if res.sourceHash != "" {
+			manifest.Sources[file.Name] = res.sourceHash
+			translatedFiles[outName] = true
+		}
+		counts[outName] = res.counts
+		for _, f := range res.failures {
+			f.File = outName
+			f.Spine = spinePosition(pkg, file.Name)
+			failures = append(failures, f)
+		}
+		for _, w := range res.warnings {
+			w.File = outName
+			w.Spine = spinePosition(pkg, file.Name)
+			warnings = append(warnings, w)
+		}
+
+		// Persist after every file so an aborted run keeps what it already paid for
+		if cfg.Cache != nil {
+			if err := cfg.Cache.Save(); err != nil {
+				log.Printf("Could not save cache: %v", err)
+			}
+		}
+
+		translated++
+		if checkpoint.translated() && translated < numberOfXml {
+			if err := checkpoint.save(entries[i+1:], copyEntry, manifest, cfg); err != nil {
+				log.Printf("Could not save checkpoint: %v", err)
+			} else {
+				log.Printf("Checkpoint: %d of %d files translated, saved to %s", translated, numberOfXml, checkpoint.path)
+			}
+		}
+	}
+
+	if err := writeTranslatorManifest(writer, manifest); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("could not finish output file: %w: %w", ErrWrite, err)
+	}
+	if err := outputFile.Close(); err != nil {
+		return fmt.Errorf("could not finish output file: %w: %w", ErrWrite, err)
+	}
+
+	checkpoint.remove()
+
+	if cfg.TOCFromHeadings {
+		if err := tocFromHeadings(outputPath, translatedFiles, cfg); err != nil {
+			return fmt.Errorf("could not update the table of contents: %w", err)
+		}
+	}
+
+	if cfg.MarkPlaceholders {
+		log.Printf("Marked the blocks to translate in %d files", len(counts))
+	} else {
+		log.Printf("Translated %s in %d files", totalCounts(counts), len(counts))
+	}
+	if len(warnings) > untranslated {
+		log.Printf("Warning: %d blocks have a suspicious length after translation", len(warnings)-untranslated)
+	}
+	if untranslated > 0 {
+		log.Printf("Warning: %d files could not be translated and were copied as they are", untranslated)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: %d blocks kept their original text, first error: %w", ErrIncomplete, len(failures), failures[0].Err)
+	}
+	return nil
+}
+
+// spinePosition is the 1-based reading position of name, or 0 if it isn't in
+// the spine.
+func spinePosition(pkg *epubPackage, name string) int {
+	if pkg == nil {
+		return 0
+	}
+	if i, ok := pkg.SpineIndex[name]; ok {
+		return i + 1
+	}
+	return 0
+}
+
+// translateFile translates one (X)HTML entry into memory. The result also
+// carries the hash of the source, which is recorded in the output's manifest.
+func translateFile(file *zip.File, cfg *Config) fileResult {
+	res, source, hadBOM, cfg, done := loadFile(file, cfg)
+	if done {
+		return res
+	}
+
+	// XML files are translated in place, without the HTML post-processing
+	if translateXML := xmlTranslator(file.Name); translateXML != nil {
+		res.data, res.failures, res.err = translateXML(source, cfg)
+		for i := range res.failures {
+			res.failures[i].File = file.Name
+		}
+		res.data = restoreBOM(res.data, hadBOM, cfg.BOM)
+		return res
+	}
+
+	// Batches keep the number of requests of a huge file down
+	if tooLarge(file, cfg) && cfg.BatchTokenBudget <= 0 {
+		chunkCfg := *cfg
+		chunkCfg.BatchTokenBudget = defaultGroupTokenBudget
+		cfg = &chunkCfg
+		cfg.logf("  -> Larger than -max-file-size, translating its blocks in batches")
+	}
+
+	var buf bytes.Buffer
+	res.failures, res.err = translateHTML(bytes.NewReader(source), &buf, cfg)
+	for i := range res.failures {
+		res.failures[i].File = file.Name
+	}
+	if res.err != nil {
+		return res
+	}
+	return finishFile(file.Name, res, buf.Bytes(), source, hadBOM, cfg)
+}
+
+// xmlTranslator returns how the XML file name is translated, or nil if it
+// is (X)HTML.
+func xmlTranslator(name string) func([]byte, *Config) ([]byte, []blockFailure, error) {
+	switch {
+	case isNCX(name):
+		return translateNCX
+	case isOPF(name):
+		return translateOPFMetadata
+	case isMediaOverlay(name):
+		return func(source []byte, cfg *Config) ([]byte, []blockFailure, error) {
+			return translateOverlay(name, source, cfg)
+		}
+	}
+	return nil
+}
+
+// loadFile reads file for translateFile and returns its source without a
+// BOM, along with the settings to translate it with. If the file needs no
+// translation, or can't be read, done is set and res is final.
+func loadFile(file *zip.File, cfg *Config) (res fileResult, source []byte, hadBOM bool, fileCfg *Config, done bool) {
+	source, err := readZipFile(file)
+	if err != nil {
+		return fileResult{err: fmt.Errorf("%w: %w", ErrInvalidEpub, err)}, nil, false, cfg, true
+	}
+	res = fileResult{sourceHash: hashBytes(source)}
+	source, hadBOM = stripBOM(source)
+
+	if cfg.Reference != nil {
+		if data, ok := cfg.Reference.translationFor(file.Name, res.sourceHash); ok {
+			cfg.logf("  -> Unchanged since reference, reusing its translation")
+			res.data = data
+			return res, source, hadBOM, cfg, true
+		}
+	}
+
+	if model := modelFor(cfg.ModelMap, file.Name, source); model != "" && model != cfg.Model {
+		modelCfg := *cfg
+		modelCfg.Model = model
+		cfg = &modelCfg
+		cfg.logf("  -> Using model %s", model)
+	}
+	return res, source, hadBOM, cfg, false
+}
+
+// finishFile runs the post-processing of the translated (X)HTML data of the
+// entry name.
+func finishFile(name string, res fileResult, data, source []byte, hadBOM bool, cfg *Config) fileResult {
+	res.data = data
+	if cfg.PostHook != "" {
+		res.data, res.err = runPostHook(name, res.data, cfg)
+	}
+	if res.err == nil {
+		res.data = normalizeLineEndings(res.data, cfg.LineEndings, source)
+		res.data = restoreBOM(res.data, hadBOM, cfg.BOM)
+	}
+	return res
+}
+
+func copyFile(file *zip.File, writer *entryWriter) error {
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	defer rc.Close()
+
+	w, err := writer.Create(file.Name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	// io.Copy doesn't say which side failed; a broken entry is the input's fault
+	tw := &trackingWriter{w: w}
+	if _, err := io.Copy(tw, rc); err != nil {
+		if tw.err != nil {
+			return fmt.Errorf("%w: %w", ErrWrite, err)
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	return nil
+}
+
+func writeEntry(writer *entryWriter, name string, data []byte) error {
+	w, err := writer.Create(name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	return nil
+}
+
+// trackingWriter remembers whether a write failed.
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// shouldTranslate reports whether name goes through translateFile rather
+// than being copied.
+func shouldTranslate(name string, pkg *epubPackage, cfg *Config) bool {
+	if cfg.TOCOnly {
+		return isTOCFile(name, pkg)
+	}
+	if cfg.MarkPlaceholders || cfg.FillPlaceholders || cfg.OnlySelector != "" {
+		return isTranslatable(name)
+	}
+	if len(cfg.MetadataFields) > 0 && pkg != nil && name == pkg.Path {
+		return true
+	}
+	return isTranslatable(name) || isNCX(name) || cfg.TranslateMediaOverlays && isMediaOverlay(name)
+}
+
+// isTranslatable reports whether a zip entry is an (X)HTML content file.
+func isTranslatable(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".xhtml" || ext == ".html"
+}
+
+// checkNotInput fails if outputPath is the input file, also by way of a
+// symlink or hard link: creating the output would truncate the input while
+// it is being read.
+func checkNotInput(inputPath, outputPath string) error {
+	in, err := os.Stat(inputPath)
+	if err != nil {
+		return nil
+	}
+	if out, err := os.Stat(outputPath); err == nil && os.SameFile(in, out) {
+		return fmt.Errorf("output file %s is the input %s: %w", outputPath, inputPath, ErrWrite)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputIsNotTheInput(t *testing.T) {
+	modes := []struct {
+		name string
+		set  func(*Config)
+	}{
+		{"translate", func(cfg *Config) {}},
+		{"fill placeholders", func(cfg *Config) { cfg.FillPlaceholders = true }},
+		{"start at", func(cfg *Config) { cfg.StartAt = "2" }},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			api := newStubAPI(t, nil)
+			dir := t.TempDir()
+			input := writeZip(t, dir, "book.epub", testBook(`<p>One.</p>`, `<p>Two.</p>`))
+			link := filepath.Join(dir, "link.epub")
+			if err := os.Symlink(input, link); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.ReadFile(input)
+
+			for _, output := range []string{input, link} {
+				cfg := testConfig(api.URL)
+				mode.set(cfg)
+				if err := processEpub(input, output, cfg); !errors.Is(err, ErrWrite) {
+					t.Errorf("output %s: got %v, want ErrWrite", filepath.Base(output), err)
+				}
+			}
+			if after, _ := os.ReadFile(input); string(after) != string(before) {
+				t.Error("the input was changed")
+			}
+			if n := len(api.requests()); n != 0 {
+				t.Errorf("sent %d requests before failing", n)
+			}
+		})
+	}
+}
+
+func TestRetryFromReportDoesNotRewriteTheInput(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", testBook(`<p>Text.</p>`))
+	before, _ := os.ReadFile(input)
+
+	reportPath := filepath.Join(dir, "report.json")
+	report := newReport(reportPath)
+	report.addBook(&BookReport{Input: input, Output: input, Failures: []FailureReport{{File: chapterName(1), Block: "html/body/p"}}})
+
+	if _, err := retryFromReport(reportPath, testConfig(api.URL)); !errors.Is(err, ErrWrite) {
+		t.Errorf("got %v, want ErrWrite", err)
+	}
+	if after, _ := os.ReadFile(input); string(after) != string(before) {
+		t.Error("the input was changed")
+	}
+}
+
+func TestTOCOnly(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.TOCOnly = true
+	cfg.MetadataFields = []string{"dc:title"}
+	out, err := translate(t, testBook(`<h1>Chapter 1</h1><p>Body text.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out[chapterName(1)] != xhtml(`<h1>Chapter 1</h1><p>Body text.</p>`) {
+		t.Errorf("content file changed with -toc-only:\n%s", out[chapterName(1)])
+	}
+	if api.requested("Body text.") != 0 {
+		t.Errorf("sent a content block with -toc-only")
+	}
+	for name, want := range map[string]string{
+		"OEBPS/toc.ncx":     "<text>[T]Chapter 1</text>",
+		"OEBPS/nav.xhtml":   ">[T]",
+		"OEBPS/content.opf": "<dc:title>[T]Test Book</dc:title>",
+	} {
+		if !strings.Contains(out[name], want) {
+			t.Errorf("%s lacks %s:\n%s", name, want, out[name])
+		}
+	}
+}
+
+func TestContinueOnFileError(t *testing.T) {
+	brokenNCX := `<?xml version="1.0" encoding="utf-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap><navPoint id="n1"><navLabel><text>Chapter 1</navLabel></navPoint></navMap></ncx>`
+	book := replaceEntry(testBook(`<p>First.</p>`, `<p>Second.</p>`), "OEBPS/toc.ncx", brokenNCX)
+
+	api := newStubAPI(t, nil)
+	if _, err := translate(t, book, testConfig(api.URL)); err == nil || !strings.Contains(err.Error(), "OEBPS/toc.ncx") {
+		t.Fatalf("got %v, want the run to fail on the NCX", err)
+	}
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.epub")
+	reportPath := filepath.Join(dir, "report.json")
+	cfg := testConfig(api.URL)
+	cfg.ContinueOnFileError = true
+	cfg.Report = newReport(reportPath)
+	if err := processEpub(writeZip(t, dir, "book.epub", book), output, cfg); err != nil {
+		t.Fatalf("with -continue-on-file-error: %v", err)
+	}
+
+	out := readEntries(t, output)
+	if out["OEBPS/toc.ncx"] != brokenNCX {
+		t.Errorf("the broken file wasn't copied as it was:\n%s", out["OEBPS/toc.ncx"])
+	}
+	for i, want := range []string{"<p>[T]First.</p>", "<p>[T]Second.</p>"} {
+		if !strings.Contains(out[chapterName(i+1)], want) {
+			t.Errorf("%s wasn't translated:\n%s", chapterName(i+1), out[chapterName(i+1)])
+		}
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	warnings := report.Books[0].Warnings
+	if len(warnings) != 1 || warnings[0].File != "OEBPS/toc.ncx" || !strings.HasPrefix(warnings[0].Warning, "file copied untranslated: ") {
+		t.Errorf("the report doesn't record the copied file: %+v", warnings)
+	}
+}
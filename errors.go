@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Error categories callers can test for with errors.Is.
+var (
+	// ErrInvalidEpub means the input could not be read as an EPUB.
+	ErrInvalidEpub = errors.New("invalid epub")
+	// ErrAuth means the API rejected the credentials (401/403).
+	ErrAuth = errors.New("authentication failed")
+	// ErrRateLimited means a block was still rate limited (429) after all retries.
+	ErrRateLimited = errors.New("rate limit exhausted")
+	// ErrTranslation is any other failure to get a translation for a block.
+	ErrTranslation = errors.New("translation failed")
+	// ErrWrite means the output EPUB could not be written.
+	ErrWrite = errors.New("write failed")
+	// ErrIncomplete means the output was written, but some blocks kept their
+	// source text because they could not be translated. It wraps the error of
+	// the first such block as well.
+	ErrIncomplete = errors.New("translation incomplete")
+)
+
+// exitCode maps an error returned by processEpub to the process exit status.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidEpub):
+		return 2
+	case errors.Is(err, ErrAuth):
+		return 3
+	case errors.Is(err, ErrRateLimited):
+		return 4
+	case errors.Is(err, ErrWrite):
+		return 5
+	case errors.Is(err, ErrIncomplete):
+		return 6
+	default:
+		return 1
+	}
+}
+
+// abortSignal stops a run once an error makes further requests pointless,
+// like rejected credentials. Its methods are safe on a nil signal.
+type abortSignal struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (a *abortSignal) set(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// get returns the error that aborted the run, or nil.
+func (a *abortSignal) get() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	failing := func(status int) func(string) (int, string) {
+		return func(string) (int, string) { return status, "" }
+	}
+	tests := []struct {
+		name     string
+		input    func(dir string) string
+		output   func(dir string) string
+		reply    func(string) (int, string)
+		want     []error
+		exitCode int
+	}{
+		{
+			name: "not a zip",
+			input: func(dir string) string {
+				p := filepath.Join(dir, "book.epub")
+				os.WriteFile(p, []byte("plain text"), 0o644)
+				return p
+			},
+			want:     []error{ErrInvalidEpub},
+			exitCode: 2,
+		},
+		{
+			name:     "credentials rejected",
+			reply:    failing(http.StatusUnauthorized),
+			want:     []error{ErrAuth},
+			exitCode: 3,
+		},
+		{
+			name:     "rate limited",
+			reply:    failing(http.StatusTooManyRequests),
+			want:     []error{ErrIncomplete, ErrRateLimited},
+			exitCode: 4,
+		},
+		{
+			name:     "output not writable",
+			output:   func(dir string) string { return filepath.Join(dir, "missing", "out.epub") },
+			want:     []error{ErrWrite},
+			exitCode: 5,
+		},
+		{
+			name:     "blocks failed",
+			reply:    failing(http.StatusInternalServerError),
+			want:     []error{ErrIncomplete, ErrTranslation},
+			exitCode: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStubAPI(t, tt.reply)
+			dir := t.TempDir()
+			input := writeZip(t, dir, "book.epub", testBook(`<p>Text.</p>`))
+			if tt.input != nil {
+				input = tt.input(dir)
+			}
+			output := filepath.Join(dir, "out.epub")
+			if tt.output != nil {
+				output = tt.output(dir)
+			}
+
+			err := processEpub(input, output, testConfig(api.URL))
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("got %v, want %v", err, want)
+				}
+			}
+			if got := exitCode(err); got != tt.exitCode {
+				t.Errorf("got exit code %d, want %d", got, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestExitCodeOfOtherErrors(t *testing.T) {
+	if got := exitCode(errors.New("something else")); got != 1 {
+		t.Errorf("got %d for an uncategorized error, want 1", got)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// runEstimate is what a run would send to the API, assuming nothing is
+// cached.
+type runEstimate struct {
+	Files    int
+	Blocks   int
+	Requests int
+	Tokens   int
+}
+
+// Cost is the estimated price of the run at pricePerMTok per million tokens.
+func (e runEstimate) Cost(pricePerMTok float64) float64 {
+	return float64(e.Tokens) / 1e6 * pricePerMTok
+}
+
+// estimateRun counts the blocks of all inputs the same way translateHTML
+// selects them, without contacting the API. Tokens include the system
+// prompt of every request and an answer as long as the source.
+func estimateRun(inputs []string, cfg *Config) (runEstimate, error) {
+	var e runEstimate
+	promptTokens := estimateTokens(buildSystemPrompt(cfg))
+
+	for _, input := range inputs {
+		r, err := zip.OpenReader(input)
+		if err != nil {
+			return e, fmt.Errorf("%w: %s: %v", ErrInvalidEpub, input, err)
+		}
+
+		for _, file := range r.File {
+			if !isTranslatable(file.Name) {
+				continue
+			}
+
+			data, err := readZipFile(file)
+			if err != nil {
+				r.Close()
+				return e, fmt.Errorf("%w: %s: %v", ErrInvalidEpub, input, err)
+			}
+			doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+
+			e.Files++
+			batchTokens := 0
+			applyTransforms(doc, cfg)
+			selection, _ := selectBlocks(doc, cfg)
+			selection.Each(func(i int, s *goquery.Selection) {
+				if !hasTranslatableText(s, cfg) {
+					return
+				}
+				inner, _ := s.Html()
+				tokens := estimateTokens(inner)
+
+				e.Blocks++
+				e.Tokens += 2 * tokens
+
+				// Mirrors the greedy grouping of translateBatched
+				if cfg.BatchTokenBudget > 0 && batchTokens > 0 && batchTokens+tokens <= cfg.BatchTokenBudget {
+					batchTokens += tokens
+					return
+				}
+				batchTokens = tokens
+				e.Requests++
+				e.Tokens += promptTokens
+			})
+		}
+		r.Close()
+	}
+
+	return e, nil
+}
+
+// confirmLimits are the thresholds of -confirm: a run that needs more
+// requests, or costs more at PricePerMTok, is only started once confirmed.
+type confirmLimits struct {
+	Requests     int
+	Cost         float64
+	PricePerMTok float64
+}
+
+// confirmExpensiveRun estimates the run of inputs and asks for confirmation
+// with confirmRun if it exceeds limits. With yes (-yes), it doesn't ask.
+func confirmExpensiveRun(inputs []string, cfg *Config, limits confirmLimits, yes bool, in io.Reader, out io.Writer, interactive bool) error {
+	if yes {
+		return nil
+	}
+	estimate, err := estimateRun(inputs, cfg)
+	if err != nil {
+		return fmt.Errorf("error estimating run: %w", err)
+	}
+	overCost := limits.PricePerMTok > 0 && estimate.Cost(limits.PricePerMTok) > limits.Cost
+	if estimate.Requests <= limits.Requests && !overCost {
+		return nil
+	}
+	return confirmRun(estimate, limits.PricePerMTok, in, out, interactive)
+}
+
+// confirmRun asks on out whether to go ahead with the estimated run, reading
+// the answer from in. Without a terminal to ask on, it refuses.
+func confirmRun(e runEstimate, pricePerMTok float64, in io.Reader, out io.Writer, interactive bool) error {
+	fmt.Fprintf(out, "About to translate %d files with %d blocks in %d requests, about %d tokens", e.Files, e.Blocks, e.Requests, e.Tokens)
+	if pricePerMTok > 0 {
+		fmt.Fprintf(out, " (estimated cost %.2f)", e.Cost(pricePerMTok))
+	}
+	fmt.Fprintln(out, ".")
+
+	if !interactive {
+		return fmt.Errorf("not asking for confirmation without a terminal, pass -yes to run anyway")
+	}
+
+	fmt.Fprint(out, "Continue? [y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("aborted")
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirmExpensiveRun(t *testing.T) {
+	input := writeZip(t, t.TempDir(), "book.epub", testBook(`<p>First text.</p><p>Second text.</p>`))
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	// Every run is over a threshold of no requests
+	forced := confirmLimits{Requests: 0}
+
+	tests := []struct {
+		name        string
+		limits      confirmLimits
+		yes         bool
+		interactive bool
+		answer      string
+		wantErr     string
+		wantAsked   bool
+	}{
+		{"-yes", forced, true, false, "", "", false},
+		{"under the threshold", confirmLimits{Requests: 1000}, false, false, "", "", false},
+		{"no terminal", forced, false, false, "y\n", "pass -yes", false},
+		{"answered no", forced, false, true, "n\n", "aborted", true},
+		{"no answer", forced, false, true, "", "aborted", true},
+		{"answered yes", forced, false, true, "yes\n", "", true},
+		{"over the cost", confirmLimits{Requests: 1000, Cost: 0, PricePerMTok: 1}, false, true, "n\n", "aborted", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := confirmExpensiveRun([]string{input}, cfg, tt.limits, tt.yes, strings.NewReader(tt.answer), &out, tt.interactive)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+			if asked := strings.Contains(out.String(), "Continue?"); asked != tt.wantAsked {
+				t.Errorf("asked %v, want %v:\n%s", asked, tt.wantAsked, out.String())
+			}
+			if tt.yes && out.Len() > 0 {
+				t.Errorf("-yes printed an estimate:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestConfirmRunShowsEstimate(t *testing.T) {
+	var out strings.Builder
+	e := runEstimate{Files: 3, Blocks: 40, Requests: 12, Tokens: 2_000_000}
+	if err := confirmRun(e, 1.5, strings.NewReader("n\n"), &out, true); err == nil {
+		t.Fatal("answering no didn't abort")
+	}
+	want := "About to translate 3 files with 40 blocks in 12 requests, about 2000000 tokens (estimated cost 3.00)."
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("got %q, want it to contain %q", out.String(), want)
+	}
+}
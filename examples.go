@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Limits for -examples, which are sent with every request.
+const (
+	maxExamples      = 20
+	maxExampleTokens = 2000
+)
+
+// Example is a known-good translation shown to the model before the block.
+type Example struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// Examples are few-shot turns, see buildPayload.
+type Examples []Example
+
+// loadExamples reads a YAML list of mappings with a source and a target,
+// both HTML like the blocks they stand for.
+// Examples beyond maxExamples or maxExampleTokens are dropped with a warning.
+func loadExamples(path string) (Examples, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var all Examples
+	if err := yaml.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	var examples Examples
+	tokens := 0
+	for i, e := range all {
+		e.Source, e.Target = strings.TrimSpace(e.Source), strings.TrimSpace(e.Target)
+		if e.Source == "" || e.Target == "" {
+			return nil, fmt.Errorf("%s: example %d needs a source and a target", path, i+1)
+		}
+
+		tokens += estimateTokens(e.Source) + estimateTokens(e.Target)
+		if len(examples) == maxExamples || tokens > maxExampleTokens {
+			log.Printf("Warning: using only the first %d of %d examples, the rest would make every request too long", len(examples), len(all))
+			break
+		}
+		examples = append(examples, e)
+	}
+	return examples, nil
+}
+
+// messages returns the examples as alternating user and assistant turns.
+func (ex Examples) messages() []map[string]string {
+	var messages []map[string]string
+	for _, e := range ex {
+		messages = append(messages,
+			map[string]string{"role": "user", "content": e.Source},
+			map[string]string{"role": "assistant", "content": e.Target})
+	}
+	return messages
+}
+
+// cacheSuffix is added to the prompt of the cache key, so translations made
+// with other examples (or none) aren't reused.
+func (ex Examples) cacheSuffix() string {
+	if len(ex) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, e := range ex {
+		h.Write([]byte(e.Source))
+		h.Write([]byte{0})
+		h.Write([]byte(e.Target))
+		h.Write([]byte{0})
+	}
+	return "\x00examples:" + hex.EncodeToString(h.Sum(nil))
+}
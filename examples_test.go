@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExamplesInPayload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "examples.yaml")
+	os.WriteFile(path, []byte(`- source: "<em>Spice</em> must flow."
+  target: "Das <em>Spice</em> muss fließen."
+- source: Fear is the mind-killer.
+  target: Die Angst tötet das Bewusstsein.
+`), 0o644)
+	examples, err := loadExamples(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.Examples = examples
+	if _, err := translate(t, testBook(`<p>Text.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := api.payload(0)["messages"].([]any)
+	var turns [][2]string
+	for _, m := range messages {
+		m, _ := m.(map[string]any)
+		role, _ := m["role"].(string)
+		content, _ := m["content"].(string)
+		turns = append(turns, [2]string{role, content})
+	}
+	want := [][2]string{
+		{"user", "<em>Spice</em> must flow."},
+		{"assistant", "Das <em>Spice</em> muss fließen."},
+		{"user", "Fear is the mind-killer."},
+		{"assistant", "Die Angst tötet das Bewusstsein."},
+	}
+	if len(turns) != len(want)+2 || !reflect.DeepEqual(turns[1:len(turns)-1], want) {
+		t.Fatalf("got turns %q, want the system prompt, %q and the block", turns, want)
+	}
+	if last := turns[len(turns)-1]; last != [2]string{"user", api.requests()[0]} {
+		t.Errorf("the block isn't the last user turn: %q", last)
+	}
+}
+
+func TestExamplesAreCapped(t *testing.T) {
+	var data strings.Builder
+	for i := range maxExamples + 5 {
+		fmt.Fprintf(&data, "- source: Source %d.\n  target: Target %d.\n", i, i)
+	}
+	path := filepath.Join(t.TempDir(), "examples.yaml")
+	os.WriteFile(path, []byte(data.String()), 0o644)
+	examples, err := loadExamples(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(examples) != maxExamples {
+		t.Errorf("got %d examples, want %d", len(examples), maxExamples)
+	}
+
+	os.WriteFile(path, []byte("- source: Only a source.\n"), 0o644)
+	if _, err := loadExamples(path); err == nil {
+		t.Error("no error for an example without a target")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func validateExtension(ext string) error {
+	switch ext {
+	case "", "xhtml", "html":
+		return nil
+	}
+	return fmt.Errorf("unknown -flatten-xhtml-extensions %q, expected xhtml or html", ext)
+}
+
+// extensionRenames maps the content files that don't have the extension ext
+// ("xhtml" or "html") to their new entry names. Encrypted files keep their
+// names, as does a file whose new name is already taken.
+func extensionRenames(files []*zip.File, ext string, encrypted map[string]bool) map[string]string {
+	if ext == "" {
+		return nil
+	}
+
+	names := make(map[string]bool, len(files))
+	for _, f := range files {
+		names[f.Name] = true
+	}
+
+	renames := make(map[string]string)
+	for _, f := range files {
+		old := filepath.Ext(f.Name)
+		if !isTranslatable(f.Name) || strings.EqualFold(old, "."+ext) || encrypted[f.Name] {
+			continue
+		}
+
+		name := strings.TrimSuffix(f.Name, old) + "." + ext
+		if names[name] {
+			log.Printf("Warning: not renaming %s, %s already exists", f.Name, name)
+			continue
+		}
+		renames[f.Name] = name
+	}
+	return renames
+}
+
+// hasContentLinks reports whether name is a file type that can link to
+// content files.
+func hasContentLinks(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xhtml", ".html", ".htm", ".opf", ".ncx", ".smil", ".svg":
+		return true
+	}
+	return false
+}
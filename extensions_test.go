@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlattenExtensions(t *testing.T) {
+	// testBook with .html chapters, linking to each other
+	var book []zipEntry
+	for _, e := range testBook(`<p>See <a href="ch2.html#s1">the next chapter</a>.</p><link href="../style.css" rel="stylesheet"/>`, `<h2 id="s1">Second</h2><p>Back to <a href="ch1.html">the start</a>.</p>`) {
+		e.name = strings.Replace(e.name, "text/ch1.xhtml", "text/ch1.html", 1)
+		e.name = strings.Replace(e.name, "text/ch2.xhtml", "text/ch2.html", 1)
+		e.data = strings.ReplaceAll(e.data, `ch1.xhtml"`, `ch1.html"`)
+		e.data = strings.ReplaceAll(e.data, `ch2.xhtml"`, `ch2.html"`)
+		book = append(book, e)
+	}
+	book = append(book, zipEntry{"OEBPS/style.css", "p { margin: 0 }"})
+
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.FlattenExtensions = "xhtml"
+	out, err := translate(t, book, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range sortedKeys(out) {
+		if strings.HasSuffix(name, ".html") {
+			t.Errorf("%s wasn't renamed", name)
+		}
+	}
+	checks := map[string][]string{
+		"OEBPS/content.opf": {`href="text/ch1.xhtml"`, `href="text/ch2.xhtml"`},
+		"OEBPS/nav.xhtml":   {`href="text/ch1.xhtml"`, `href="text/ch2.xhtml"`},
+		"OEBPS/toc.ncx":     {`src="text/ch1.xhtml"`, `src="text/ch2.xhtml"`},
+		chapterName(1):      {`href="ch2.xhtml#s1"`, `href="../style.css"`, "[T]See"},
+		chapterName(2):      {`href="ch1.xhtml"`},
+	}
+	for name, wants := range checks {
+		for _, want := range wants {
+			if !strings.Contains(out[name], want) {
+				t.Errorf("%s lacks %s:\n%s", name, want, out[name])
+			}
+		}
+	}
+	for name, data := range out {
+		// The manifest of the translator records the names of the sources
+		if name != translatorManifestName && strings.Contains(data, `.html"`) || strings.Contains(data, ".html#") {
+			t.Errorf("%s still links to a .html file:\n%s", name, data)
+		}
+	}
+}
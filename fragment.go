@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// blockElements must not appear in a translation unless the source had them:
+// the parser would happily nest them (a <p> inside the <p> being translated),
+// producing a document that no longer round-trips.
+var blockElements = map[string]bool{
+	"html": true, "head": true, "body": true, "p": true, "div": true, "section": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "table": true, "tr": true, "td": true,
+}
+
+// checkFragment verifies that the model's output can replace the inner HTML of
+// the source block: every tag is closed in the right order and it doesn't
+// introduce block elements the source didn't have. The HTML parser never
+// fails, so without this check a broken fragment would be silently "repaired"
+// into something else. A translation without any text is rejected too.
+func checkFragment(source, translated string) error {
+	if emptyTranslation(source, translated) {
+		return fmt.Errorf("no text in the translation")
+	}
+
+	sourceTags := make(map[string]bool)
+	z := html.NewTokenizer(strings.NewReader(source))
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			name, _ := z.TagName()
+			sourceTags[string(name)] = true
+		}
+	}
+
+	var open []string
+	z = html.NewTokenizer(strings.NewReader(translated))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			if len(open) > 0 {
+				return fmt.Errorf("unclosed <%s>", open[len(open)-1])
+			}
+			return nil
+
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if blockElements[tag] && !sourceTags[tag] {
+				return fmt.Errorf("unexpected <%s>", tag)
+			}
+			if !voidElements[tag] {
+				open = append(open, tag)
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if voidElements[tag] {
+				continue
+			}
+			if len(open) == 0 || open[len(open)-1] != tag {
+				return fmt.Errorf("unexpected </%s>", tag)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckFragment(t *testing.T) {
+	tests := []struct {
+		source, translated string
+		ok                 bool
+	}{
+		{"Hello <em>world</em>.", "Hallo <em>Welt</em>.", true},
+		{"Line<br/>break", "Zeilen<br/>umbruch", true},
+		{"Hello <em>world</em>.", "Hallo <em>Welt.", false},
+		{"Hello <em>world</em>.", "Hallo </em>Welt<em>.", false},
+		{"<b><i>x</i></b>", "<b><i>y</b></i>", false},
+		{"Text.", "<p>Text.</p>", false},
+		{"<p>Text.</p>", "<p>Text.</p>", true},
+		{"Text.", "<em></em>", false},
+	}
+	for _, tt := range tests {
+		if err := checkFragment(tt.source, tt.translated); (err == nil) != tt.ok {
+			t.Errorf("checkFragment(%q, %q) = %v, want ok %v", tt.source, tt.translated, err, tt.ok)
+		}
+	}
+}
+
+func TestMalformedTranslationIsAFailure(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		if strings.Contains(content, "world") {
+			return 200, "Hallo <em>Welt."
+		}
+		return prefixReply(content)
+	})
+	cfg := testConfig(api.URL)
+	cfg.Report = newReport(filepath.Join(t.TempDir(), "report.json"))
+	out, err := translate(t, testBook(`<p>Hello <em>world</em>.</p><p>Fine.</p>`), cfg)
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("got %v, want ErrIncomplete", err)
+	}
+
+	chapter := out[chapterName(1)]
+	if !strings.Contains(chapter, "<p>Hello <em>world</em>.") || !strings.Contains(chapter, "Translation failed") {
+		t.Errorf("the block doesn't have its original text and a failure marker:\n%s", chapter)
+	}
+	if !strings.Contains(chapter, "<p>[T]Fine.</p>") {
+		t.Errorf("the other block isn't translated:\n%s", chapter)
+	}
+
+	failures := cfg.Report.Books[0].Failures
+	if len(failures) != 1 || failures[0].File != chapterName(1) || !strings.Contains(failures[0].Error, "malformed HTML") {
+		t.Errorf("got report failures %+v", failures)
+	}
+	if n := api.requested("world"); n != 2 {
+		t.Errorf("sent the block %d times, want 2: malformed responses are retried once", n)
+	}
+}
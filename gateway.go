@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// requestTemplate is the request body of -request-template: any JSON
+// object, in which the strings "{{model}}", "{{system}}", "{{content}}",
+// "{{temperature}}" and "{{messages}}" are replaced by the model, the system
+// prompt, the text to translate, the temperature and the OpenAI-style
+// message list (system prompt, examples and text). The first three may
+// also be part of a longer string.
+type requestTemplate struct {
+	body map[string]interface{}
+}
+
+func loadRequestTemplate(path string) (*requestTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read request template: %w", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("could not parse request template %s: %w", path, err)
+	}
+	return &requestTemplate{body: body}, nil
+}
+
+// payload fills in the template. The template itself is left unchanged, so
+// it can be used for concurrent requests.
+func (t *requestTemplate) payload(systemPrompt, content string, cfg *Config) map[string]interface{} {
+	text := strings.NewReplacer("{{model}}", cfg.Model, "{{system}}", systemPrompt, "{{content}}", content)
+
+	var fill func(v interface{}) (interface{}, bool)
+	fill = func(v interface{}) (interface{}, bool) {
+		switch v := v.(type) {
+		case string:
+			switch v {
+			case "{{messages}}":
+				return buildPayload(systemPrompt, content, cfg)["messages"], true
+			case "{{temperature}}":
+				// Without -temperature, the field is left out
+				if cfg.Temperature == nil {
+					return nil, false
+				}
+				return *cfg.Temperature, true
+			}
+			return text.Replace(v), true
+		case map[string]interface{}:
+			m := make(map[string]interface{}, len(v))
+			for key, value := range v {
+				if filled, ok := fill(value); ok {
+					m[key] = filled
+				}
+			}
+			return m, true
+		case []interface{}:
+			list := make([]interface{}, 0, len(v))
+			for _, value := range v {
+				if filled, ok := fill(value); ok {
+					list = append(list, filled)
+				}
+			}
+			return list, true
+		}
+		return v, true
+	}
+
+	filled, _ := fill(t.body)
+	return filled.(map[string]interface{})
+}
+
+// responsePath is the location of the translation in a response body
+// (-response-path): object keys and array indexes separated by dots, e.g.
+// "choices.0.message.content" or "output.text".
+type responsePath []string
+
+func parseResponsePath(s string) (responsePath, error) {
+	path := responsePath(strings.Split(s, "."))
+	for _, key := range path {
+		if key == "" {
+			return nil, fmt.Errorf("invalid -response-path %q, expected keys separated by dots", s)
+		}
+	}
+	return path, nil
+}
+
+// text returns the translation at the path in body. The value may be a
+// string or an array of content parts, like a message content. It reports
+// false if the body has nothing there.
+func (p responsePath) text(body []byte) (string, bool) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", false
+	}
+	for _, key := range p {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	var content messageContent
+	if err := json.Unmarshal(raw, &content); err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(content)), true
+}
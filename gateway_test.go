@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCustomGateway(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		input := body["input"].(map[string]any)
+		text := input["text"].(string)
+		json.NewEncoder(w).Encode(map[string]any{
+			"output": map[string]any{"results": []any{map[string]any{"text": "[T]" + text}}},
+		})
+	}))
+	defer server.Close()
+
+	templatePath := filepath.Join(t.TempDir(), "template.json")
+	os.WriteFile(templatePath, []byte(`{"engine": "{{model}}", "input": {"instructions": "{{system}}", "text": "{{content}}"}, "temperature": "{{temperature}}", "tags": ["book", "{{model}}-run"]}`), 0o644)
+	template, err := loadRequestTemplate(templatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := parseResponsePath("output.results.0.text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(server.URL)
+	cfg.RequestTemplate = template
+	cfg.ResponsePath = path
+	out, err := translate(t, testBook(`<p>Hello world.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapter := out[chapterName(1)]; !strings.Contains(chapter, "<p>[T]Hello world.</p>") {
+		t.Errorf("translation wasn't taken from the configured path:\n%s", chapter)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var body map[string]any
+	for _, b := range bodies {
+		if b["input"].(map[string]any)["text"] == "Hello world." {
+			body = b
+		}
+	}
+	if body == nil {
+		t.Fatalf("the block wasn't sent in the template's field: %v", bodies)
+	}
+	if body["engine"] != "test-model" || !strings.Contains(body["input"].(map[string]any)["instructions"].(string), "German") {
+		t.Errorf("model or system prompt not filled in: %v", body)
+	}
+	if tags := body["tags"].([]any); len(tags) != 2 || tags[1] != "test-model-run" {
+		t.Errorf("placeholder inside a longer string not filled in: %v", tags)
+	}
+	if _, ok := body["temperature"]; ok {
+		t.Errorf("temperature sent without -temperature: %v", body)
+	}
+	if _, ok := body["messages"]; ok {
+		t.Errorf("the provider's request body was sent: %v", body)
+	}
+}
+
+func TestResponsePath(t *testing.T) {
+	tests := []struct {
+		path, body, want string
+		ok               bool
+	}{
+		{"output.text", `{"output":{"text":" Hallo "}}`, "Hallo", true},
+		{"choices.0.message.content", `{"choices":[{"message":{"content":[{"type":"text","text":"Hallo"}]}}]}`, "Hallo", true},
+		{"output.text", `{"output":{}}`, "", false},
+		{"results.1", `{"results":["Hallo"]}`, "", false},
+		{"results.x", `{"results":["Hallo"]}`, "", false},
+		{"output.text", `not json`, "", false},
+	}
+	for _, tt := range tests {
+		path, err := parseResponsePath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := path.text([]byte(tt.body)); got != tt.want || ok != tt.ok {
+			t.Errorf("%s in %s: got %q, %v, want %q, %v", tt.path, tt.body, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for _, invalid := range []string{"", "output..text", ".text"} {
+		if _, err := parseResponsePath(invalid); err == nil {
+			t.Errorf("%q: no error", invalid)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GlossaryEntry pins the translation of one term. A soft entry is only
+// passed to the model; a hard one is also enforced on the translation.
+type GlossaryEntry struct {
+	Source string
+	Target string
+	Hard   bool
+}
+
+// Glossary is a list of fixed term translations, typically names and
+// invented words that must be rendered the same way throughout a book or a
+// whole series.
+type Glossary []GlossaryEntry
+
+// loadGlossary reads a glossary file with one "source = target" pair per
+// line, or "source == target" for a hard entry. Empty lines and lines
+// starting with # are ignored.
+func loadGlossary(path string) (Glossary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var g Glossary
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		source, target, ok := strings.Cut(line, "=")
+		target, hard := strings.CutPrefix(target, "=")
+		source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+		if !ok || source == "" || target == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"source = target\"", path, lineNo)
+		}
+		g = append(g, GlossaryEntry{Source: source, Target: target, Hard: hard})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Longest terms first, so "New York Times" is listed before "New York"
+	sort.SliceStable(g, func(i, j int) bool { return len(g[i].Source) > len(g[j].Source) })
+	return g, nil
+}
+
+// promptFor lists the entries whose source term occurs in the block. Only
+// relevant terms are sent, which keeps the prompt short for large glossaries.
+func (g Glossary) promptFor(content string) string {
+	lower := strings.ToLower(content)
+
+	var lines []string
+	for _, e := range g {
+		if strings.Contains(lower, strings.ToLower(e.Source)) {
+			lines = append(lines, fmt.Sprintf("%s -> %s", e.Source, e.Target))
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Always use these translations for the following terms: " + strings.Join(lines, "; ") + "."
+}
+
+// enforce replaces the source terms of hard entries (all entries with all)
+// that the model left in the translation by their target. Only whole words
+// in text are replaced, not tag names or attribute values, and the case of
+// the occurrence is carried over: "DRAGON" or "Dragon" for "dragon".
+func (g Glossary) enforce(translated string, all bool) string {
+	lower := strings.ToLower(translated)
+	for _, e := range g {
+		if !e.Hard && !all || strings.EqualFold(e.Source, e.Target) || !strings.Contains(lower, strings.ToLower(e.Source)) {
+			continue
+		}
+		pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(e.Source))
+		translated = replaceInText(translated, func(text string) string {
+			return replaceWords(text, pattern, e.Target)
+		})
+	}
+	return translated
+}
+
+// replaceInText applies replace to the text between the tags of fragment.
+func replaceInText(fragment string, replace func(string) string) string {
+	var b strings.Builder
+	for fragment != "" {
+		open := strings.IndexByte(fragment, '<')
+		if open < 0 {
+			b.WriteString(replace(fragment))
+			break
+		}
+		b.WriteString(replace(fragment[:open]))
+		end := strings.IndexByte(fragment[open:], '>')
+		if end < 0 {
+			b.WriteString(fragment[open:])
+			break
+		}
+		b.WriteString(fragment[open : open+end+1])
+		fragment = fragment[open+end+1:]
+	}
+	return b.String()
+}
+
+// replaceWords replaces the matches of pattern in text that aren't part of
+// a longer word.
+func replaceWords(text string, pattern *regexp.Regexp, target string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range pattern.FindAllStringIndex(text, -1) {
+		before, _ := utf8.DecodeLastRuneInString(text[:m[0]])
+		after, _ := utf8.DecodeRuneInString(text[m[1]:])
+		if m[0] > 0 && isWordRune(before) || m[1] < len(text) && isWordRune(after) {
+			continue
+		}
+		b.WriteString(text[last:m[0]])
+		b.WriteString(matchCase(text[m[0]:m[1]], target))
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// matchCase gives target the case of the occurrence it replaces: all upper
+// case, or a capital first letter. Otherwise target is used as written.
+func matchCase(occurrence, target string) string {
+	if strings.ToUpper(occurrence) == occurrence && strings.ToLower(occurrence) != occurrence {
+		return strings.ToUpper(target)
+	}
+	first, _ := utf8.DecodeRuneInString(occurrence)
+	if unicode.IsUpper(first) {
+		r, size := utf8.DecodeRuneInString(target)
+		return string(unicode.ToUpper(r)) + target[size:]
+	}
+	return target
+}
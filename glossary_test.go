@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlossaryEnforce(t *testing.T) {
+	g := Glossary{
+		{Source: "dragon", Target: "Lindwurm", Hard: true},
+		{Source: "spice", Target: "Gewürz", Hard: true},
+		{Source: "castle", Target: "Burg"},
+	}
+	tests := []struct {
+		name, in, want string
+	}{
+		{"lower case", "Der dragon fliegt.", "Der Lindwurm fliegt."},
+		{"capitalized", "Dragon fliegt.", "Lindwurm fliegt."},
+		{"upper case", "DER DRAGON!", "DER LINDWURM!"},
+		{"target as written", "ein dragon", "ein Lindwurm"},
+		{"part of a longer word", "dragonfly und Snapdragon", "dragonfly und Snapdragon"},
+		{"attribute values", `<a href="dragon.xhtml" title="dragon">dragon</a>`, `<a href="dragon.xhtml" title="dragon">Lindwurm</a>`},
+		{"tag names", `<dragon>Text</dragon>`, `<dragon>Text</dragon>`},
+		{"soft entry", "Die castle steht.", "Die castle steht."},
+		{"several terms", "<em>spice</em> und dragon", "<em>Gewürz</em> und Lindwurm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.enforce(tt.in, false); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := g.enforce("Die castle steht.", true); got != "Die Burg steht." {
+		t.Errorf("enforcing all entries: got %q", got)
+	}
+}
+
+func TestLoadGlossary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glossary.txt")
+	os.WriteFile(path, []byte("# Names\nNew York = New York\nNew York Times == New York Times\n\nspice == Gewürz\n"), 0o644)
+	g, err := loadGlossary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Glossary{
+		{Source: "New York Times", Target: "New York Times", Hard: true},
+		{Source: "New York", Target: "New York"},
+		{Source: "spice", Target: "Gewürz", Hard: true},
+	}
+	if len(g) != len(want) {
+		t.Fatalf("got %+v, want %+v", g, want)
+	}
+	for i := range want {
+		if g[i] != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, g[i], want[i])
+		}
+	}
+
+	os.WriteFile(path, []byte("no separator\n"), 0o644)
+	if _, err := loadGlossary(path); err == nil {
+		t.Error("no error for a line without a separator")
+	}
+}
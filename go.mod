@@ -4,10 +4,9 @@ go 1.24.2
 
 require (
 	github.com/PuerkitoBio/goquery v1.11.0
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/joho/godotenv v1.5.1
-)
-
-require (
-	github.com/andybalholm/cascadia v1.3.3 // indirect
-	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/net v0.47.0
+	golang.org/x/sync v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// healthCheckText is what -health-check asks the model to translate.
+const healthCheckText = "Good morning."
+
+// healthCheckTimeout bounds the request of -health-check.
+const healthCheckTimeout = time.Minute
+
+// healthCheckAttempts is how often -health-check tries a server error or an
+// unreachable API before it gives up.
+const healthCheckAttempts = 3
+
+// checkHealth sends one small translation request, so a wrong key, URL or
+// model stops the run before the first book instead of failing every one of
+// its blocks. Only answers that say the configuration is wrong (400, 401,
+// 403, 404) stop the run. Server errors and network errors are tried again
+// and, if they persist, only warned about, since the blocks have retries of
+// their own; a rate-limited answer passes, since it means the credentials
+// were accepted.
+func checkHealth(cfg *Config) error {
+	body, _ := json.Marshal(requestPayload(blockPrompt(healthCheckText, "", cfg), healthCheckText, cfg))
+	delay := cfg.RetryDelay
+
+	var problem string
+	for attempt := 1; attempt <= healthCheckAttempts; attempt++ {
+		start := time.Now()
+		status, respBody, info, err := sendHealthCheck(body, cfg)
+		switch {
+		case errors.Is(err, errInvalidURL):
+			return fmt.Errorf("%w: invalid API URL %q: %v", ErrTranslation, cfg.APIURL, err)
+		case err != nil:
+			problem = err.Error()
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return fmt.Errorf("%w, check GEMINI_API_KEY (%s)", ErrAuth, info)
+		case status == http.StatusNotFound:
+			return fmt.Errorf("%w: nothing found at %s, check GEMINI_API_URL and the model %q (%s)", ErrTranslation, cfg.APIURL, cfg.Model, info)
+		case status == http.StatusBadRequest:
+			return fmt.Errorf("%w: the request was rejected, check the model %q (%s)", ErrTranslation, cfg.Model, info)
+		case status == http.StatusTooManyRequests:
+			log.Printf("Health check: the API is rate limiting (%s), continuing", info)
+			return nil
+		case status != http.StatusOK:
+			problem = info
+		default:
+			if translated, ok := responseText(respBody, healthCheckText, cfg); !ok || translated == "" {
+				log.Printf("Warning: the health check response contains no translation, check -provider and -response-path (%s)", info)
+				return nil
+			}
+			log.Printf("Health check passed: %s answered in %v", cfg.Model, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		if attempt < healthCheckAttempts {
+			log.Printf("Health check failed (%s), trying again in %v", problem, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	log.Printf("Warning: the health check failed %d times (%s), continuing anyway", healthCheckAttempts, problem)
+	return nil
+}
+
+// errInvalidURL means the API URL can't be used for a request at all.
+var errInvalidURL = errors.New("invalid URL")
+
+// sendHealthCheck sends the health check request body and returns the
+// status and body of the answer, along with both for the log.
+func sendHealthCheck(body []byte, cfg *Config) (int, []byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	req, err := newAPIRequest(ctx, body, cfg)
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("%w: %v", errInvalidURL, err)
+	}
+	resp, err := cfg.doRequest(req)
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("could not reach %s: %v", cfg.APIURL, err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("could not read the response: %v", err)
+	}
+
+	info := fmt.Sprintf("status %d", resp.StatusCode)
+	if snippet := respBody[:min(len(respBody), maxLoggedBody)]; len(snippet) > 0 {
+		info += " - " + logSnippet(snippet, cfg)
+	}
+	return resp.StatusCode, respBody, info, nil
+}
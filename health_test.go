@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckHealth(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []fakeResponse
+		wantErr   error
+		wantCalls int
+	}{
+		{"passes", []fakeResponse{okResponse("Guten Morgen.")}, nil, 1},
+		{"bad model", []fakeResponse{{status: 404, body: `{"error":"model not found"}`}}, ErrTranslation, 1},
+		{"rejected request", []fakeResponse{{status: 400}}, ErrTranslation, 1},
+		{"bad key", []fakeResponse{{status: 401}}, ErrAuth, 1},
+		{"forbidden", []fakeResponse{{status: 403}}, ErrAuth, 1},
+		{"rate limited", []fakeResponse{{status: 429}}, nil, 1},
+		{"500 once", []fakeResponse{{status: 500}, okResponse("Guten Morgen.")}, nil, 2},
+		{"500 every time", []fakeResponse{{status: 503}}, nil, healthCheckAttempts},
+		{"unreachable", []fakeResponse{{err: errors.New("connection refused")}}, nil, healthCheckAttempts},
+		{"no translation", []fakeResponse{{status: 200, body: `{}`}}, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			cfg := testConfig("http://api.invalid/v1/chat/completions")
+			cfg.DoRequest = fakeAPI(tt.responses, &calls)
+
+			err := checkHealth(cfg)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
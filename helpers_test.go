@@ -0,0 +1,308 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	requestSpacing = 0
+	os.Exit(m.Run())
+}
+
+// testConfig returns the settings of a run with the default flags against
+// the API at url. Retries wait a millisecond instead of seconds.
+func testConfig(url string) *Config {
+	return &Config{
+		Provider:           providerOpenAI,
+		APIKey:             "test-api-key-123456",
+		APIURL:             url,
+		Model:              "test-model",
+		TargetLang:         "German",
+		Role:               roleAuto,
+		BestOf:             1,
+		UserAgent:          defaultUserAgent(),
+		RunID:              "test-run",
+		HTTPClient:         newHTTPClient(0),
+		LineEndings:        lineEndingsLF,
+		BOM:                bomStrip,
+		MinTextLength:      2,
+		LengthRatioMin:     0.3,
+		LengthRatioMax:     3,
+		KeepMediaStructure: true,
+		OversizedAction:    oversizedCopy,
+		RetryDelay:         time.Millisecond,
+		Abort:              &abortSignal{},
+		OriginalClass:      "original",
+		UpdateModified:     true,
+		Concurrency:        1,
+		NodeConcurrency:    1,
+	}
+}
+
+// stubAPI is an OpenAI-compatible chat completions API for tests. It answers
+// every request with reply, which is given the user turn, i.e. the block.
+type stubAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	contents []string
+	payloads []map[string]any
+	headers  []http.Header
+}
+
+// prefixReply is the default reply: the block with "[T]" in front.
+func prefixReply(content string) (int, string) {
+	return http.StatusOK, "[T]" + content
+}
+
+func newStubAPI(t *testing.T, reply func(content string) (status int, answer string)) *stubAPI {
+	t.Helper()
+	if reply == nil {
+		reply = prefixReply
+	}
+	s := &stubAPI{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		content := lastUserTurn(payload)
+
+		s.mu.Lock()
+		s.contents = append(s.contents, content)
+		s.payloads = append(s.payloads, payload)
+		s.headers = append(s.headers, r.Header.Clone())
+		s.mu.Unlock()
+
+		status, answer := reply(content)
+		if status != http.StatusOK {
+			http.Error(w, `{"error":"stub"}`, status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": answer}}},
+			"usage":   map[string]any{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func lastUserTurn(payload map[string]any) string {
+	messages, _ := payload["messages"].([]any)
+	if len(messages) == 0 {
+		return ""
+	}
+	last, _ := messages[len(messages)-1].(map[string]any)
+	content, _ := last["content"].(string)
+	return content
+}
+
+// requests returns the user turn of every request so far, in order.
+func (s *stubAPI) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.contents...)
+}
+
+// payload returns the decoded body of the i-th request.
+func (s *stubAPI) payload(i int) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.payloads[i]
+}
+
+// systemPrompt returns the first message of the i-th request, the
+// instructions for the model.
+func (s *stubAPI) systemPrompt(i int) string {
+	messages, _ := s.payload(i)["messages"].([]any)
+	first, _ := messages[0].(map[string]any)
+	content, _ := first["content"].(string)
+	return content
+}
+
+// systemPromptFor returns the system prompt of the first request whose
+// user turn contains text, or "" if there is none.
+func (s *stubAPI) systemPromptFor(text string) string {
+	for i, c := range s.requests() {
+		if strings.Contains(c, text) {
+			return s.systemPrompt(i)
+		}
+	}
+	return ""
+}
+
+// requested reports how many requests contained text.
+func (s *stubAPI) requested(text string) int {
+	n := 0
+	for _, c := range s.requests() {
+		if strings.Contains(c, text) {
+			n++
+		}
+	}
+	return n
+}
+
+// zipEntry is a file of a test EPUB.
+type zipEntry struct {
+	name, data string
+}
+
+// xhtml wraps body into a content document.
+func xhtml(body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><head><title>Test</title></head><body>` + body + `</body></html>`
+}
+
+// chapterName is the entry name of the i-th chapter of testBook, from 1.
+func chapterName(i int) string {
+	return fmt.Sprintf("OEBPS/text/ch%d.xhtml", i)
+}
+
+// testBook returns the entries of an EPUB 3 with an NCX, a nav document, an
+// image and the given chapter bodies in the spine, titled "Chapter N" in
+// the tables of contents.
+func testBook(chapters ...string) []zipEntry {
+	var manifest, spine, nav, ncx strings.Builder
+	for i := range chapters {
+		n := i + 1
+		fmt.Fprintf(&manifest, `<item id="c%d" href="text/ch%d.xhtml" media-type="application/xhtml+xml"/>`, n, n)
+		fmt.Fprintf(&spine, `<itemref idref="c%d"/>`, n)
+		fmt.Fprintf(&nav, `<li><a href="text/ch%d.xhtml">Chapter %d</a></li>`, n, n)
+		fmt.Fprintf(&ncx, `<navPoint id="n%d" playOrder="%d"><navLabel><text>Chapter %d</text></navLabel><content src="text/ch%d.xhtml"/></navPoint>`, n, n, n, n)
+	}
+
+	entries := []zipEntry{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:identifier id="id">test-book</dc:identifier><dc:title>Test Book</dc:title><dc:language>en</dc:language><meta property="dcterms:modified">2020-01-01T00:00:00Z</meta></metadata>
+<manifest><item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/><item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>` + manifest.String() + `<item id="img" href="img/a.png" media-type="image/png"/></manifest>
+<spine toc="ncx">` + spine.String() + `</spine></package>`},
+		{"OEBPS/nav.xhtml", xhtml(`<nav epub:type="toc"><ol>` + nav.String() + `</ol></nav>`)},
+		{"OEBPS/toc.ncx", `<?xml version="1.0" encoding="utf-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><head><meta name="dtb:uid" content="test-book"/></head><docTitle><text>Test Book</text></docTitle><navMap>` + ncx.String() + `</navMap></ncx>`},
+	}
+	for i, body := range chapters {
+		entries = append(entries, zipEntry{chapterName(i + 1), xhtml(body)})
+	}
+	return append(entries, zipEntry{"OEBPS/img/a.png", "\x89PNG\r\n\x1a\n"})
+}
+
+// replaceEntry returns entries with the data of name replaced, or added.
+func replaceEntry(entries []zipEntry, name, data string) []zipEntry {
+	out := append([]zipEntry(nil), entries...)
+	for i := range out {
+		if out[i].name == name {
+			out[i].data = data
+			return out
+		}
+	}
+	return append(out, zipEntry{name, data})
+}
+
+// writeZip writes entries as a zip file to dir and returns its path.
+func writeZip(t *testing.T, dir, name string, entries []zipEntry) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		method := zip.Deflate
+		if e.name == "mimetype" {
+			method = zip.Store
+		}
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readEntries returns the entries of the zip file at path by name.
+func readEntries(t *testing.T, path string) map[string]string {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	entries := make(map[string]string)
+	for _, f := range r.File {
+		data, err := readZipFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+// entryNames returns the names of the entries of the zip file at path, in
+// the order they are stored.
+func entryNames(t *testing.T, path string) []string {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// translate runs processEpub on entries and returns the entries of the
+// output, along with the error of the run.
+func translate(t *testing.T, entries []zipEntry, cfg *Config) (map[string]string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", entries)
+	output := filepath.Join(dir, "out.epub")
+	err := processEpub(input, output, cfg)
+	if _, statErr := os.Stat(output); statErr != nil {
+		return nil, err
+	}
+	return readEntries(t, output), err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runPostHook pipes a translated file through the user's -post-hook command.
+//
+// Contract: the command runs via "sh -c" once per translated (X)HTML file. It
+// receives the serialized file on stdin and must write the full replacement
+// content to stdout. EPUB_TRANSLATOR_FILE holds the entry name inside the
+// EPUB and TARGET_LANGUAGE the target language. A nonzero exit status or
+// empty output means the hook failed: the file is kept as translated and a
+// warning is logged, or, with -post-hook-strict, the run is aborted.
+func runPostHook(name string, data []byte, cfg *Config) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", cfg.PostHook)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"EPUB_TRANSLATOR_FILE="+name,
+		"TARGET_LANGUAGE="+cfg.TargetLang,
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil && stdout.Len() == 0 {
+		err = fmt.Errorf("no output")
+	}
+
+	if err != nil {
+		err = fmt.Errorf("post-hook failed for %s: %w %s", name, err, strings.TrimSpace(stderr.String()))
+		if cfg.PostHookStrict {
+			return nil, err
+		}
+		cfg.logf("  -> %v, keeping unmodified translation", err)
+		return data, nil
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPostHook(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.PostHook = `tr a-z A-Z; echo "<!-- $EPUB_TRANSLATOR_FILE $TARGET_LANGUAGE -->"`
+	out, err := translate(t, testBook(`<p>Some text.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	if !strings.Contains(chapter, "<P>[T]SOME TEXT.</P>") {
+		t.Errorf("the hook's output isn't the chapter:\n%s", chapter)
+	}
+	if !strings.Contains(chapter, "<!-- "+chapterName(1)+" German -->") {
+		t.Errorf("the hook didn't get the file name and language:\n%s", chapter)
+	}
+	if out["OEBPS/img/a.png"] != "\x89PNG\r\n\x1a\n" {
+		t.Error("the hook ran on an image")
+	}
+}
+
+func TestPostHookFailure(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.PostHook = "exit 3"
+	out, err := translate(t, testBook(`<p>Some text.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out[chapterName(1)], "<p>[T]Some text.</p>") {
+		t.Errorf("a failed hook didn't keep the translation:\n%s", out[chapterName(1)])
+	}
+
+	cfg = testConfig(api.URL)
+	cfg.PostHook = "exit 3"
+	cfg.PostHookStrict = true
+	if _, err := translate(t, testBook(`<p>Some text.</p>`), cfg); err == nil || errors.Is(err, ErrIncomplete) {
+		t.Errorf("got %v, want the run to fail with -post-hook-strict", err)
+	}
+}
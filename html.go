@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// translatableSelector matches the elements whose inner HTML is sent to the model.
+// A <blockquote> is sent as a whole, so its paragraphs and the <cite> of its
+// attribution are translated together, in one voice. The same goes for a
+// definition list's <dd>. A <q> or an <abbr> is usually part of a paragraph
+// and translated with it, but it can also stand outside any block.
+const translatableSelector = "p, h1, h2, h3, h4, h5, h6, li, span, figcaption, caption, th, td, blockquote, cite, q, abbr, summary, dt, dd"
+
+// blockSelector is the selector of the blocks to translate: -only-selector
+// if set, translatableSelector otherwise.
+func (cfg *Config) blockSelector() string {
+	if cfg.OnlySelector != "" {
+		return cfg.OnlySelector
+	}
+	return translatableSelector
+}
+
+// hasTranslatableText reports whether a block contains anything to
+// translate: letters (not just numbers or symbols, as in many table cells)
+// outside of code, and at least cfg.MinTextLength characters other than
+// whitespace there, so drop caps and stray punctuation are left alone.
+func hasTranslatableText(s *goquery.Selection, cfg *Config) bool {
+	letters, length := false, 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				letters = letters || hasLetters(c.Data)
+				for _, r := range c.Data {
+					if !unicode.IsSpace(r) {
+						length++
+					}
+				}
+			case c.Type == html.ElementNode && (c.Data == "code" || c.Data == "pre" || isNoTranslate(c)):
+				// Code in a cell or paragraph is sent along, but doesn't make it
+				// worth translating on its own
+			case c.Type == html.ElementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(s.Get(0))
+	return letters && length >= cfg.MinTextLength
+}
+
+func hasSelectedAncestor(n *html.Node, selected map[*html.Node]bool) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if selected[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// maxNestingDepth is the element depth beyond which a document is reported
+// as likely malformed, e.g. by a converter that never closes its spans. The
+// HTML parser rejects documents nested more than 512 levels deep.
+const maxNestingDepth = 256
+
+// outermostBlocks returns the selected nodes below root that are translated
+// as blocks of their own: those without a selected ancestor that aren't
+// inside an excluded part of the document (translate="no", an epub:case,
+// whose MathML or the like stays as it is, with -keep-media-structure, an
+// index with -translate-index). It walks the
+// tree once without recursion, so deeply nested or very wide documents
+// take linear time, and also returns the deepest nesting level it found.
+func outermostBlocks(root *html.Node, selected map[*html.Node]bool, cfg *Config) (map[*html.Node]bool, int) {
+	type frame struct {
+		n        *html.Node
+		depth    int
+		excluded bool // an ancestor is selected or excluded
+	}
+
+	keep := make(map[*html.Node]bool, len(selected))
+	maxDepth := 0
+	stack := []frame{{n: root}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDepth = max(maxDepth, f.depth)
+
+		n := f.n
+		excluded := f.excluded || n.Type == html.ElementNode && (isNoTranslate(n) ||
+			cfg.KeepMediaStructure && n.Data == "epub:case" ||
+			cfg.TranslateIndex && hasEpubType(n, "index"))
+		if selected[n] && !excluded {
+			keep[n] = true
+		}
+
+		for c := n.LastChild; c != nil; c = c.PrevSibling {
+			if c.Type == html.ElementNode {
+				stack = append(stack, frame{c, f.depth + 1, excluded || selected[n]})
+			}
+		}
+	}
+	return keep, maxDepth
+}
+
+// figureContext describes the image a <figcaption> belongs to, using the alt
+// texts of the images in the same <figure>.
+func figureContext(caption *goquery.Selection) string {
+	var alts []string
+	caption.Closest("figure").Find("img[alt]").Each(func(i int, img *goquery.Selection) {
+		if alt := strings.TrimSpace(img.AttrOr("alt", "")); alt != "" {
+			alts = append(alts, alt)
+		}
+	})
+
+	if len(alts) == 0 {
+		return ""
+	}
+	return "This is the caption of an image described as: " + strings.Join(alts, "; ")
+}
+
+// blockFailure is a block that kept its source text because it could not be
+// translated. Path locates the element inside File, see nodePath; Spine is
+// the file's reading position, if known.
+type blockFailure struct {
+	File  string
+	Path  string
+	Spine int
+	Err   error
+}
+
+// translateHTML translates the document read from r and writes it to w.
+// Blocks that fail to translate don't abort the file; they are returned so the
+// caller can report them.
+func translateHTML(r io.Reader, w io.Writer, cfg *Config) ([]blockFailure, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d, selection, err := parseHTML(source, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MarkPlaceholders {
+		cfg.logf("  -> Marked %d blocks for translation", markPlaceholders(selection, cfg))
+	} else {
+		selection = d.prepare(selection, cfg)
+
+		var blockFailures []blockFailure
+		if cfg.BatchTokenBudget > 0 {
+			blockFailures = translateBatched(selection, cfg)
+		} else if cfg.NodeConcurrency > 1 && cfg.Session == nil {
+			// A session needs the blocks before a caption translated first
+			blockFailures = translateConcurrently(selection, cfg)
+		} else {
+			selection.Each(func(i int, s *goquery.Selection) {
+				if f := translateBlock(s, cfg); f != nil {
+					blockFailures = append(blockFailures, *f)
+				}
+			})
+		}
+		d.finish(selection, blockFailures, cfg)
+	}
+
+	htmlStr, err := d.render()
+	if err != nil {
+		return d.failures, err
+	}
+
+	_, err = io.WriteString(w, htmlStr)
+	return d.failures, err
+}
+
+// htmlDocument is an (X)HTML file being translated, see translateHTML.
+type htmlDocument struct {
+	source   []byte
+	doc      *goquery.Document
+	selected map[*html.Node]bool
+	failures []blockFailure
+}
+
+// parseHTML parses source, cleans it up and returns the blocks to translate.
+func parseHTML(source []byte, cfg *Config) (*htmlDocument, *goquery.Selection, error) {
+	source, _ = stripBOM(source)
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(source))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := &htmlDocument{source: source, doc: doc}
+	if !cfg.MarkPlaceholders && !cfg.FillPlaceholders && cfg.OnlySelector == "" {
+		d.failures = handleStyleContent(doc, cfg)
+	}
+	applyTransforms(doc, cfg)
+	kept, originals := bilingualPairs(doc, cfg)
+
+	selection, selected := selectBlocks(doc, cfg)
+	d.selected = selected
+	if originals > 0 {
+		before := selection.Length()
+		selection = skipBilingual(selection, kept)
+		if cfg.DropOriginals {
+			cfg.logf("  -> Bilingual content: removed %d original blocks, keeping %d translated blocks", originals, before-selection.Length())
+		} else {
+			cfg.logf("  -> Bilingual content: keeping %d original and translated blocks as they are", before-selection.Length())
+		}
+	}
+	cfg.logf("  -> Found %d translatable nodes", selection.Length())
+	return d, selection, nil
+}
+
+// prepare translates what isn't a block (media fallbacks, labels, the titles
+// of abbreviations) and returns the blocks of selection that are to be
+// translated. With -file-session, labels are left to finish, so they get the
+// context of the translated text.
+func (d *htmlDocument) prepare(selection *goquery.Selection, cfg *Config) *goquery.Selection {
+	if cfg.FillPlaceholders {
+		// Everything else was finished by hand or is meant to stay as it is
+		selection = selection.Filter("[" + placeholderAttr + "]")
+		cfg.logf("  -> %d blocks are marked for translation", selection.Length())
+	}
+
+	if cfg.KeepMediaStructure && cfg.OnlySelector == "" && !cfg.FillPlaceholders {
+		d.failures = append(d.failures, translateMediaFallbacks(d.doc, d.selected, cfg)...)
+	}
+	if cfg.TranslateLabels && cfg.OnlySelector == "" && !cfg.FillPlaceholders && cfg.Session == nil {
+		d.failures = append(d.failures, translateLabels(d.doc, d.selected, cfg)...)
+	}
+	if cfg.OnlySelector == "" && !cfg.FillPlaceholders {
+		d.failures = append(d.failures, translateAbbrTitles(d.doc, cfg)...)
+	}
+
+	// With a session, captions come last, after the text they refer to
+	if cfg.Session != nil {
+		selection = selection.Not(captionSelector).AddSelection(selection.Filter(captionSelector))
+	}
+	return selection
+}
+
+// finish records the outcome of translating the blocks of selection.
+func (d *htmlDocument) finish(selection *goquery.Selection, blockFailures []blockFailure, cfg *Config) {
+	if cfg.FillPlaceholders {
+		clearPlaceholders(selection, blockFailures)
+	}
+	d.failures = append(d.failures, blockFailures...)
+
+	if cfg.TranslateLabels && cfg.OnlySelector == "" && !cfg.FillPlaceholders && cfg.Session != nil {
+		d.failures = append(d.failures, translateLabels(d.doc, d.selected, cfg)...)
+	}
+}
+
+func (d *htmlDocument) render() (string, error) {
+	return renderDocument(d.doc, d.source)
+}
+
+// selectBlocks returns the elements to translate, and the set of all
+// elements matching the block selector before nested ones were dropped.
+func selectBlocks(doc *goquery.Document, cfg *Config) (*goquery.Selection, map[*html.Node]bool) {
+	selection := doc.Find(cfg.blockSelector())
+
+	// A selected ancestor (e.g. the <p> around a <span>) already translates
+	// the node as part of its inner HTML. This has to be decided before any
+	// SetHtml call detaches the nested nodes from their parents.
+	selected := make(map[*html.Node]bool, selection.Length())
+	for _, n := range selection.Nodes {
+		selected[n] = true
+	}
+	keep, depth := outermostBlocks(doc.Get(0), selected, cfg)
+	if depth > maxNestingDepth {
+		cfg.logf("  -> Warning: elements are nested %d levels deep, the file may be malformed", depth)
+	}
+	selection = selection.FilterFunction(func(i int, s *goquery.Selection) bool {
+		return keep[s.Get(0)]
+	})
+
+	if cfg.TranslateIndex && cfg.OnlySelector == "" {
+		selection = selection.AddSelection(indexTerms(doc))
+	}
+	return selection, selected
+}
+
+// emptyTranslation reports whether translated has no text although source
+// has, so setting it would blank the block. This is the last guard, for
+// empty answers that got past the retries, e.g. from the cache.
+func emptyTranslation(source, translated string) bool {
+	if strings.TrimSpace(translated) != "" && strings.TrimSpace(fragmentText(translated)) != "" {
+		return false
+	}
+	return strings.TrimSpace(fragmentText(source)) != ""
+}
+
+// translateBlock replaces the inner HTML of one selected element with its
+// translation. It returns the failure if the block kept its original text.
+func translateBlock(s *goquery.Selection, cfg *Config) *blockFailure {
+	p := prepareBlock(s, cfg)
+	if p == nil {
+		return nil
+	}
+	p.translate()
+	return p.apply()
+}
+
+// pendingBlock is a block between the steps of translateBlock. Only
+// prepareBlock and apply change the document; translate just sends the
+// request, so several blocks of a document can be translated at once.
+type pendingBlock struct {
+	s        *goquery.Selection
+	cfg      *Config
+	inner    string
+	context  string
+	original string
+	media    []*html.Node
+	kept     []*html.Node
+
+	translated string
+	err        error
+}
+
+// prepareBlock gets s ready for translating, or returns nil if there is
+// nothing to translate.
+func prepareBlock(s *goquery.Selection, cfg *Config) *pendingBlock {
+	// Only translate if there's text and it's not just whitespace or numbers
+	if !hasTranslatableText(s, cfg) {
+		return nil
+	}
+	p := &pendingBlock{s: s, cfg: cfg.auditBlocks(AuditBlock{File: cfg.AuditFile, Block: nodePath(s.Get(0))})}
+	cfg = p.cfg
+
+	// Media inside the block is swapped for placeholders, so the model can't
+	// alter source references or the cases of an epub:switch
+	if cfg.KeepMediaStructure && containsMedia(s.Get(0)) {
+		p.original, _ = s.Html()
+		p.media = protectMedia(s.Get(0))
+	}
+
+	// Likewise for what the author marked translate="no"
+	if containsNoTranslate(s.Get(0)) {
+		if p.original == "" {
+			p.original, _ = s.Html()
+		}
+		p.kept = protectNoTranslate(s.Get(0))
+	}
+
+	// Use innerHTML to keep nested tags like <em> or <strong>
+	inner, err := s.Html()
+	if err != nil {
+		return nil
+	}
+	p.inner = inner
+
+	if cfg.FigureContext && goquery.NodeName(s) == "figcaption" {
+		p.context = figureContext(s)
+	} else if cfg.TranslateIndex && insideIndex(s.Get(0)) {
+		p.context = indexContext
+	}
+	if cfg.Session != nil && s.Is(captionSelector) {
+		p.context = cfg.Session.withSession(p.context, inner)
+	}
+	return p
+}
+
+// translate requests the translation of the block.
+func (p *pendingBlock) translate() {
+	content := encodeNbsp(p.inner)
+	if p.cfg.TokenizeTags {
+		p.translated, p.err = translateTokenized(p.s.Get(0), content, p.context, p.cfg)
+	} else {
+		p.translated, p.err = translateNode(content, p.context, p.cfg)
+	}
+}
+
+// apply puts the translation into the document and returns the failure if
+// the block kept its original text.
+func (p *pendingBlock) apply() *blockFailure {
+	s, cfg, translated, err := p.s, p.cfg, p.translated, p.err
+	if err == nil && emptyTranslation(p.inner, translated) {
+		err = fmt.Errorf("%w: empty translation", ErrTranslation)
+		translated = encodeNbsp(p.inner) + failureMarker
+		cfg.logf("  -> The translation of a block has no text, keeping the original")
+	}
+
+	var failure *blockFailure
+	if err != nil {
+		failure = &blockFailure{Path: nodePath(s.Get(0)), Err: err}
+	} else {
+		checkLength(s, p.inner, translated, cfg)
+		translated = cfg.Glossary.enforce(translated, cfg.GlossaryEnforce)
+		if cfg.QuoteStyle != nil {
+			translated = localizeQuotes(translated, *cfg.QuoteStyle)
+		}
+		translated = cfg.Replacements.apply(translated)
+	}
+	quoted := hasQuotedQ(s.Get(0))
+	s.SetHtml(translated)
+	if failure == nil && !quoted {
+		unquoteQ(s.Get(0))
+	}
+	if failure == nil && cfg.NormalizeWhitespace {
+		normalizeWhitespace(s.Get(0))
+	}
+	if failure == nil && cfg.BidiFixup {
+		fixBidi(s.Get(0))
+	}
+
+	kept := p.kept
+	if p.media != nil {
+		if err := restoreMedia(s.Get(0), p.media); err != nil {
+			s.SetHtml(p.original)
+			kept = nil
+			if failure == nil {
+				failure = &blockFailure{Path: nodePath(s.Get(0)), Err: fmt.Errorf("%w: %v", ErrTranslation, err)}
+			}
+		}
+	}
+	if kept != nil {
+		if err := restoreNoTranslate(s.Get(0), kept); err != nil {
+			s.SetHtml(p.original)
+			if failure == nil {
+				failure = &blockFailure{Path: nodePath(s.Get(0)), Err: fmt.Errorf("%w: translate=\"no\" %v", ErrTranslation, err)}
+			}
+		}
+	}
+
+	return failure
+}
+
+// translateConcurrently translates the blocks of selection like
+// translateBlock, with up to cfg.NodeConcurrency requests at a time. Only the
+// requests run in parallel: the blocks are prepared, and their translations
+// put in, in document order on the calling goroutine, so the document is
+// only ever changed by one goroutine.
+func translateConcurrently(selection *goquery.Selection, cfg *Config) []blockFailure {
+	var pending []*pendingBlock
+	selection.Each(func(i int, s *goquery.Selection) {
+		if p := prepareBlock(s, cfg); p != nil {
+			pending = append(pending, p)
+		}
+	})
+
+	done := make([]chan struct{}, len(pending))
+	slots := make(chan struct{}, cfg.NodeConcurrency)
+	for i, p := range pending {
+		done[i] = make(chan struct{})
+		go func() {
+			defer close(done[i])
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			p.translate()
+		}()
+	}
+
+	var failures []blockFailure
+	for i, p := range pending {
+		<-done[i]
+		if f := p.apply(); f != nil {
+			failures = append(failures, *f)
+		}
+	}
+	return failures
+}
+
+// nodePath identifies an element within its document, e.g.
+// "html/body/section[1]/p[3]", counting only element siblings of the same
+// name. Translating a block only replaces its children, so the paths of the
+// blocks themselves are stable across runs and before/after translation.
+func nodePath(n *html.Node) string {
+	var parts []string
+	for ; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		part := n.Data
+		if n.Parent != nil && n.Parent.Type == html.ElementNode {
+			index, count := 0, 0
+			for c := n.Parent.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && c.Data == n.Data {
+					count++
+					if c == n {
+						index = count
+					}
+				}
+			}
+			if count > 1 {
+				part += "[" + strconv.Itoa(index) + "]"
+			}
+		}
+		parts = append([]string{part}, parts...)
+	}
+	return strings.Join(parts, "/")
+}
+
+// findNodeByPath is the inverse of nodePath.
+func findNodeByPath(doc *html.Node, path string) *html.Node {
+	n := doc
+	for _, part := range strings.Split(path, "/") {
+		name, index := part, 1
+		if open := strings.IndexByte(part, '['); open >= 0 && strings.HasSuffix(part, "]") {
+			name = part[:open]
+			i, err := strconv.Atoi(part[open+1 : len(part)-1])
+			if err != nil {
+				return nil
+			}
+			index = i
+		}
+
+		var found *html.Node
+		count := 0
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == name {
+				count++
+				if count == index {
+					found = c
+					break
+				}
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		n = found
+	}
+	return n
+}
+
+// encodeNbsp spells out non-breaking spaces as &nbsp; before the content goes
+// to the model. The parser has already decoded all entities, and a raw U+00A0
+// is easily "normalized" into a plain space by the model, whereas the explicit
+// entity is kept. SetHtml decodes it again and the serializer writes the raw
+// character, which, unlike &nbsp;, is valid in XHTML without a DTD, so the
+// output consistently uses the literal character.
+func encodeNbsp(s string) string {
+	return strings.ReplaceAll(s, "\u00a0", "&nbsp;")
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestEntitiesRenderEquivalently(t *testing.T) {
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(`<p>10&nbsp;km&mdash;far &#169; 2020&#x2009;AD</p>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if api.requested("10&nbsp;km—far © 2020\u2009AD") != 1 {
+		t.Errorf("sent %q, want the non-breaking space as &nbsp;", api.requests())
+	}
+	chapter := out[chapterName(1)]
+	if want := "<p>[T]10\u00a0km—far © 2020\u2009AD</p>"; !strings.Contains(chapter, want) {
+		t.Errorf("got:\n%s\nwant %q", chapter, want)
+	}
+	if strings.Contains(chapter, "&nbsp;") {
+		t.Errorf("output has the &nbsp; entity, which XHTML doesn't define:\n%s", chapter)
+	}
+}
+
+func TestFigureContext(t *testing.T) {
+	figure := `<figure id="f1"><img src="../img/a.png" alt="A red fox in the snow"/><figcaption>The <em>hunter</em> at dawn.</figcaption></figure>`
+	for _, withContext := range []bool{false, true} {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.FigureContext = withContext
+		out, err := translate(t, testBook(figure), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `<figure id="f1"><img src="../img/a.png" alt="A red fox in the snow"/><figcaption>[T]The <em>hunter</em> at dawn.</figcaption></figure>`
+		if chapter := out[chapterName(1)]; !strings.Contains(chapter, want) {
+			t.Errorf("context %v: got:\n%s\nwant %s", withContext, chapter, want)
+		}
+		prompt := api.systemPromptFor("hunter")
+		if got := strings.Contains(prompt, "described as: A red fox in the snow"); got != withContext {
+			t.Errorf("context %v: the image description is in the prompt: %v\n%s", withContext, got, prompt)
+		}
+		if api.requested("red fox") != 0 {
+			t.Error("the description was sent as text to translate")
+		}
+	}
+}
+
+func TestTableCells(t *testing.T) {
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(`<table><caption>Results per year</caption>
+<tr><th>Year</th><th>Sales</th></tr>
+<tr><td>2021</td><td>1,234.50</td></tr>
+<tr><td>Total</td><td><p>Much more than before.</p></td></tr>
+<tr><td><pre>x := 1</pre></td><td>- / -</td></tr>
+</table>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		"<caption>[T]Results per year</caption>",
+		"<th>[T]Year</th>",
+		"<th>[T]Sales</th>",
+		"<td>[T]Total</td>",
+		"<td>2021</td>",
+		"<td>1,234.50</td>",
+		"<td>- / -</td>",
+		"<pre>x := 1</pre>",
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if n := strings.Count(chapter, "[T]"); n != 5 {
+		t.Errorf("got %d translated blocks, want 5:\n%s", n, chapter)
+	}
+	for _, numeric := range []string{"2021", "1,234.50", "x := 1"} {
+		if api.requested(numeric) != 0 {
+			t.Errorf("sent %q to the API", numeric)
+		}
+	}
+	if api.requested("Much more than before.") != 1 {
+		t.Errorf("the paragraph in a cell was sent %d times, want once", api.requested("Much more than before."))
+	}
+}
+
+func TestBlockquoteWithCite(t *testing.T) {
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(`<blockquote class="epigraph"><p>All happy families are alike.</p><p>Each is unhappy in its own way.</p><footer>-- <cite>Leo Tolstoy</cite></footer></blockquote><p>A <cite>Book Title</cite> in running text.</p>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quote := "<p>All happy families are alike.</p><p>Each is unhappy in its own way.</p><footer>-- <cite>Leo Tolstoy</cite></footer>"
+	if api.requested(quote) != 1 {
+		t.Errorf("the quote and its cite weren't sent together: %q", api.requests())
+	}
+	if api.requested("Leo Tolstoy") != 1 || api.requested("Book Title") != 1 {
+		t.Errorf("a cite was sent on its own as well: %q", api.requests())
+	}
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		`<blockquote class="epigraph">[T]` + quote + `</blockquote>`,
+		"<p>[T]A <cite>Book Title</cite> in running text.</p>",
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+}
+
+func TestOnlySelector(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.OnlySelector = "h1,h2"
+	out, err := translate(t, testBook(`<h1>Title</h1><p>Body text.</p><section><h2>Part</h2><ul><li>Item</li></ul></section><h2 translate="no">Kept</h2>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{"<h1>[T]Title</h1>", "<h2>[T]Part</h2>", "<p>Body text.</p>", "<li>Item</li>", `<h2 translate="no">Kept</h2>`} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	for _, block := range []string{"Body text.", "Item", "Kept", "Chapter 1"} {
+		if api.requested(block) != 0 {
+			t.Errorf("sent %s with -only-selector h1,h2", block)
+		}
+	}
+}
+
+func TestDetailsAndDefinitionLists(t *testing.T) {
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(`<details open="open"><summary>Show the answer</summary><p>The answer.</p></details><dl><dt>Term</dt><dd>Its definition.</dd></dl><figure><img src="../img/a.png" alt=""/><figcaption>A caption</figcaption></figure>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		`<details open="open"><summary>[T]Show the answer</summary><p>[T]The answer.</p></details>`,
+		"<dl><dt>[T]Term</dt><dd>[T]Its definition.</dd></dl>",
+		"<figcaption>[T]A caption</figcaption>",
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+}
+
+func TestPathologicalDocument(t *testing.T) {
+	const depth, siblings = 400, 5000
+	nested := strings.Repeat("<span>", depth) + "Deep text." + strings.Repeat("</span>", depth)
+	body := "<p>" + nested + "</p><div>" + nested + "</div><div>" + strings.Repeat("<p>Sibling.</p>", siblings) + "</div>"
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(xhtml(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logged strings.Builder
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.Logger = log.New(&logged, "", 0)
+	start := time.Now()
+	selection, _ := selectBlocks(doc, cfg)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("selecting the blocks took %v", elapsed)
+	}
+
+	if n := selection.Length(); n != siblings+2 {
+		t.Errorf("got %d blocks, want %d: the nested spans translated once, inside the <p> and on their own", n, siblings+2)
+	}
+	if first := selection.First(); goquery.NodeName(first) != "p" || first.Children().Length() != 1 {
+		t.Errorf("the first block is a %s, want the <p> around the spans", goquery.NodeName(first))
+	}
+	if goquery.NodeName(selection.Eq(1)) != "span" || goquery.NodeName(selection.Eq(1).Parent()) != "div" {
+		t.Error("the second block isn't the outermost span outside the <p>")
+	}
+	if !strings.Contains(logged.String(), "levels deep, the file may be malformed") {
+		t.Errorf("no warning about the nesting:\n%s", logged.String())
+	}
+}
+
+func TestMinTextLength(t *testing.T) {
+	book := testBook(`<p>A</p><p>…</p><p>Ok</p><p>12 345</p><p>x <code>fmt.Println(1)</code></p><p>Hello there.</p>`)
+	tests := []struct {
+		minLength          int
+		translated, copied []string
+	}{
+		{1, []string{"A", "Ok", "x <code>", "Hello there."}, []string{"…", "12 345"}},
+		{2, []string{"Ok", "Hello there."}, []string{"A", "…", "12 345", "x <code>"}},
+		{5, []string{"Hello there."}, []string{"A", "…", "Ok", "12 345", "x <code>"}},
+	}
+	for _, tt := range tests {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.MinTextLength = tt.minLength
+		out, err := translate(t, book, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chapter := out[chapterName(1)]
+		for _, block := range tt.translated {
+			if !strings.Contains(chapter, "<p>[T]"+block) {
+				t.Errorf("-min-text-length %d: %s wasn't translated:\n%s", tt.minLength, block, chapter)
+			}
+		}
+		for _, block := range tt.copied {
+			if !strings.Contains(chapter, "<p>"+block) || api.requested(block) > 0 {
+				t.Errorf("-min-text-length %d: %s wasn't left as it is:\n%s", tt.minLength, block, chapter)
+			}
+		}
+	}
+}
+
+func TestEmptyTranslationKeepsOriginal(t *testing.T) {
+	answers := map[string]string{"Empty": "", "Blank": " \n ", "Tags": "<b> </b>"}
+	api := newStubAPI(t, func(content string) (int, string) {
+		for prefix, answer := range answers {
+			if strings.HasPrefix(content, prefix) {
+				return http.StatusOK, answer
+			}
+		}
+		return prefixReply(content)
+	})
+	cfg := testConfig(api.URL)
+	out, err := translate(t, testBook(`<p>Empty answer.</p><p>Blank answer.</p><p>Tags <b>only</b>.</p><p>Fine.</p>`), cfg)
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("got %v, want ErrIncomplete", err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, original := range []string{"<p>Empty answer. <span", "<p>Blank answer. <span", "<p>Tags <b>only</b>. <span"} {
+		if !strings.Contains(chapter, original) {
+			t.Errorf("chapter lacks the original %s:\n%s", original, chapter)
+		}
+	}
+	if !strings.Contains(chapter, "<p>[T]Fine.</p>") {
+		t.Errorf("the other block wasn't translated:\n%s", chapter)
+	}
+	if n := api.requested("Empty answer."); n < 2 {
+		t.Errorf("the empty answer was requested %d times, want it retried", n)
+	}
+}
+
+func TestEmptyTranslation(t *testing.T) {
+	tests := []struct {
+		source, translated string
+		want               bool
+	}{
+		{"Hello", "", true},
+		{"Hello", "  ", true},
+		{"Hello <em>you</em>", "<em></em>", true},
+		{"Hello", "Hallo", false},
+		{"<img src=\"a.png\"/>", "", false},
+		{"  ", "", false},
+	}
+	for _, tt := range tests {
+		if got := emptyTranslation(tt.source, tt.translated); got != tt.want {
+			t.Errorf("emptyTranslation(%q, %q) = %v, want %v", tt.source, tt.translated, got, tt.want)
+		}
+	}
+}
+
+func TestFileAndNodeConcurrency(t *testing.T) {
+	var chapters []string
+	for c := 1; c <= 4; c++ {
+		var body strings.Builder
+		for b := 1; b <= 6; b++ {
+			fmt.Fprintf(&body, "<p>Chapter %d, block %d.</p>", c, b)
+		}
+		chapters = append(chapters, body.String())
+	}
+	book := testBook(chapters...)
+
+	run := func(files, nodes int) (map[string]string, int32) {
+		t.Helper()
+		// Answers arrive out of order, the later blocks of a file first
+		var inFlight, maxInFlight atomic.Int32
+		api := newStubAPI(t, func(content string) (int, string) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+			}
+			var c, b int
+			if _, err := fmt.Sscanf(content, "Chapter %d, block %d.", &c, &b); err == nil {
+				time.Sleep(time.Duration(7-b) * 3 * time.Millisecond)
+			}
+			return prefixReply(content)
+		})
+		cfg := testConfig(api.URL)
+		cfg.Reproducible = true
+		cfg.Concurrency = files
+		cfg.NodeConcurrency = nodes
+		out, err := translate(t, book, cfg)
+		if err != nil {
+			t.Fatalf("-file-concurrency %d -node-concurrency %d: %v", files, nodes, err)
+		}
+		return out, maxInFlight.Load()
+	}
+
+	serial, inFlight := run(1, 1)
+	if inFlight != 1 {
+		t.Errorf("%d requests were in flight at once without concurrency", inFlight)
+	}
+	for c := 1; c <= 4; c++ {
+		chapter := serial[chapterName(c)]
+		for b := 1; b <= 6; b++ {
+			if want := fmt.Sprintf("<p>[T]Chapter %d, block %d.</p>", c, b); !strings.Contains(chapter, want) {
+				t.Errorf("serial run: chapter %d lacks %s:\n%s", c, want, chapter)
+			}
+		}
+	}
+
+	for _, tt := range []struct{ files, nodes int }{{4, 1}, {1, 4}, {4, 4}} {
+		out, inFlight := run(tt.files, tt.nodes)
+		if inFlight < 2 || inFlight > int32(tt.files*tt.nodes) {
+			t.Errorf("-file-concurrency %d -node-concurrency %d: %d requests in flight at most", tt.files, tt.nodes, inFlight)
+		}
+		for _, name := range sortedKeys(serial) {
+			if out[name] != serial[name] {
+				t.Errorf("-file-concurrency %d -node-concurrency %d: %s differs from the serial run:\n%s", tt.files, tt.nodes, name, out[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// indexContext tells the model what an index term is, so it uses the form
+// the term has in the translated text rather than a free translation.
+const indexContext = "This is a term of the book's index. Translate it the way it would appear in the translated text, and keep it short."
+
+func hasEpubType(n *html.Node, value string) bool {
+	for _, a := range n.Attr {
+		if a.Key == "epub:type" {
+			for _, t := range strings.Fields(a.Val) {
+				if t == value {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// insideIndex reports whether n is part of an epub:type="index" section.
+func insideIndex(n *html.Node) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && hasEpubType(p, "index") {
+			return true
+		}
+	}
+	return false
+}
+
+// indexTerms selects the labels of an index for -translate-index: elements
+// marked as index-term and links with a textual label. Locators (page
+// numbers) and link targets stay as they are; translating a term only
+// replaces the content of its element.
+func indexTerms(doc *goquery.Document) *goquery.Selection {
+	terms := doc.Find(`a, span, li`).FilterFunction(func(i int, s *goquery.Selection) bool {
+		n := s.Get(0)
+		if !insideIndex(n) || hasEpubType(n, "index-locator") {
+			return false
+		}
+		if n.Data == "a" {
+			return hasLetters(s.Text())
+		}
+		return hasEpubType(n, "index-term")
+	})
+
+	selected := make(map[*html.Node]bool, terms.Length())
+	for _, n := range terms.Nodes {
+		selected[n] = true
+	}
+	return terms.FilterFunction(func(i int, s *goquery.Selection) bool {
+		return !hasSelectedAncestor(s.Get(0), selected)
+	})
+}
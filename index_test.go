@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslateIndex(t *testing.T) {
+	body := `<section epub:type="index"><h2>Index</h2><ul>` +
+		`<li><span epub:type="index-term">Apple trees</span>, <a href="ch1.xhtml#p12" epub:type="index-locator">12</a>, <a href="ch1.xhtml#p30" epub:type="index-locator">30</a></li>` +
+		`<li><a href="ch1.xhtml#grafting">Grafting</a></li>` +
+		`</ul></section>`
+
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.TranslateIndex = true
+	out, err := translate(t, testBook(body), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		`<span epub:type="index-term">[T]Apple trees</span>`,
+		`<a href="ch1.xhtml#p12" epub:type="index-locator">12</a>`,
+		`<a href="ch1.xhtml#p30" epub:type="index-locator">30</a>`,
+		`<a href="ch1.xhtml#grafting">[T]Grafting</a>`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("index lacks %s:\n%s", want, chapter)
+		}
+	}
+	if api.requested("ch1.xhtml#") != 0 {
+		t.Errorf("sent the locators or the links along with the terms: %q", api.requests())
+	}
+	if prompt := api.systemPromptFor("Apple trees"); !strings.Contains(prompt, indexContext) {
+		t.Errorf("the term was translated without the index context: %s", prompt)
+	}
+}
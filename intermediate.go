@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// saveIntermediate writes the translation of the entry name to dir
+// (-save-intermediate) as soon as the file is done, under its path in the
+// book. It goes through a temporary file, so a file in dir is always
+// complete.
+func saveIntermediate(dir, name string, data []byte) error {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-"+filepath.Base(target))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSaveIntermediate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "intermediate")
+	var firstSaved, secondSaved atomic.Bool
+	api := newStubAPI(t, func(content string) (int, string) {
+		if strings.Contains(content, "Second chapter") {
+			_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(chapterName(1))))
+			firstSaved.Store(err == nil)
+			_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(chapterName(2))))
+			secondSaved.Store(err == nil)
+		}
+		return prefixReply(content)
+	})
+
+	cfg := testConfig(api.URL)
+	cfg.SaveIntermediate = dir
+	out, err := translate(t, testBook(`<p>First chapter.</p>`, `<p>Second chapter.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !firstSaved.Load() {
+		t.Error("the first chapter wasn't saved before the second one was translated")
+	}
+	if secondSaved.Load() {
+		t.Error("the second chapter was saved before it was translated")
+	}
+
+	for _, name := range []string{chapterName(1), chapterName(2)} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != out[name] {
+			t.Errorf("the intermediate %s differs from the one in the EPUB:\n%s", name, data)
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "OEBPS", "text", ".tmp-*"))
+	if len(matches) > 0 {
+		t.Errorf("temporary files were left behind: %v", matches)
+	}
+}
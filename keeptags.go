@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// droppedWithContent are removed including everything inside them when the
+// model adds them, since their content isn't text of the book.
+var droppedWithContent = map[string]bool{"script": true, "style": true, "iframe": true, "object": true, "embed": true}
+
+// parseTagList parses the comma-separated -keep-tags-list.
+func parseTagList(s string) map[string]bool {
+	tags := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags[t] = true
+		}
+	}
+	return tags
+}
+
+// tagNames returns the names of all tags in fragment.
+func tagNames(fragment string) map[string]bool {
+	names := make(map[string]bool)
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return names
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			names[string(name)] = true
+		}
+	}
+}
+
+// restrictTags removes the tags from translated that are neither in
+// cfg.KeepTags nor in the source. Unknown tags are unwrapped, keeping
+// their text; droppedWithContent ones are removed entirely. Without
+// -keep-tags-list, translated is returned unchanged.
+func (cfg *Config) restrictTags(source, translated string) string {
+	if cfg.KeepTags == nil {
+		return translated
+	}
+
+	allowed := tagNames(source)
+	for t := range cfg.KeepTags {
+		allowed[t] = true
+	}
+
+	var b strings.Builder
+	removed := make(map[string]bool)
+	skip, skipDepth := "", 0
+
+	z := html.NewTokenizer(strings.NewReader(translated))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		raw := string(z.Raw())
+		switch tt {
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			nameBytes, _ := z.TagName()
+			name := string(nameBytes)
+
+			if skip != "" {
+				if name == skip && tt == html.StartTagToken {
+					skipDepth++
+				} else if name == skip && tt == html.EndTagToken {
+					skipDepth--
+					if skipDepth == 0 {
+						skip = ""
+					}
+				}
+				continue
+			}
+
+			if allowed[name] {
+				b.WriteString(raw)
+				continue
+			}
+
+			removed[name] = true
+			if droppedWithContent[name] && tt == html.StartTagToken {
+				skip, skipDepth = name, 1
+			}
+
+		default:
+			if skip == "" {
+				b.WriteString(raw)
+			}
+		}
+	}
+
+	if len(removed) > 0 {
+		var names []string
+		for name := range removed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		cfg.logf("  -> Removed tags not in -keep-tags-list from a translation: %s", strings.Join(names, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestKeepTagsList(t *testing.T) {
+	reply := func(content string) (int, string) {
+		if strings.Contains(content, "Some") {
+			return http.StatusOK, `<span class="x">Einiger</span> <em>Text</em><script>alert("hi")</script> <strong>hier</strong>.`
+		}
+		return prefixReply(content)
+	}
+	chapter := `<p>Some <em>text</em> here.</p>`
+
+	api := newStubAPI(t, reply)
+	cfg := testConfig(api.URL)
+	cfg.KeepTags = parseTagList("em, Strong")
+	out, err := translate(t, testBook(chapter), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out[chapterName(1)]
+	if want := `<p>Einiger <em>Text</em> <strong>hier</strong>.</p>`; !strings.Contains(got, want) {
+		t.Errorf("got\n%s\nwant it to contain %s", got, want)
+	}
+	for _, injected := range []string{"<script", "alert", "<span"} {
+		if strings.Contains(got, injected) {
+			t.Errorf("output has the injected %s:\n%s", injected, got)
+		}
+	}
+
+	api = newStubAPI(t, reply)
+	out, err = translate(t, testBook(chapter), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out[chapterName(1)], `<span class="x">Einiger</span>`) {
+		t.Errorf("tags were stripped without -keep-tags-list:\n%s", out[chapterName(1)])
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// labelContext is passed along with texts that are read out by assistive
+// technology rather than shown.
+const labelContext = "This is a page title or accessibility label read out by screen readers. Output plain text only."
+
+// labelMetaNames are the <meta name> values whose content is a page title or
+// description. Technical metas like viewport (fixed layout) are never touched.
+var labelMetaNames = map[string]bool{
+	"title": true, "description": true,
+	"dc.title": true, "dc.description": true, "dcterms.title": true, "dcterms.description": true,
+}
+
+// translateLabels translates the texts of a page that only assistive
+// technology presents (-translate-labels): the <title>, title and
+// description metas, aria-label attributes, and the elements aria-labelledby
+// points to that aren't translated as blocks anyway.
+func translateLabels(doc *goquery.Document, selected map[*html.Node]bool, cfg *Config) []blockFailure {
+	var failures []blockFailure
+
+	translateText := func(n *html.Node, suffix, text string, set func(string)) {
+		text = strings.TrimSpace(text)
+		if !hasLetters(text) {
+			return
+		}
+		translated, err := translateNode(text, cfg.Session.withSession(labelContext, text), cfg)
+		if err != nil {
+			failures = append(failures, blockFailure{Path: nodePath(n) + suffix, Err: err})
+			return
+		}
+		set(html.UnescapeString(translated))
+	}
+
+	doc.Find("head > title").Each(func(i int, s *goquery.Selection) {
+		translateText(s.Get(0), "", s.Text(), func(t string) { s.SetText(t) })
+	})
+	doc.Find("meta[name][content]").Each(func(i int, s *goquery.Selection) {
+		if !labelMetaNames[strings.ToLower(s.AttrOr("name", ""))] {
+			return
+		}
+		translateText(s.Get(0), "@content", s.AttrOr("content", ""), func(t string) { s.SetAttr("content", t) })
+	})
+
+	doc.Find("[aria-label]").Each(func(i int, s *goquery.Selection) {
+		if insideNoTranslate(s.Get(0)) {
+			return
+		}
+		translateText(s.Get(0), "@aria-label", s.AttrOr("aria-label", ""), func(t string) { s.SetAttr("aria-label", t) })
+	})
+
+	ids := make(map[string]bool)
+	doc.Find("[aria-labelledby]").Each(func(i int, s *goquery.Selection) {
+		for _, id := range strings.Fields(s.AttrOr("aria-labelledby", "")) {
+			ids[id] = true
+		}
+	})
+	doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+		n := s.Get(0)
+		if !ids[s.AttrOr("id", "")] || selected[n] || hasSelectedAncestor(n, selected) || containsSelected(n, selected) || insideNoTranslate(n) {
+			return
+		}
+		if f := translateBlock(s, cfg); f != nil {
+			failures = append(failures, *f)
+		}
+	})
+
+	return failures
+}
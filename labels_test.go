@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const fixedLayoutPage = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><head><title>Page one</title><meta name="viewport" content="width=1200, height=1600"/><meta name="description" content="The fox at the river."/></head>` +
+	`<body><div class="page" aria-labelledby="label"><svg aria-label="A fox drinking"><image href="../img/a.png"/></svg><span id="label" hidden="hidden">Page one of the story</span>` +
+	`<p>The fox drinks.</p></div></body></html>`
+
+func TestTranslateLabels(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.TranslateLabels = true
+	book := replaceEntry(testBook(`<p>x</p>`), chapterName(1), fixedLayoutPage)
+	out, err := translate(t, book, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := out[chapterName(1)]
+	for _, want := range []string{
+		`<meta name="viewport" content="width=1200, height=1600"/>`,
+		`<title>[T]Page one</title>`,
+		`<meta name="description" content="[T]The fox at the river."/>`,
+		`aria-label="[T]A fox drinking"`,
+		`[T]Page one of the story</span>`,
+		`<p>[T]The fox drinks.</p>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page lacks %s:\n%s", want, page)
+		}
+	}
+	if api.requested("width=1200") > 0 {
+		t.Error("the viewport meta was sent for translation")
+	}
+
+	api = newStubAPI(t, nil)
+	out, err = translate(t, book, testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = out[chapterName(1)]
+	if !strings.Contains(page, `<title>Page one</title>`) || !strings.Contains(page, `aria-label="A fox drinking"`) {
+		t.Errorf("labels were translated without -translate-labels:\n%s", page)
+	}
+}
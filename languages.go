@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// language is an entry of the table used to map the free-form TARGET_LANGUAGE
+// to a code.
+type language struct {
+	Code    string // ISO 639-1, or a BCP 47 tag where the region matters
+	Name    string
+	Aliases []string // native names and common alternatives
+}
+
+var languages = []language{
+	{"ar", "Arabic", []string{"العربية"}},
+	{"bg", "Bulgarian", []string{"български"}},
+	{"cs", "Czech", []string{"čeština", "cesky"}},
+	{"da", "Danish", []string{"dansk"}},
+	{"de", "German", []string{"deutsch"}},
+	{"el", "Greek", []string{"ελληνικά"}},
+	{"en", "English", nil},
+	{"es", "Spanish", []string{"español", "espanol", "castellano"}},
+	{"et", "Estonian", []string{"eesti"}},
+	{"fa", "Persian", []string{"farsi", "فارسی"}},
+	{"fi", "Finnish", []string{"suomi"}},
+	{"fr", "French", []string{"français", "francais"}},
+	{"he", "Hebrew", []string{"עברית"}},
+	{"hi", "Hindi", []string{"हिन्दी"}},
+	{"hr", "Croatian", []string{"hrvatski"}},
+	{"hu", "Hungarian", []string{"magyar"}},
+	{"id", "Indonesian", []string{"bahasa indonesia"}},
+	{"it", "Italian", []string{"italiano"}},
+	{"ja", "Japanese", []string{"日本語"}},
+	{"ko", "Korean", []string{"한국어"}},
+	{"lt", "Lithuanian", []string{"lietuvių"}},
+	{"lv", "Latvian", []string{"latviešu"}},
+	{"nb", "Norwegian", []string{"norsk", "norwegian bokmål", "bokmål"}},
+	{"nl", "Dutch", []string{"nederlands"}},
+	{"pl", "Polish", []string{"polski"}},
+	{"pt", "Portuguese", []string{"português", "portugues"}},
+	{"pt-BR", "Brazilian Portuguese", []string{"português brasileiro"}},
+	{"ro", "Romanian", []string{"română"}},
+	{"ru", "Russian", []string{"русский"}},
+	{"sk", "Slovak", []string{"slovenčina"}},
+	{"sl", "Slovenian", []string{"slovenščina"}},
+	{"sv", "Swedish", []string{"svenska"}},
+	{"th", "Thai", []string{"ไทย"}},
+	{"tr", "Turkish", []string{"türkçe"}},
+	{"uk", "Ukrainian", []string{"українська"}},
+	{"ur", "Urdu", []string{"اردو"}},
+	{"vi", "Vietnamese", []string{"tiếng việt"}},
+	{"zh", "Chinese", []string{"中文", "simplified chinese"}},
+	{"zh-TW", "Traditional Chinese", []string{"繁體中文"}},
+}
+
+// lookupLanguage finds a language by code, English name or alias, ignoring case.
+func lookupLanguage(s string) (language, bool) {
+	s = strings.TrimSpace(s)
+	for _, l := range languages {
+		if strings.EqualFold(s, l.Code) || strings.EqualFold(s, l.Name) {
+			return l, true
+		}
+		for _, alias := range l.Aliases {
+			if strings.EqualFold(s, alias) {
+				return l, true
+			}
+		}
+	}
+	return language{}, false
+}
+
+// isRTLLanguage reports whether name is a language written right to left.
+func isRTLLanguage(name string) bool {
+	l, ok := lookupLanguage(name)
+	if !ok {
+		return false
+	}
+	switch l.Code {
+	case "ar", "fa", "he", "ur":
+		return true
+	}
+	return false
+}
+
+// sameLanguage reports whether a and b name the same language, e.g. "de"
+// and "German".
+func sameLanguage(a, b string) bool {
+	la, okA := lookupLanguage(a)
+	lb, okB := lookupLanguage(b)
+	if okA && okB {
+		return la.Code == lb.Code
+	}
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// listLanguages prints the languages table for -list-languages.
+func listLanguages(out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tNAME\tALSO KNOWN AS")
+	for _, l := range languages {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", l.Code, l.Name, strings.Join(l.Aliases, ", "))
+	}
+	return tw.Flush()
+}
+
+// unknownLanguageWarning is the warning for a target language that isn't in
+// the table, with suggestions if it looks like a misspelling, or "" for a
+// known one.
+func unknownLanguageWarning(lang string) string {
+	if _, ok := lookupLanguage(lang); ok {
+		return ""
+	}
+	if suggestions := suggestLanguages(lang); len(suggestions) > 0 {
+		return fmt.Sprintf("Warning: unknown target language %q, did you mean %s? See -list-languages", lang, strings.Join(suggestions, " or "))
+	}
+	return fmt.Sprintf("Warning: unknown target language %q, see -list-languages", lang)
+}
+
+// suggestLanguages returns the names of the languages s may be a misspelling
+// of: those it is a prefix or an extension of ("Germany"), or that are at
+// most two edits away from it.
+func suggestLanguages(s string) []string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return nil
+	}
+
+	var suggestions []string
+	for _, l := range languages {
+		for _, name := range append([]string{l.Name}, l.Aliases...) {
+			name = strings.ToLower(name)
+			close := len(s) >= 3 && (strings.HasPrefix(name, s) || strings.HasPrefix(s, name))
+			if close || editDistance(s, name) <= 2 {
+				suggestions = append(suggestions, l.Name)
+				break
+			}
+		}
+	}
+	return suggestions
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
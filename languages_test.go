@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnknownLanguageWarning(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string // in the warning; "" for none
+	}{
+		{"German", ""},
+		{"german", ""},
+		{"de", ""},
+		{"Deutsch", ""},
+		{"pt-BR", ""},
+		{"Germany", `unknown target language "Germany", did you mean German?`},
+		{"Frnech", `did you mean French?`},
+		{"Klingon", `unknown target language "Klingon", see -list-languages`},
+	}
+	for _, tt := range tests {
+		got := unknownLanguageWarning(tt.lang)
+		if tt.want == "" && got != "" || tt.want != "" && !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got warning %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestListLanguages(t *testing.T) {
+	var out strings.Builder
+	if err := listLanguages(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(languages)+1 {
+		t.Errorf("got %d lines for %d languages", len(lines), len(languages))
+	}
+	found := false
+	for _, line := range lines {
+		if f := strings.Fields(line); len(f) >= 3 && f[0] == "de" && f[1] == "German" && f[2] == "deutsch" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("German isn't listed:\n%s", out.String())
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Values of -line-endings.
+const (
+	lineEndingsLF       = "lf"
+	lineEndingsCRLF     = "crlf"
+	lineEndingsPreserve = "preserve"
+)
+
+func validateLineEndings(mode string) error {
+	switch mode {
+	case lineEndingsLF, lineEndingsCRLF, lineEndingsPreserve:
+		return nil
+	}
+	return fmt.Errorf("unknown line ending mode %q, expected lf, crlf or preserve", mode)
+}
+
+// normalizeLineEndings gives a serialized (X)HTML file consistent line
+// endings. The round-trip through the parser and the model's output can leave
+// a mix of CRLF and LF. "preserve" uses CRLF if the source did, LF otherwise.
+func normalizeLineEndings(data []byte, mode string, source []byte) []byte {
+	if mode == lineEndingsPreserve {
+		mode = lineEndingsLF
+		if bytes.Contains(source, []byte("\r\n")) {
+			mode = lineEndingsCRLF
+		}
+	}
+
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+	if mode == lineEndingsCRLF {
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	}
+	return data
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineEndings(t *testing.T) {
+	source := strings.ReplaceAll(xhtml("\n<p>First\nline.</p>\n<p>Second.</p>\n"), "\n", "\r\n")
+	binary := "\x89PNG\r\n\x1a\n\r\n"
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{lineEndingsLF, "\n"},
+		{lineEndingsCRLF, "\r\n"},
+		{lineEndingsPreserve, "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			api := newStubAPI(t, nil)
+			cfg := testConfig(api.URL)
+			cfg.LineEndings = tt.mode
+			entries := replaceEntry(testBook(""), chapterName(1), source)
+			entries = replaceEntry(entries, "OEBPS/img/a.png", binary)
+			out, err := translate(t, entries, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chapter := out[chapterName(1)]
+			if !strings.Contains(chapter, "[T]First") {
+				t.Fatalf("chapter not translated:\n%q", chapter)
+			}
+			lf := strings.Count(chapter, "\n")
+			crlf := strings.Count(chapter, "\r\n")
+			if tt.want == "\n" && crlf != 0 || tt.want == "\r\n" && crlf != lf || lf == 0 {
+				t.Errorf("got %d line breaks, %d of them CRLF, want all %q:\n%q", lf, crlf, tt.want, chapter)
+			}
+			if out["OEBPS/img/a.png"] != binary {
+				t.Error("the line endings of an image were changed")
+			}
+		})
+	}
+}
+
+func TestPreserveLineEndingsOfLFSource(t *testing.T) {
+	if got := string(normalizeLineEndings([]byte("a\r\nb\nc"), lineEndingsPreserve, []byte("x\ny"))); got != "a\nb\nc" {
+		t.Errorf("got %q", got)
+	}
+}
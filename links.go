@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// linkAttrPattern matches the attributes that reference other entries:
+// href and src, including xlink:href (SVG, NCX) and the src of SMIL and NCX
+// <content>.
+var linkAttrPattern = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*("[^"]*"|'[^']*')`)
+
+// rewriteLinks updates the links in data, the content of the entry name,
+// after entries were renamed or moved: renames maps old entry names to new
+// ones and may include name itself. Relative links to renamed entries are
+// changed to reach the new name from the (new) location of name; fragments,
+// queries and other links are kept as they are. This works for XHTML, the
+// OPF manifest, NCX, SMIL and SVG alike, which all link relative to their
+// own location.
+func rewriteLinks(name string, data []byte, renames map[string]string) []byte {
+	if len(renames) == 0 {
+		return data
+	}
+	return linkAttrPattern.ReplaceAllFunc(data, func(attr []byte) []byte {
+		m := linkAttrPattern.FindSubmatchIndex(attr)
+		open, end := m[2]+1, m[3]-1
+		link := renamedLink(name, string(attr[open:end]), renames)
+		return append(append(append([]byte{}, attr[:open]...), link...), attr[end:]...)
+	})
+}
+
+func renamedLink(base, link string, renames map[string]string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return link
+	}
+
+	target := resolveHref(base, link)
+	newTarget, targetMoved := renames[target]
+	if !targetMoved {
+		newTarget = target
+	}
+	newBase, ok := renames[base]
+	if !ok {
+		newBase = base
+	}
+	sameDirs := path.Dir(newBase) == path.Dir(base) && path.Dir(newTarget) == path.Dir(target)
+	if !targetMoved && sameDirs {
+		return link
+	}
+
+	end := strings.IndexAny(link, "?#")
+	if end < 0 {
+		end = len(link)
+	}
+
+	// A file renamed in place only changes the last segment, so the rest of
+	// the link is kept as written
+	if sameDirs {
+		slash := strings.LastIndexByte(link[:end], '/')
+		return link[:slash+1] + escapePath(path.Base(newTarget)) + link[end:]
+	}
+	return escapePath(relativePath(path.Dir(newBase), newTarget)) + link[end:]
+}
+
+// relativePath is the path of target relative to the directory dir, both
+// entry names.
+func relativePath(dir, target string) string {
+	if dir == "." {
+		return target
+	}
+	from := strings.Split(dir, "/")
+	to := strings.Split(target, "/")
+
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+	parts := make([]string, 0, len(from)-common+len(to)-common)
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to[common:]...)
+	return strings.Join(parts, "/")
+}
+
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
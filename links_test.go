@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRewriteLinks(t *testing.T) {
+	renames := map[string]string{
+		"OEBPS/text/ch1.xhtml":    "OEBPS/chapters/part 1/ch1.xhtml",
+		"OEBPS/text/ch2.html":     "OEBPS/text/ch2.xhtml",
+		"OEBPS/text/notes.xhtml":  "OEBPS/notes.xhtml",
+		"OEBPS/images/cover.jpeg": "OEBPS/images/cover.jpg",
+	}
+	entries := map[string]string{
+		"OEBPS/content.opf": `<manifest><item id="c1" href="text/ch1.xhtml"/><item id="c2" href="text/ch2.html"/><item id="n" href="text/notes.xhtml"/><item id="img" href="images/cover.jpeg"/><item id="css" href="style.css"/></manifest>`,
+		"OEBPS/toc.ncx":     `<navPoint><content src="text/ch2.html#s1"/></navPoint><navPoint><content src='text/ch1.xhtml'/></navPoint>`,
+		"OEBPS/nav.xhtml":   `<a href="text/ch1.xhtml">One</a><a href="text/ch2.html#s1">Two</a>`,
+		"OEBPS/text/ch1.xhtml": `<link href="../style.css"/><a href="ch2.html#s1">next</a><a href="notes.xhtml#n1">note</a><img src="../images/cover.jpeg"/>` +
+			`<a href="#top">top</a><a href="https://example.com/ch2.html">web</a><a href="mailto:a@b.c">mail</a><svg><image xlink:href="../images/cover.jpeg"/></svg>`,
+		"OEBPS/text/ch2.html":    `<a HREF="ch1.xhtml?x=1#end">back</a><a href="./notes.xhtml">notes</a>`,
+		"OEBPS/text/notes.xhtml": `<a href="ch1.xhtml#n1">back</a><a href="ch2.html">two</a>`,
+	}
+	want := map[string]string{
+		"OEBPS/content.opf": `<manifest><item id="c1" href="chapters/part%201/ch1.xhtml"/><item id="c2" href="text/ch2.xhtml"/><item id="n" href="notes.xhtml"/><item id="img" href="images/cover.jpg"/><item id="css" href="style.css"/></manifest>`,
+		"OEBPS/toc.ncx":     `<navPoint><content src="text/ch2.xhtml#s1"/></navPoint><navPoint><content src='chapters/part%201/ch1.xhtml'/></navPoint>`,
+		"OEBPS/nav.xhtml":   `<a href="chapters/part%201/ch1.xhtml">One</a><a href="text/ch2.xhtml#s1">Two</a>`,
+		"OEBPS/text/ch1.xhtml": `<link href="../../style.css"/><a href="../../text/ch2.xhtml#s1">next</a><a href="../../notes.xhtml#n1">note</a><img src="../../images/cover.jpg"/>` +
+			`<a href="#top">top</a><a href="https://example.com/ch2.html">web</a><a href="mailto:a@b.c">mail</a><svg><image xlink:href="../../images/cover.jpg"/></svg>`,
+		"OEBPS/text/ch2.html":    `<a HREF="../chapters/part%201/ch1.xhtml?x=1#end">back</a><a href="../notes.xhtml">notes</a>`,
+		"OEBPS/text/notes.xhtml": `<a href="chapters/part%201/ch1.xhtml#n1">back</a><a href="text/ch2.xhtml">two</a>`,
+	}
+
+	names := make(map[string]bool)
+	for name := range entries {
+		if n, ok := renames[name]; ok {
+			name = n
+		}
+		names[name] = true
+	}
+	names["OEBPS/style.css"] = true
+	names["OEBPS/images/cover.jpg"] = true
+
+	for _, name := range sortedKeys(entries) {
+		got := string(rewriteLinks(name, []byte(entries[name]), renames))
+		if got != want[name] {
+			t.Errorf("%s:\ngot  %s\nwant %s", name, got, want[name])
+		}
+
+		// Every relative link must reach an entry from the new location
+		newName := name
+		if n, ok := renames[name]; ok {
+			newName = n
+		}
+		for _, m := range testLinkPattern.FindAllStringSubmatch(got, -1) {
+			link := m[1]
+			u, err := url.Parse(link)
+			if err != nil || u.Scheme != "" || u.Path == "" {
+				continue
+			}
+			if target := resolveHref(newName, link); !names[target] {
+				t.Errorf("%s: link %s dangles, %s doesn't exist", newName, link, target)
+			}
+		}
+	}
+}
+
+var testLinkPattern = regexp.MustCompile(`(?i)(?:href|src)=["']([^"']*)["']`)
+
+func TestRewriteLinksWithoutRenames(t *testing.T) {
+	data := []byte(`<a href="ch2.xhtml">two</a>`)
+	if got := rewriteLinks("OEBPS/ch1.xhtml", data, nil); string(got) != string(data) {
+		t.Errorf("got %s", got)
+	}
+	if got := rewriteLinks("OEBPS/ch1.xhtml", data, map[string]string{"OEBPS/other.xhtml": "OEBPS/x/other.xhtml"}); !strings.Contains(string(got), `href="ch2.xhtml"`) {
+		t.Errorf("an unrelated link changed: %s", got)
+	}
+}
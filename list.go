@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// listEpub prints every zip entry with its size and how it would be handled,
+// without calling the API or writing an output file.
+func listEpub(inputPath string, out io.Writer) error {
+	reader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return fmt.Errorf("could not open input epub: %w", err)
+	}
+	defer reader.Close()
+
+	pkg, err := readPackage(reader.File)
+	if err != nil {
+		log.Printf("Could not parse OPF, spine information unavailable: %v", err)
+	}
+
+	encrypted, err := encryptedEntries(reader.File)
+	if err != nil {
+		log.Printf("Could not parse encryption.xml: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tACTION\tSPINE\tEPUB:TYPE")
+
+	for _, file := range reader.File {
+		action := "copy"
+		epubType := "-"
+		if unsafeEntryName(file.Name) {
+			action = "skip (unsafe path)"
+		} else if encrypted[file.Name] {
+			action = "encrypted"
+		} else if isTranslatable(file.Name) {
+			action = "translate"
+			if t := readEpubType(file); t != "" {
+				epubType = t
+			}
+		} else if isNCX(file.Name) {
+			action = "translate"
+		}
+
+		spine := "-"
+		if pkg != nil {
+			if idx, ok := pkg.SpineIndex[file.Name]; ok {
+				spine = strconv.Itoa(idx + 1)
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", file.Name, file.UncompressedSize64, action, spine, epubType)
+	}
+
+	return tw.Flush()
+}
+
+// readEpubType returns the first epub:type found on the body or one of its
+// top-level sectioning elements.
+func readEpubType(file *zip.File) string {
+	rc, err := file.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(rc)
+	if err != nil {
+		return ""
+	}
+
+	epubType := ""
+	doc.Find("body, body > section, body > article, body > div").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if v, ok := s.Attr("epub:type"); ok {
+			epubType = v
+			return false
+		}
+		return true
+	})
+	return epubType
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListEpub(t *testing.T) {
+	input := writeZip(t, t.TempDir(), "book.epub", testBook(`<section epub:type="chapter"><p>Text.</p></section>`))
+
+	var out strings.Builder
+	if err := listEpub(input, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		fields := strings.Fields(line)
+		actions[fields[0]] = fields[2:]
+	}
+	tests := []struct {
+		name   string
+		action string
+		spine  string
+	}{
+		{chapterName(1), "translate", "1"},
+		{"OEBPS/nav.xhtml", "translate", "-"},
+		{"OEBPS/toc.ncx", "translate", "-"},
+		{"OEBPS/img/a.png", "copy", "-"},
+		{"OEBPS/content.opf", "copy", "-"},
+	}
+	for _, tt := range tests {
+		got := actions[tt.name]
+		if len(got) < 2 || got[0] != tt.action || got[1] != tt.spine {
+			t.Errorf("%s: got %v, want %s in spine position %s", tt.name, got, tt.action, tt.spine)
+		}
+	}
+	if got := actions[chapterName(1)]; len(got) < 3 || got[2] != "chapter" {
+		t.Errorf("chapter: got %v, want epub:type chapter", got)
+	}
+}
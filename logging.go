@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// logf logs a message about the file being translated. With a per-file
+// logger (see fileLogger), the line carries the file name.
+func (cfg *Config) logf(format string, args ...interface{}) {
+	if cfg.Logger != nil {
+		cfg.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// fileLogger returns the config for translating name. With concurrency,
+// lines get the file name as prefix so interleaved output stays
+// attributable; with -buffer-logs they are also collected and written in one
+// go by the returned flush function once the file is done.
+func fileLogger(name string, cfg *Config) (*Config, func()) {
+	if cfg.Concurrency <= 1 && !cfg.BufferLogs {
+		return cfg, func() {}
+	}
+
+	fileCfg := *cfg
+	prefix := fmt.Sprintf("[%s] ", name)
+	if !cfg.BufferLogs {
+		fileCfg.Logger = log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+		return &fileCfg, func() {}
+	}
+
+	var buf bytes.Buffer
+	fileCfg.Logger = log.New(&buf, prefix, log.Flags()|log.Lmsgprefix)
+	return &fileCfg, func() {
+		log.Writer().Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a log output that is safe for concurrent writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+// captureLog redirects the standard logger to a buffer for the test.
+func captureLog(t *testing.T) *syncBuffer {
+	out, flags := log.Writer(), log.Flags()
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return buf
+}
+
+func TestFileLogPrefixes(t *testing.T) {
+	chapters := []string{`<p>One text.</p>`, `<p>Two text.</p>`, `<p>Three text.</p>`}
+	for _, bufferLogs := range []bool{false, true} {
+		logged := captureLog(t)
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.Concurrency = 3
+		cfg.BufferLogs = bufferLogs
+		if _, err := translate(t, testBook(chapters...), cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		found := 0
+		var order []string
+		for _, line := range logged.lines() {
+			if !strings.Contains(line, "translatable nodes") {
+				continue
+			}
+			found++
+			name, _, ok := strings.Cut(strings.TrimPrefix(line, "["), "] ")
+			if !strings.HasPrefix(line, "[") || !ok {
+				t.Errorf("buffer-logs %v: line without a file prefix: %q", bufferLogs, line)
+				continue
+			}
+			order = append(order, name)
+		}
+		if found != len(chapters)+1 {
+			t.Errorf("buffer-logs %v: got %d lines about found nodes, want %d", bufferLogs, found, len(chapters)+1)
+		}
+		if bufferLogs {
+			var prefixed []string
+			for _, line := range logged.lines() {
+				if name, _, ok := strings.Cut(line, "] "); ok && strings.HasPrefix(name, "[") {
+					prefixed = append(prefixed, name)
+				}
+			}
+			done := make(map[string]bool)
+			for i, name := range prefixed {
+				if done[name] {
+					t.Errorf("lines of %s] are interleaved with those of other files: %q", name, prefixed)
+					break
+				}
+				if i+1 < len(prefixed) && prefixed[i+1] != name {
+					done[name] = true
+				}
+			}
+		}
+		for i := 1; i <= len(chapters); i++ {
+			if !strings.Contains(strings.Join(order, " "), chapterName(i)) {
+				t.Errorf("buffer-logs %v: no prefixed line for %s: %q", bufferLogs, chapterName(i), order)
+			}
+		}
+	}
+}
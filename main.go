@@ -1,28 +1,315 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/joho/godotenv"
 )
 
-type OpenAIResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+// Config holds the settings shared by all stages of a translation run.
+type Config struct {
+	// Provider is the -provider the blocks are sent to. The identity
+	// provider sends nothing and returns them unchanged, with IdentityMarker
+	// in front.
+	Provider       string
+	IdentityMarker string
+
+	APIKey     string
+	APIURL     string
+	Model      string
+	TargetLang string
+	// ModelMap overrides Model for some files, see modelFor.
+	ModelMap []modelRule
+	// SourceLang is optional; without it the model detects the source.
+	SourceLang string
+
+	// PivotLang, if set, is translated into first, see translateViaPivot.
+	PivotLang string
+
+	// Role is the -role the system prompt is sent with ("auto" picks it by
+	// model). Temperature is only sent if set and supported by the model.
+	Role        string
+	Temperature *float64
+
+	// BestOf is the number of choices requested per block, see pickChoice.
+	BestOf int
+	// LanguagePrompt replaces the default system prompt, see -prompt-dir.
+	LanguagePrompt string
+	// LanguageProfile holds the instructions of the -lang-profile, which
+	// the system prompt gives as the definition of TargetLang.
+	LanguageProfile string
+	// Tone is an optional register ("formal", "casual", ...), see toneInstructions.
+	Tone string
+
+	// OnlySelector, if set, replaces translatableSelector; nothing but the
+	// matching elements is translated.
+	OnlySelector string
+
+	// UnwrapSelector and RemoveSelector clean up the source before
+	// translation, see applyTransforms.
+	UnwrapSelector string
+	RemoveSelector string
+
+	// KeepTags, if set, are the tags a translation may contain besides
+	// those of its source, see restrictTags.
+	KeepTags map[string]bool
+
+	// Glossary pins the translation of names and terms. With
+	// GlossaryEnforce, every entry is enforced as if it were hard.
+	Glossary        Glossary
+	GlossaryEnforce bool
+
+	// Examples are sent before every block as few-shot turns.
+	Examples Examples
+
+	// FileSession gives captions and labels the translations of the text of
+	// their file as context; Session is the one of the file being
+	// translated, see fileSession.
+	FileSession bool
+	Session     *fileSession
+
+	// RequestTemplate and ResponsePath, if set, replace the provider's
+	// request body and the location of the translation in its response.
+	RequestTemplate *requestTemplate
+	ResponsePath    responsePath
+
+	// ImportedMemory maps source segments to known translations, see
+	// -import-tmx. ExportMemory collects the segments of the run for
+	// -export-tmx.
+	ImportedMemory map[string]string
+	ExportMemory   *translationMemory
+
+	// Stats counts the words of the file being translated, if set.
+	Stats *textStats
+
+	// Cache is optional; when set, translated blocks are looked up and stored there.
+	Cache *Cache
+
+	// PostHook is a shell command every translated file is piped through,
+	// see runPostHook. PostHookStrict makes its failures fatal.
+	PostHook       string
+	PostHookStrict bool
+
+	// TranslateIndex translates only the term labels of epub:type="index"
+	// sections, keeping locators and links.
+	TranslateIndex bool
+
+	// TranslateMediaOverlays also translates the text of .vtt captions and
+	// SMIL media overlays.
+	TranslateMediaOverlays bool
+
+	// KeepMediaStructure protects <audio>, <video> and epub:switch from the
+	// model and translates only their fallback text.
+	KeepMediaStructure bool
+
+	// TranslateCSSContent translates visible text in <style> content:
+	// strings instead of only warning about it.
+	TranslateCSSContent bool
+
+	// FigureContext passes the alt text of a figure's images along when
+	// translating its <figcaption>.
+	FigureContext bool
+
+	// Reference is a previous translation whose output is reused for
+	// source files that haven't changed since.
+	Reference *referenceEpub
+
+	// RepairXMLPaths limits the translation of XML files to the texts with
+	// these failure paths; each one that is found is set to true. See
+	// repairFile.
+	RepairXMLPaths map[string]bool
+
+	// CacheOnly fails every block the cache has no translation for instead
+	// of requesting it, see compareEpub.
+	CacheOnly bool
+
+	// RedactLog masks anything resembling a credential and keeps book
+	// content out of the log.
+	RedactLog bool
+
+	// Report collects per-book results for -report, if set.
+	Report *Report
+
+	// Audit logs every API call for -audit-log, if set. AuditFile and
+	// AuditBlocks say what the calls made with the config are about.
+	Audit       *AuditLog
+	AuditFile   string
+	AuditBlocks []AuditBlock
+
+	// HTTPClient is shared by all requests, so connections are reused.
+	HTTPClient *http.Client
+	// DoRequest, if set, sends the API calls instead of HTTPClient, the
+	// -health-check included, so a function can stand in for the API.
+	DoRequest func(*http.Request) (*http.Response, error)
+
+	// UserAgent is sent with every request. If RequestIDHeader is set, RunID
+	// is sent in that header so a gateway can trace the requests of a run.
+	UserAgent       string
+	RequestIDHeader string
+	RunID           string
+
+	// QuoteStyle, if set, replaces the quotation marks in translated text,
+	// see -localize-punctuation.
+	QuoteStyle *quoteStyle
+
+	// MinTextLength is the number of characters other than whitespace a
+	// block needs to be translated, see hasTranslatableText.
+	MinTextLength int
+
+	// LengthRatioMin and LengthRatioMax bound the length of a translation
+	// relative to its source, see checkLength. Zero disables a bound.
+	LengthRatioMin float64
+	LengthRatioMax float64
+
+	// BidiFixup adds directional marks to translations into a right-to-left
+	// language, see fixBidi.
+	BidiFixup bool
+
+	// NormalizeWhitespace cleans up the spacing of translations, see
+	// normalizeWhitespace.
+	NormalizeWhitespace bool
+
+	// TranslateLabels also translates page titles and accessibility labels,
+	// see translateLabels.
+	TranslateLabels bool
+
+	// TokenizeTags sends blocks as text with placeholders for their tags,
+	// see translateTokenized.
+	TokenizeTags bool
+
+	// LineEndings is the -line-endings mode for translated files, BOM the
+	// -bom mode.
+	LineEndings string
+	BOM         string
+
+	// BatchTokenBudget, if positive, groups blocks into one request up to
+	// this many estimated tokens, see translateBatched.
+	BatchTokenBudget int
+
+	// MergeSmallFiles, if positive, batches the blocks of consecutive
+	// (X)HTML files smaller than this many bytes, see translateFileGroup.
+	MergeSmallFiles int64
+
+	// MaxFileSize, if positive, is the size above which a file is handled
+	// as -max-file-size-action OversizedAction says.
+	MaxFileSize     int64
+	OversizedAction string
+
+	// IgnoreEncryption copies DRM-encrypted files through instead of
+	// refusing the book.
+	IgnoreEncryption bool
+
+	// Reproducible writes entries with a fixed timestamp and compression
+	// level, see newEntryWriter.
+	Reproducible bool
+
+	// MarkPlaceholders marks the blocks instead of translating them, and
+	// FillPlaceholders translates only the marked ones, see markPlaceholders.
+	MarkPlaceholders bool
+	FillPlaceholders bool
+
+	// TOCOnly translates only the OPF metadata and the tables of contents,
+	// copying the content files.
+	TOCOnly bool
+	// TOCFromHeadings takes the labels of the tables of contents from the
+	// translated chapter headings, see tocFromHeadings.
+	TOCFromHeadings bool
+
+	// MetadataFields are the OPF metadata fields to translate, see
+	// translateOPFMetadata.
+	MetadataFields []string
+
+	// ReorderBySpine writes the spine documents in reading order.
+	ReorderBySpine bool
+
+	// FlattenExtensions, if set, is the extension ("xhtml" or "html") all
+	// content files get, see extensionRenames.
+	FlattenExtensions string
+
+	// SaveIntermediate, if set, is the directory each translated file is
+	// written to as soon as it is done, see saveIntermediate.
+	SaveIntermediate string
+
+	// CompressionLevel is the -compression-level of the output entries, see
+	// compressionLevel; empty for the default.
+	CompressionLevel string
+
+	// KeepOriginalFile adds the untranslated chapters after the translated
+	// ones, see originalEdition.
+	KeepOriginalFile bool
+
+	// BlockTimeout, if positive, is the total time a block (or batch) may
+	// take including retries before it keeps its original text.
+	BlockTimeout time.Duration
+
+	// ReasoningFilter removes the -strip-tags reasoning from responses.
+	ReasoningFilter *reasoningFilter
+
+	// Replacements are the -replace-map rules for the translated text.
+	Replacements Replacements
+
+	// RetryDelay is the wait before the first retry of a failed request. It
+	// doubles after every attempt, and triples after a 429.
+	RetryDelay time.Duration
+
+	// RetryBudget is shared by all blocks of the run, see retryBudget.
+	RetryBudget *retryBudget
+	// RampUp staggers the first requests of the run, see rampUp.
+	RampUp *rampUp
+
+	// Abort is set when the run has to stop, see ContinueOnAuthError.
+	Abort *abortSignal
+	// UpdateModified sets the dcterms:modified date of the output's package
+	// to the time of the translation; UpdateDate does the same for the EPUB 2
+	// <dc:date opf:event="modification">. See touchModified.
+	UpdateModified bool
+	UpdateDate     bool
+
+	// OriginalClass marks the original-language blocks of content that is
+	// already bilingual; they and their translations are kept as they are,
+	// see bilingualPairs. DropOriginals removes the originals instead.
+	OriginalClass string
+	DropOriginals bool
+
+	// StartAt is the -start-at spine file or position; the spine before it
+	// is copied untranslated.
+	StartAt string
+
+	// NameFromTitle names the output after the translated dc:title.
+	NameFromTitle bool
+
+	// CheckpointEvery saves the partial book every this many translated
+	// files, see checkpoint; 0 disables it.
+	CheckpointEvery int
+
+	// ContinueOnFileError copies a file that could not be translated at all
+	// instead of failing the book.
+	ContinueOnFileError bool
+
+	// ContinueOnAuthError keeps retrying and translating after a 401/403
+	// instead of aborting the run.
+	ContinueOnAuthError bool
+
+	// Concurrency is the number of files translated in parallel.
+	Concurrency int
+	// NodeConcurrency is the number of blocks of a file translated in
+	// parallel, see translateConcurrently.
+	NodeConcurrency int
+	// BufferLogs collects the log lines of a file and writes them together
+	// when it is done. Logger, if set, is the logger of the file being
+	// translated, see fileLogger.
+	BufferLogs bool
+	Logger     *log.Logger
+	// MaxMemory caps the bytes of file content buffered between translation
+	// and writing. Zero means unlimited.
+	MaxMemory int64
 }
 
 func main() {
@@ -30,207 +317,671 @@ func main() {
 		log.Println("No .env file found, using environment variables")
 	}
 
+	configPath := flag.String("config", "", "YAML file with flag settings (keys are flag names); flags on the command line override it")
+	listFiles := flag.Bool("list", false, "List all entries of the EPUB and how they would be handled, without translating")
+	cachePath := flag.String("cache", os.Getenv("TRANSLATION_CACHE"), "Path to a persistent block cache (JSON), reused across runs")
+	outDir := flag.String("out-dir", ".", "Directory the translated EPUBs are written to")
+	watch := flag.Bool("watch", false, "Keep watching the input directory and translate new EPUBs as they appear")
+	concurrency := flag.Int("file-concurrency", 1, "Number of files translated in parallel")
+	flag.IntVar(concurrency, "concurrency", 1, "Same as -file-concurrency")
+	nodeConcurrency := flag.Int("node-concurrency", 1, "Number of blocks of a file translated in parallel; the requests in flight are up to -file-concurrency times this")
+	bufferLogs := flag.Bool("buffer-logs", false, "Print the log lines of each file together once it is done instead of as they happen")
+	tone := flag.String("tone", os.Getenv("TARGET_STYLE"), "Register of the translation: formal, casual, literary or technical (default: unspecified)")
+	postHook := flag.String("post-hook", "", "Shell command each translated file is piped through (stdin -> stdout), e.g. a spell checker")
+	postHookStrict := flag.Bool("post-hook-strict", false, "Abort the run if the post-hook fails instead of keeping the unmodified file")
+	fileSessionFlag := flag.Bool("file-session", false, "Translate captions and labels after the text of their file, with the passages that share their words as context")
+	figureCtx := flag.Bool("figure-context", false, "Give the model the image alt text as context when translating a <figcaption>")
+	referencePath := flag.String("reference", "", "Previously translated EPUB; files whose source is unchanged are copied from it instead of translated")
+	translateCSS := flag.Bool("translate-css-content", false, "Translate visible text in content: strings of <style> blocks (otherwise only a warning is logged)")
+	redactLog := flag.Bool("redact-log", false, "Mask API keys and authorization headers in logged error bodies and keep book content out of the log")
+	langProfilePath := flag.String("lang-profile", "", "YAML file describing the target as a profile (plain language, house style): instructions for the prompt and optionally the underlying language")
+	promptDir := flag.String("prompt-dir", "", "Directory with per-language system prompts (de.txt, ja.txt, ...) used instead of the default prompt")
+	auditPath := flag.String("audit-log", "", "Append a JSON line for every API call (time, model, tokens, latency, retries, file and blocks) to this file")
+	auditContent := flag.Bool("audit-log-content", false, "With -audit-log, also record the request bodies and the translations instead of only their hashes")
+	reportPath := flag.String("report", "", "Write a JSON report listing the blocks that could not be translated")
+	userAgent := flag.String("user-agent", defaultUserAgent(), "User-Agent header sent to the API")
+	requestIDHeader := flag.String("request-id-header", "", "Name of a header (e.g. X-Request-ID) carrying a per-run UUID for server-side tracing")
+	glossaryPath := flag.String("glossary", "", "Glossary file with one \"source = target\" term per line")
+	glossaryEnforce := flag.Bool("glossary-enforce", false, "Replace glossary terms the model left untranslated by their translation, for all entries rather than just \"source == target\" ones")
+	replaceMap := flag.String("replace-map", "", "File with \"from => to\" replacements (literal, or /regex/) applied to the translated text, outside of tags")
+	examplesPath := flag.String("examples", "", "YAML file with example translations (source/target pairs) sent before every block")
+	exportTMX := flag.String("export-tmx", "", "Write all translated segments of the run to this TMX file")
+	importTMX := flag.String("import-tmx", "", "TMX file whose segments are used instead of asking the model when the source matches exactly")
+	onlySelector := flag.String("only-selector", "", "Translate only the elements matching this CSS selector (e.g. \"h1,h2,h3\") instead of all text blocks, copying everything else")
+	unwrap := flag.String("unwrap", "", "CSS selector of elements to replace by their content before translating, e.g. \"span:not([class])\"")
+	remove := flag.String("remove", "", "CSS selector of elements to delete with their content before translating, e.g. \"span.tracking\"")
+	keepTags := flag.String("keep-tags-list", "", "Comma-separated tags a translation may contain besides those of its source (e.g. em,strong,a,i,b,sub,sup); others are stripped")
+	minTextLength := flag.Int("min-text-length", 2, "Leave blocks with fewer characters than this (not counting whitespace and code) untranslated, e.g. drop caps and stray punctuation")
+	lengthRatioMin := flag.Float64("length-ratio-min", 0.3, "Warn about translated blocks shorter than this fraction of their source (0 = never)")
+	lengthRatioMax := flag.Float64("length-ratio-max", 3, "Warn about translated blocks longer than this multiple of their source (0 = never)")
+	tokenizeTags := flag.Bool("tokenize-tags", false, "Send the text of blocks with numbered placeholders instead of their HTML tags, and put the tags back afterwards")
+	translateLabels := flag.Bool("translate-labels", false, "Also translate page titles, title/description metas and accessibility labels (aria-label, aria-labelledby targets)")
+	normalizeWS := flag.Bool("normalize-whitespace", false, "Collapse repeated spaces in translations and remove spaces at the start and end of blocks")
+	bidiFixup := flag.Bool("bidi-fixup", false, "For right-to-left targets (Arabic, Hebrew, Persian, Urdu), add directional marks so embedded Latin words and symbols are ordered correctly")
+	localizePunct := flag.Bool("localize-punctuation", false, "Convert quotation marks in the translation to the target language's conventions")
+	totalRetryBudget := flag.Duration("total-retry-budget", 0, "Time the whole run may spend on failed requests and waiting for retries, e.g. 30m; after that, blocks fail without retrying (0 = no limit)")
+	stripTags := flag.String("strip-tags", "", "Comma-separated tags the model wraps its reasoning in, e.g. think,reasoning; such blocks at the start of a response are removed")
+	rampUpWindow := flag.Duration("ramp-up", 0, "Stagger the start of the parallel requests over this time, e.g. 10s, instead of sending them all at once (0 = off)")
+	retryDelay := flag.Duration("retry-delay", 5*time.Second, "Wait before the first retry of a failed request; doubled after every further attempt, tripled after a 429")
+	blockTimeout := flag.Duration("block-timeout", 0, "Give up on a block after this long including retries, e.g. 2m (0 = no limit)")
+	healthCheck := flag.Bool("health-check", true, "Send one test request before processing, to stop early if the API key, URL or model are wrong")
+	updateModified := flag.Bool("update-modified", true, "Set the dcterms:modified date of the output to the time of the translation, adding it to EPUB 3 books without one")
+	updateDate := flag.Bool("update-date", false, "Also set the EPUB 2 modification date (<dc:date opf:event=\"modification\">) if the book has one")
+	startAt := flag.String("start-at", "", "Copy the spine before this file (name or 1-based position) untranslated and translate from it on, e.g. to work on one chapter")
+	nameFromTitle := flag.Bool("name-from-title", false, "Name the output after the translated title of the book instead of the input file")
+	checkpointEvery := flag.Int("checkpoint-every", 0, "Save the book translated so far as a valid EPUB (OUTPUT.partial.epub) every N files, to resume from with -reference after a crash (0 = off)")
+	continueOnFileError := flag.Bool("continue-on-file-error", false, "Copy a file that can't be translated (e.g. malformed markup) untranslated with a warning instead of failing the book")
+	continueOnAuth := flag.Bool("continue-on-auth-error", false, "Keep going when the API rejects the credentials (401/403) instead of aborting the run")
+	maxConns := flag.Int("max-conns", 0, "Maximum number of connections to the API host, independent of -concurrency (0 = no limit)")
+	stripFailed := flag.Bool("strip-markers", false, "Write clean copies of finished EPUBs without the \"Translation failed\" markers, without translating")
+	stripOriginals := flag.Bool("strip-originals", false, "With -strip-markers, also remove the -original-class blocks of bilingual output")
+	originalClass := flag.String("original-class", "original", "Class of the original-language blocks of bilingual content; a source that already has them keeps them and the translation after each untranslated (empty = no detection)")
+	dropOriginals := flag.Bool("drop-originals", false, "Remove the -original-class blocks of a bilingual source, keeping only their translations")
+	retryReport := flag.String("retry-report", "", "Re-translate the failed blocks listed in a report from a previous run, repairing its output EPUBs in place")
+	lineEndings := flag.String("line-endings", lineEndingsLF, "Line endings of translated files: lf, crlf or preserve (same as the source)")
+	bomMode := flag.String("bom", bomStrip, "Byte order mark of translated files whose source has one: strip or preserve")
+	sourceLang := flag.String("source-lang", os.Getenv("SOURCE_LANGUAGE"), "Language of the book (env: SOURCE_LANGUAGE); detected by the model if not set")
+	pivotLang := flag.String("pivot-lang", "", "Translate every block into this language first and from there into the target language (doubles the requests)")
+	modelMap := flag.String("model-map", "", "Models for some files, first match wins: \"chapter*:strong-model,type=frontmatter:cheap-model\" (file name patterns or epub:type); others use -model")
+	requestTemplatePath := flag.String("request-template", "", "JSON file with the request body to send instead of the provider's, with {{model}}, {{system}}, {{content}}, {{temperature}} and {{messages}} placeholders")
+	responsePathFlag := flag.String("response-path", "", "Location of the translation in API responses, as keys and indexes separated by dots (e.g. output.text), instead of the provider's")
+	provider := flag.String("provider", providerOpenAI, "Translation backend: openai (an OpenAI-compatible API), anthropic (the Anthropic Messages API) or identity (no API, returns the text unchanged for testing)")
+	identityMarker := flag.String("identity-marker", "", "With -provider identity, put this marker (e.g. \"[de]\") in front of every block")
+	modelFlag := flag.String("model", os.Getenv("GEMINI_MODEL"), "Model name (env: GEMINI_MODEL)")
+	role := flag.String("role", roleAuto, "Role of the instructions: auto (developer for o-series models, system otherwise), system or developer")
+	bestOf := flag.Int("best-of", 1, "Ask for this many translations of every block (OpenAI n parameter) and keep the first one with the tags of the source")
+	temperature := flag.Float64("temperature", -1, "Sampling temperature; not sent if negative or unsupported by the model")
+	compare := flag.String("compare", "", "Previously translated EPUB to compare the cached translations with; lists the blocks that would differ, without calling the API or writing output")
+	preview := flag.String("preview", "", "Translate only this chapter (entry name, see -list) and write a before/after HTML page instead of an EPUB")
+	translateIndex := flag.Bool("translate-index", false, "In epub:type=\"index\" sections, translate only the term labels and keep page references and links")
+	translateOverlays := flag.Bool("translate-media-overlays", false, "Also translate WebVTT cue text and SMIL <text> content, keeping timings and src references")
+	keepMedia := flag.Bool("keep-media-structure", true, "Translate only the fallback text of <audio>, <video> and epub:switch, keeping sources and cases untouched")
+	batchBudget := flag.Int("batch-token-budget", 0, "Send several blocks per request, up to this many estimated tokens (0 = one block per request)")
+	mergeSmallFiles := flag.String("merge-small-files", "0", "Batch the blocks of consecutive content files smaller than this size (e.g. 4KB, 0 to disable)")
+	confirm := flag.Bool("confirm", false, "Estimate the run first and ask before starting if it exceeds -confirm-requests or -confirm-cost")
+	confirmRequests := flag.Int("confirm-requests", 500, "With -confirm, ask if the run needs more requests than this")
+	confirmCost := flag.Float64("confirm-cost", 1, "With -confirm, ask if the estimated cost exceeds this (requires -price-per-mtok)")
+	pricePerMTok := flag.Float64("price-per-mtok", 0, "Price per million tokens, used for cost estimates")
+	yes := flag.Bool("yes", false, "Don't ask for confirmation, see -confirm")
+	ignoreEncryption := flag.Bool("ignore-encryption", false, "Translate DRM-protected EPUBs anyway, copying the encrypted files through untouched")
+	reproducible := flag.Bool("reproducible", false, "Write byte-identical EPUBs for identical translations: fixed timestamps (SOURCE_DATE_EPOCH) and compression level")
+	markPlaceholders := flag.Bool("translate-placeholder", false, "Don't translate, mark every block for translation (data-epub-translator-placeholder) for a manual or later pass")
+	fillPlaceholders := flag.Bool("fill-placeholders", false, "Translate only the blocks marked by -translate-placeholder, removing their markers")
+	metadataFields := flag.String("translate-metadata", "", "Comma-separated OPF metadata fields to translate, e.g. dc:title,dc:subject,belongs-to-collection,calibre:series (default with -toc-only: dc:title,dc:description)")
+	tocFromHeadingsFlag := flag.Bool("toc-from-headings", false, "After translating, set the table of contents labels (nav and NCX) to the translated <h1>/<h2> of the chapters they link to, so both read the same")
+	tocOnly := flag.Bool("toc-only", false, "Only translate the title, description and table of contents (NCX and nav document), copying all chapters untouched")
+	saveIntermediateDir := flag.String("save-intermediate", "", "Also write each translated file to this directory as soon as it is done")
+	compression := flag.String("compression-level", "", "Compression of the output entries: a deflate level from 0 (none) to 9 (smallest), or store (default: the library's level)")
+	keepOriginal := flag.Bool("keep-original-file", false, "Also include the untranslated chapters, after the translated ones in the spine and in a section of their own in the table of contents")
+	flattenExt := flag.String("flatten-xhtml-extensions", "", "Give all content files this extension (xhtml or html), updating the manifest and all links")
+	reorderBySpine := flag.Bool("reorder-by-spine", false, "Write the chapters in reading (spine) order instead of their order in the input zip")
+	maxFileSize := flag.String("max-file-size", "0", "Treat content files larger than this size (e.g. 2MB) as -max-file-size-action says (0 = no limit)")
+	oversizedAction := flag.String("max-file-size-action", oversizedCopy, "What to do with files larger than -max-file-size: copy (untranslated) or chunk (translate their blocks in batches)")
+	listLangs := flag.Bool("list-languages", false, "List the known target languages and their codes, then exit")
+	maxMemory := flag.String("max-memory", "256MB", "Upper bound for file content buffered in memory (e.g. 512MB, 2GB, 0 for unlimited)")
+	flag.Parse()
+
+	if *configPath != "" {
+		if err := applyConfigFile(*configPath, flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *listLangs {
+		if err := listLanguages(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() < 1 && *retryReport == "" {
+		log.Fatal("Usage: epub-translator [flags] <input.epub|directory|glob>...")
+	}
+
+	inputPath := flag.Arg(0)
+
+	if *listFiles {
+		if err := listEpub(inputPath, os.Stdout); err != nil {
+			log.Fatalf("Error listing epub: %v", err)
+		}
+		return
+	}
+
+	if *stripFailed {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			log.Fatalf("Error creating output directory: %v", err)
+		}
+		stripCfg := &Config{Reproducible: *reproducible, OriginalClass: *originalClass}
+		for _, input := range flag.Args() {
+			outputPath := stripOutputPath(input, *outDir)
+			if err := stripMarkers(input, outputPath, *stripOriginals, stripCfg); err != nil {
+				log.Printf("Error cleaning %s: %v", input, err)
+				os.Exit(exitCode(err))
+			}
+			fmt.Printf("Wrote clean copy of %s to %s\n", input, outputPath)
+		}
+		return
+	}
+
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	apiUrl := os.Getenv("GEMINI_API_URL")
-	model := os.Getenv("GEMINI_MODEL")
+	model := *modelFlag
 	targetLang := os.Getenv("TARGET_LANGUAGE")
 
 	if targetLang == "" {
 		targetLang = "German" // My personal Fallback
 	}
 
-	if apiKey == "" || apiUrl == "" || model == "" {
-		log.Fatal("GEMINI_API_KEY, GEMINI_API_URL, and GEMINI_MODEL must be set")
+	if err := validateProvider(*provider); err != nil {
+		log.Fatal(err)
+	}
+
+	// No API is involved; the model name keys the cache, and the unchanged
+	// text must not be found there later under a real model
+	if *provider == providerIdentity {
+		model = providerIdentity
+	}
+	if *provider == providerAnthropic && apiUrl == "" {
+		apiUrl = anthropicURL
+	}
+
+	if *markPlaceholders && *fillPlaceholders {
+		log.Fatal("-translate-placeholder and -fill-placeholders are separate passes, use one of them")
+	}
+
+	if *provider != providerIdentity && !*markPlaceholders && *compare == "" && (apiKey == "" || apiUrl == "" || model == "") {
+		log.Fatal("GEMINI_API_KEY, GEMINI_API_URL, and GEMINI_MODEL (or -model) must be set")
+	}
+
+	// With a profile, the target is just its name and the language-specific
+	// features go by the profile's language, if it has one
+	featureLang := targetLang
+	var profile *languageProfile
+	if *langProfilePath != "" {
+		var err error
+		if profile, err = loadLanguageProfile(*langProfilePath); err != nil {
+			log.Fatalf("Error loading language profile: %v", err)
+		}
+		featureLang = profile.Language
+		log.Printf("Using language profile %s", *langProfilePath)
 	}
 
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: epub-translator <input.epub>")
+	// Any language works with the model, but the language-specific features
+	// (and the model itself) do better with a name it recognizes
+	if warning := unknownLanguageWarning(targetLang); warning != "" && profile == nil {
+		log.Print(warning)
 	}
 
 	log.Printf("Starting translation with model: %s, target language: %s", model, targetLang)
 
-	inputPath := os.Args[1]
-	timestamp := time.Now().Format("20060102-1504")
-	inputFilename := filepath.Base(inputPath)
-	outputPath := fmt.Sprintf("translated-%s-%s", timestamp, inputFilename)
+	if _, ok := toneInstructions[*tone]; *tone != "" && !ok {
+		log.Fatalf("Unknown tone %q, expected one of: formal, casual, literary, technical", *tone)
+	}
 
-	err := processEpub(inputPath, outputPath, apiKey, apiUrl, model, targetLang)
-	if err != nil {
-		log.Fatalf("Error processing epub: %v", err)
+	if err := validateLineEndings(*lineEndings); err != nil {
+		log.Fatal(err)
 	}
 
-	fmt.Printf("Successfully translated EPUB to %s\n", outputPath)
-}
+	if err := validateBOM(*bomMode); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateOversizedAction(*oversizedAction); err != nil {
+		log.Fatal(err)
+	}
 
-func processEpub(inputPath, outputPath, apiKey, apiUrl, model string, targetLang string) error {
-	reader, err := zip.OpenReader(inputPath)
+	if *compression != "" {
+		if _, _, err := compressionLevel(*compression); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *bestOf > 1 && *provider != providerOpenAI {
+		log.Fatal("-best-of needs the openai provider")
+	}
+
+	metadata, err := parseMetadataFields(*metadataFields)
 	if err != nil {
-		return fmt.Errorf("could not open input epub: %w", err)
+		log.Fatal(err)
+	}
+	if len(metadata) == 0 && *tocOnly {
+		metadata = defaultMetadataFields
+	}
+	if *tocFromHeadingsFlag && *tocOnly {
+		log.Fatal("-toc-from-headings needs the translated chapters, it can't be used with -toc-only")
+	}
+
+	if err := validateRole(*role); err != nil {
+		log.Fatal(err)
 	}
-	defer reader.Close()
 
-	outputFile, err := os.Create(outputPath)
+	modelRules, err := parseModelMap(*modelMap)
 	if err != nil {
-		return fmt.Errorf("could not create output file: %w", err)
+		log.Fatal(err)
 	}
-	defer outputFile.Close()
 
-	writer := zip.NewWriter(outputFile)
-	defer writer.Close()
+	if err := validateExtension(*flattenExt); err != nil {
+		log.Fatal(err)
+	}
 
-	numberOfXml := 0
-	xmlIndex := 0
+	if err := validateSelector("-only-selector", *onlySelector); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSelector("-unwrap", *unwrap); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSelector("-remove", *remove); err != nil {
+		log.Fatal(err)
+	}
 
-	for _, file := range reader.File {
-		ext := strings.ToLower(filepath.Ext(file.Name))
-		if ext == ".xhtml" || ext == ".html" {
-			numberOfXml++
-		}
+	if *bidiFixup && featureLang == "" {
+		log.Printf("Warning: the language profile has no language, ignoring -bidi-fixup")
+		*bidiFixup = false
+	} else if *bidiFixup && !isRTLLanguage(featureLang) {
+		log.Printf("Warning: %s is not a right-to-left language, ignoring -bidi-fixup", featureLang)
+		*bidiFixup = false
 	}
 
-	log.Printf("Found %d HTML/XHTML files to translate.", numberOfXml)
+	memLimit, err := parseByteSize(*maxMemory)
+	if err != nil {
+		log.Fatalf("Invalid -max-memory: %v", err)
+	}
+	smallFileSize, err := parseByteSize(*mergeSmallFiles)
+	if err != nil {
+		log.Fatalf("Invalid -merge-small-files: %v", err)
+	}
+	fileSizeLimit, err := parseByteSize(*maxFileSize)
+	if err != nil {
+		log.Fatalf("Invalid -max-file-size: %v", err)
+	}
 
-	for _, file := range reader.File {
-		ext := strings.ToLower(filepath.Ext(file.Name))
+	cfg := &Config{
+		Provider:               *provider,
+		IdentityMarker:         *identityMarker,
+		APIKey:                 apiKey,
+		APIURL:                 apiUrl,
+		Model:                  model,
+		ModelMap:               modelRules,
+		TargetLang:             targetLang,
+		SourceLang:             *sourceLang,
+		Tone:                   *tone,
+		Role:                   *role,
+		BestOf:                 *bestOf,
+		PostHook:               *postHook,
+		PostHookStrict:         *postHookStrict,
+		FigureContext:          *figureCtx,
+		FileSession:            *fileSessionFlag,
+		TranslateCSSContent:    *translateCSS,
+		KeepMediaStructure:     *keepMedia,
+		TranslateMediaOverlays: *translateOverlays,
+		OnlySelector:           *onlySelector,
+		UnwrapSelector:         *unwrap,
+		RemoveSelector:         *remove,
+		TranslateIndex:         *translateIndex,
+		RedactLog:              *redactLog,
+		UserAgent:              *userAgent,
+		RequestIDHeader:        *requestIDHeader,
+		RunID:                  newRunID(),
+		HTTPClient:             newHTTPClient(*maxConns),
+		LineEndings:            *lineEndings,
+		BidiFixup:              *bidiFixup,
+		NormalizeWhitespace:    *normalizeWS,
+		TranslateLabels:        *translateLabels,
+		TokenizeTags:           *tokenizeTags,
+		MinTextLength:          *minTextLength,
+		LengthRatioMin:         *lengthRatioMin,
+		LengthRatioMax:         *lengthRatioMax,
+		BOM:                    *bomMode,
+		BatchTokenBudget:       *batchBudget,
+		MergeSmallFiles:        smallFileSize,
+		MaxFileSize:            fileSizeLimit,
+		OversizedAction:        *oversizedAction,
+		ReorderBySpine:         *reorderBySpine,
+		FlattenExtensions:      *flattenExt,
+		KeepOriginalFile:       *keepOriginal,
+		CompressionLevel:       *compression,
+		SaveIntermediate:       *saveIntermediateDir,
+		TOCOnly:                *tocOnly,
+		TOCFromHeadings:        *tocFromHeadingsFlag,
+		MetadataFields:         metadata,
+		MarkPlaceholders:       *markPlaceholders,
+		FillPlaceholders:       *fillPlaceholders,
+		Reproducible:           *reproducible,
+		IgnoreEncryption:       *ignoreEncryption,
+		BlockTimeout:           *blockTimeout,
+		RetryDelay:             *retryDelay,
+		RetryBudget:            newRetryBudget(*totalRetryBudget),
+		RampUp:                 newRampUp(*rampUpWindow, max(*concurrency, 1)*max(*nodeConcurrency, 1)),
+		Abort:                  &abortSignal{},
+		ContinueOnAuthError:    *continueOnAuth,
+		ContinueOnFileError:    *continueOnFileError,
+		CheckpointEvery:        *checkpointEvery,
+		NameFromTitle:          *nameFromTitle,
+		StartAt:                *startAt,
+		OriginalClass:          *originalClass,
+		DropOriginals:          *dropOriginals,
+		UpdateModified:         *updateModified,
+		UpdateDate:             *updateDate,
+		Concurrency:            *concurrency,
+		NodeConcurrency:        *nodeConcurrency,
+		BufferLogs:             *bufferLogs,
+		MaxMemory:              memLimit,
+	}
 
-		if ext == ".xhtml" || ext == ".html" {
-			xmlIndex++
-			log.Printf("Translating %s... (%v/%v)", file.Name, xmlIndex, numberOfXml)
+	if *cachePath != "" {
+		cache, err := loadCache(*cachePath)
+		if err != nil {
+			log.Fatalf("Error loading cache: %v", err)
 		}
+		log.Printf("Using cache %s (%d entries)", *cachePath, cache.Len())
+		cfg.Cache = cache
+	}
 
-		err := processFile(file, writer, apiKey, apiUrl, model, targetLang)
+	if *temperature >= 0 {
+		cfg.Temperature = temperature
+	}
 
-		if err != nil {
-			return fmt.Errorf("error processing file %s: %w", file.Name, err)
+	if cfg.RequestIDHeader != "" {
+		log.Printf("Run ID %s (sent as %s)", cfg.RunID, cfg.RequestIDHeader)
+	}
+
+	if *pivotLang != "" {
+		if sameLanguage(*pivotLang, targetLang) {
+			log.Printf("Pivot language %s is the target language, translating directly", *pivotLang)
+		} else {
+			log.Printf("Translating via %s", *pivotLang)
+			cfg.PivotLang = *pivotLang
 		}
 	}
 
-	return nil
-}
+	if *keepTags != "" {
+		cfg.KeepTags = parseTagList(*keepTags)
+	}
 
-func processFile(file *zip.File, writer *zip.Writer, apiKey, apiUrl, model string, targetLang string) error {
-	rc, err := file.Open()
-	if err != nil {
-		return err
+	if *localizePunct && featureLang == "" {
+		log.Printf("Warning: the language profile has no language, ignoring -localize-punctuation")
+	} else if *localizePunct {
+		style, ok := quoteStyleFor(featureLang)
+		if !ok {
+			log.Fatalf("-localize-punctuation has no quotation rules for %s", featureLang)
+		}
+		cfg.QuoteStyle = &style
 	}
-	defer rc.Close()
 
-	w, err := writer.Create(file.Name)
-	if err != nil {
-		return err
+	if profile != nil {
+		cfg.LanguageProfile = profile.Instructions
 	}
 
-	ext := strings.ToLower(filepath.Ext(file.Name))
-	if ext == ".xhtml" || ext == ".html" {
-		return translateHTML(rc, w, apiKey, apiUrl, model, targetLang)
+	if *glossaryPath != "" {
+		glossary, err := loadGlossary(*glossaryPath)
+		if err != nil {
+			log.Fatalf("Error loading glossary: %v", err)
+		}
+		log.Printf("Using glossary %s (%d terms)", *glossaryPath, len(glossary))
+		cfg.Glossary = glossary
+		cfg.GlossaryEnforce = *glossaryEnforce
 	}
 
-	_, err = io.Copy(w, rc)
-	return err
-}
+	if *replaceMap != "" {
+		replacements, err := loadReplacements(*replaceMap)
+		if err != nil {
+			log.Fatalf("Error loading replace map: %v", err)
+		}
+		log.Printf("Using %d replacements from %s", len(replacements), *replaceMap)
+		cfg.Replacements = replacements
+	}
 
-func translateHTML(r io.Reader, w io.Writer, apiKey, apiUrl, model string, targetLang string) error {
-	doc, err := goquery.NewDocumentFromReader(r)
-	if err != nil {
-		return err
+	if *examplesPath != "" {
+		examples, err := loadExamples(*examplesPath)
+		if err != nil {
+			log.Fatalf("Error loading examples: %v", err)
+		}
+		log.Printf("Using %d examples from %s", len(examples), *examplesPath)
+		cfg.Examples = examples
 	}
 
-	// Tags to translate
-	selection := doc.Find("p, h1, h2, h3, h4, h5, h6, li, span")
-	log.Printf("  -> Found %d translatable nodes", selection.Length())
+	reasoning, err := parseReasoningTags(*stripTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.ReasoningFilter = reasoning
 
-	selection.Each(func(i int, s *goquery.Selection) {
-		// Only translate if there's text and it's not just whitespace
-		if strings.TrimSpace(s.Text()) == "" {
-			return
+	if *requestTemplatePath != "" {
+		template, err := loadRequestTemplate(*requestTemplatePath)
+		if err != nil {
+			log.Fatalf("Error loading request template: %v", err)
 		}
+		cfg.RequestTemplate = template
+	}
+	if *responsePathFlag != "" {
+		path, err := parseResponsePath(*responsePathFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.ResponsePath = path
+	}
 
-		// Use innerHTML to keep nested tags like <em> or <strong>
-		inner, err := s.Html()
+	if *importTMX != "" {
+		if featureLang == "" {
+			log.Fatal("-import-tmx needs the language of the target, set it in the -lang-profile")
+		}
+		memory, err := loadTMX(*importTMX, featureLang)
 		if err != nil {
-			return
+			log.Fatalf("Error loading translation memory: %v", err)
 		}
+		log.Printf("Using translation memory %s (%d segments)", *importTMX, len(memory))
+		cfg.ImportedMemory = memory
+	}
 
-		translated := translateNode(inner, apiKey, apiUrl, model, targetLang)
-		s.SetHtml(translated)
+	if *exportTMX != "" {
+		cfg.ExportMemory = newTranslationMemory(*exportTMX, *sourceLang, featureLang)
+	}
 
-		// Add a small delay to avoid hitting rate limits too quickly
-		time.Sleep(200 * time.Millisecond)
-	})
+	if *promptDir != "" {
+		prompt, path, err := loadLanguagePrompt(*promptDir, targetLang)
+		if err != nil {
+			log.Fatalf("Error loading prompt: %v", err)
+		}
+		if path != "" {
+			log.Printf("Using prompt %s", path)
+		} else {
+			log.Printf("No prompt for %s in %s, using the default prompt", targetLang, *promptDir)
+		}
+		cfg.LanguagePrompt = prompt
+	}
 
-	htmlStr, err := doc.Html()
-	if err != nil {
-		return err
+	if *reportPath != "" {
+		cfg.Report = newReport(*reportPath)
 	}
 
-	_, err = io.WriteString(w, htmlStr)
-	return err
-}
+	// os.Exit skips deferred calls, so what has to be closed or removed
+	// however the run ends, such as the audit log and the directory of
+	// inputs given as URL, is released by cleanup, which exit calls first
+	var audit *AuditLog
+	var ref *referenceEpub
+	downloads := ""
+	cleanup := func() {
+		if audit != nil {
+			audit.Close()
+		}
+		if ref != nil {
+			ref.Close()
+		}
+		if downloads != "" {
+			os.RemoveAll(downloads)
+		}
+	}
+	defer cleanup()
+	exit := func(code int) {
+		cleanup()
+		os.Exit(code)
+	}
 
-func translateNode(htmlContent, key, url, model string, targetLang string) string {
-	maxRetries := 5
+	if *auditPath != "" {
+		var err error
+		if audit, err = openAuditLog(*auditPath, *auditContent); err != nil {
+			log.Fatal(err)
+		}
+		cfg.Audit = audit
+	}
 
-	// Start delay for retries (will increase exponentially)
-	retryDelay := 5 * time.Second
+	if *referencePath != "" {
+		var err error
+		if ref, err = openReference(*referencePath, targetLang); err != nil {
+			log.Printf("Error loading reference: %v", err)
+			exit(1)
+		}
+		cfg.Reference = ref
+	}
 
-	systemPrompt := fmt.Sprintf("You are a professional translator. Translate to %s. Keep all HTML tags exactly as they are. Output ONLY the translated content.", targetLang)
-	payload := map[string]interface{}{
-		"model": model,
-		"messages": []map[string]string{
-			{"role": "system", "content": systemPrompt},
-			{"role": "user", "content": htmlContent},
-		},
+	if *compare != "" {
+		if cfg.Cache == nil {
+			log.Print("-compare needs the -cache to compare with")
+			exit(1)
+		}
+		differ, err := compareEpub(inputPath, *compare, cfg, os.Stdout)
+		if err != nil {
+			log.Printf("Error comparing: %v", err)
+			exit(exitCode(err))
+		}
+		if differ > 0 {
+			exit(1)
+		}
+		return
 	}
-	body, _ := json.Marshal(payload)
 
-	for i := 0; i <= maxRetries; i++ {
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if *healthCheck && cfg.Provider != providerIdentity && !cfg.MarkPlaceholders {
+		if err := checkHealth(cfg); err != nil {
+			log.Printf("Health check failed: %v", err)
+			exit(exitCode(err))
+		}
+	}
 
+	if *preview != "" {
+		path, err := previewChapter(inputPath, *preview, cfg)
 		if err != nil {
-			log.Printf("  -> Error creating request: %v", err)
-			return htmlContent
+			log.Printf("Error creating preview: %v", err)
+			exit(exitCode(err))
 		}
+		fmt.Printf("Preview written to %s\n", path)
+		return
+	}
 
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+key)
+	if *retryReport != "" {
+		remaining, err := retryFromReport(*retryReport, cfg)
+		if err != nil {
+			log.Printf("Error retrying report: %v", err)
+			exit(exitCode(err))
+		}
+		if remaining > 0 {
+			log.Printf("%d blocks still could not be translated", remaining)
+			exit(exitCode(ErrIncomplete))
+		}
+		fmt.Println("All failed blocks repaired")
+		return
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		log.Printf("Error creating output directory: %v", err)
+		exit(1)
+	}
 
-		if err == nil && resp.StatusCode == http.StatusOK {
-			defer resp.Body.Close()
-			respBody, _ := io.ReadAll(resp.Body)
-			var openAIResp OpenAIResponse
-			if err := json.Unmarshal(respBody, &openAIResp); err == nil && len(openAIResp.Choices) > 0 {
-				return strings.TrimSpace(openAIResp.Choices[0].Message.Content)
-			}
+	if *watch {
+		if info, err := os.Stat(inputPath); err != nil || !info.IsDir() {
+			log.Print("-watch requires the input to be a directory")
+			exit(1)
 		}
+		if err := watchDir(inputPath, *outDir, cfg, 10*time.Second); err != nil {
+			log.Printf("Stopped watching: %v", err)
+			exit(exitCode(err))
+		}
+		return
+	}
 
-		if i < maxRetries {
-			statusInfo := "network error"
-			if resp != nil {
-				statusInfo = fmt.Sprintf("status %d", resp.StatusCode)
-				respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-				if len(respBody) > 0 {
-					statusInfo += " - " + string(respBody)
+	var inputs []string
+	for _, arg := range flag.Args() {
+		if isURL(arg) {
+			if downloads == "" {
+				dir, err := os.MkdirTemp("", "epub-translator-")
+				if err != nil {
+					log.Printf("Error creating download directory: %v", err)
+					exit(1)
 				}
-				resp.Body.Close()
+				downloads = dir
+			}
+			path, err := downloadEpub(arg, downloads, cfg)
+			if err != nil {
+				log.Printf("Error downloading input: %v", err)
+				exit(exitCode(err))
 			}
+			inputs = append(inputs, path)
+			continue
+		}
 
-			log.Printf("  -> Translation failed (%s). Retry %d/%d in %v...", statusInfo, i+1, maxRetries, retryDelay)
-			time.Sleep(retryDelay)
+		resolved, err := resolveInputs(arg)
+		if err != nil {
+			log.Printf("Error resolving input: %v", err)
+			exit(1)
+		}
+		inputs = append(inputs, resolved...)
+	}
 
-			if resp != nil && resp.StatusCode == 429 {
-				retryDelay *= 3
+	if cfg.Reference != nil && len(inputs) > 1 {
+		log.Print("-reference can only be used with a single input EPUB")
+		exit(1)
+	}
+
+	if *confirm {
+		limits := confirmLimits{Requests: *confirmRequests, Cost: *confirmCost, PricePerMTok: *pricePerMTok}
+		if err := confirmExpensiveRun(inputs, cfg, limits, *yes, os.Stdin, os.Stderr, isTerminal(os.Stdin)); err != nil {
+			log.Print(err)
+			exit(exitCode(err))
+		}
+	}
+
+	if len(inputs) == 1 {
+		outputPath := outputPathFor(inputs[0], *outDir, cfg)
+		if err := processEpub(inputs[0], outputPath, cfg); err != nil {
+			if errors.Is(err, ErrIncomplete) {
+				log.Printf("Warning: %v", err)
+				fmt.Printf("Translated EPUB written to %s, but some blocks could not be translated\n", outputPath)
 			} else {
-				retryDelay *= 2
+				log.Printf("Error processing epub: %v", err)
 			}
-			continue
+			exit(exitCode(err))
 		}
+
+		if cfg.MarkPlaceholders {
+			fmt.Printf("Marked the blocks to translate in %s, fill them in with -fill-placeholders\n", outputPath)
+			return
+		}
+		fmt.Printf("Successfully translated EPUB to %s\n", outputPath)
+		return
 	}
 
-	// Final fallback if all retries failed
-	log.Printf("All retries failed for a block. Keeping original text.")
+	// Books of a series repeat a lot (names, headers, recurring phrases), so
+	// share translations between them even without a persistent cache
+	if cfg.Cache == nil {
+		cfg.Cache = newMemoryCache()
+	}
 
-	return htmlContent + " <span style='color: gray; font-size: 0.8em;'>(⚠️ Translation failed)</span>"
+	if failed := runBatch(inputs, *outDir, cfg); failed > 0 {
+		if err := cfg.Abort.get(); err != nil {
+			exit(exitCode(err))
+		}
+		exit(1)
+	}
+}
+
+// outputPathFor derives the output file name for an input EPUB. With
+// -name-from-title, the translated title takes the place of the input's name.
+func outputPathFor(inputPath, outDir string, cfg *Config) string {
+	timestamp := time.Now().Format("20060102-1504")
+	inputFilename := filepath.Base(inputPath)
+	if cfg.NameFromTitle {
+		if slug := titleSlug(inputPath, cfg); slug != "" {
+			inputFilename = slug + ".epub"
+		}
+	}
+	return filepath.Join(outDir, fmt.Sprintf("translated-%s-%s", timestamp, inputFilename))
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// mediaElements carry structure the model must not touch: source
+// references, tracks and the cases of an epub:switch. The XHTML is parsed as
+// HTML, so namespaced elements keep their prefix in the name.
+var mediaElements = map[string]bool{"audio": true, "video": true, "epub:switch": true}
+
+// mediaPlaceholderAttr marks the stand-ins for media elements while their
+// surrounding block is translated.
+const mediaPlaceholderAttr = "data-epub-translator-media"
+
+func containsMedia(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (mediaElements[c.Data] || containsMedia(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSelected(n *html.Node, selected map[*html.Node]bool) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if selected[c] || containsSelected(c, selected) {
+			return true
+		}
+	}
+	return false
+}
+
+// translateMediaFallbacks translates the human-readable fallback of <audio>
+// and <video> elements and of epub:default, i.e. everything but the <source>
+// and <track> children. Fallbacks that contain regular blocks are left to the
+// normal selection.
+func translateMediaFallbacks(doc *goquery.Document, selected map[*html.Node]bool, cfg *Config) []blockFailure {
+	var failures []blockFailure
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data == "epub:case" || isNoTranslate(c) {
+				continue
+			}
+			if (c.Data == "audio" || c.Data == "video" || c.Data == "epub:default") && !containsSelected(c, selected) {
+				if f := translateFallback(c, cfg); f != nil {
+					failures = append(failures, *f)
+				}
+			}
+			walk(c)
+		}
+	}
+	walk(doc.Get(0))
+
+	return failures
+}
+
+func translateFallback(media *html.Node, cfg *Config) *blockFailure {
+	var structural, fallback []*html.Node
+	for c := media.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.Data == "source" || c.Data == "track" || mediaElements[c.Data]) {
+			structural = append(structural, c)
+		} else {
+			fallback = append(fallback, c)
+		}
+	}
+
+	var b strings.Builder
+	for _, c := range fallback {
+		if err := html.Render(&b, c); err != nil {
+			return nil
+		}
+	}
+	if strings.TrimSpace(textOf(fallback)) == "" {
+		return nil
+	}
+
+	translated, err := translateNode(encodeNbsp(b.String()), "This is the fallback text shown when the reading system can't play the media.", cfg)
+	if err != nil {
+		return &blockFailure{Path: nodePath(media), Err: err}
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(translated), media)
+	if err != nil {
+		return &blockFailure{Path: nodePath(media), Err: err}
+	}
+
+	// Sources and tracks first, in their original order, then the fallback
+	for _, c := range fallback {
+		media.RemoveChild(c)
+	}
+	for _, c := range structural {
+		media.RemoveChild(c)
+		media.AppendChild(c)
+	}
+	for _, c := range nodes {
+		media.AppendChild(c)
+	}
+	return nil
+}
+
+func textOf(nodes []*html.Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(goquery.NewDocumentFromNode(n).Text())
+	}
+	return b.String()
+}
+
+// protectMedia swaps the media elements inside n for empty placeholder
+// images, which models keep in place like any other tag. It returns the
+// originals in placeholder order.
+func protectMedia(n *html.Node) []*html.Node {
+	return protectElements(n, mediaPlaceholderAttr, func(c *html.Node) bool { return mediaElements[c.Data] })
+}
+
+// restoreMedia puts the originals back in place of their placeholders. Every
+// placeholder has to be present exactly once.
+func restoreMedia(n *html.Node, media []*html.Node) error {
+	if err := restoreElements(n, mediaPlaceholderAttr, media); err != nil {
+		return fmt.Errorf("media %w", err)
+	}
+	return nil
+}
+
+// protectElements swaps the elements inside n that match for placeholder
+// images carrying attr, see protectMedia.
+func protectElements(n *html.Node, attr string, match func(*html.Node) bool) []*html.Node {
+	var originals []*html.Node
+
+	var walk func(p *html.Node)
+	walk = func(p *html.Node) {
+		for c := p.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if !match(c) {
+				walk(c)
+				continue
+			}
+
+			placeholder := &html.Node{
+				Type: html.ElementNode,
+				Data: "img",
+				Attr: []html.Attribute{{Key: attr, Val: strconv.Itoa(len(originals))}},
+			}
+			p.InsertBefore(placeholder, c)
+			p.RemoveChild(c)
+			originals = append(originals, c)
+			c = placeholder
+		}
+	}
+	walk(n)
+
+	return originals
+}
+
+// restoreElements is the inverse of protectElements.
+func restoreElements(n *html.Node, attr string, originals []*html.Node) error {
+	found := make(map[int]*html.Node)
+	count := 0
+
+	var walk func(p *html.Node)
+	walk = func(p *html.Node) {
+		for c := p.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			for _, a := range c.Attr {
+				if a.Key == attr {
+					count++
+					if i, err := strconv.Atoi(a.Val); err == nil {
+						found[i] = c
+					}
+				}
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+
+	if count != len(originals) {
+		return fmt.Errorf("placeholders were changed by the model")
+	}
+	for i, o := range originals {
+		placeholder, ok := found[i]
+		if !ok {
+			return fmt.Errorf("placeholder %d is missing", i)
+		}
+		placeholder.Parent.InsertBefore(o, placeholder)
+		placeholder.Parent.RemoveChild(placeholder)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMediaFallbackText(t *testing.T) {
+	body := `<audio id="rec" src="../audio/a.mp3" controls="controls"><source src="../audio/a.ogg" type="audio/ogg"/><p>Your reader cannot play <a href="../audio/a.mp3">this recording</a>.</p></audio>` +
+		`<epub:switch id="eq"><epub:case required-namespace="http://www.w3.org/1998/Math/MathML"><math xmlns="http://www.w3.org/1998/Math/MathML"><mi>x</mi></math></epub:case><epub:default><p>The variable x.</p></epub:default></epub:switch>` +
+		`<p>Listen: <video src="../video/v.mp4" poster="../img/a.png">No video support.</video> and read on.</p>`
+	want := strings.NewReplacer(
+		"<p>Your", "<p>[T]Your",
+		"<p>The", "<p>[T]The",
+		"<p>Listen", "<p>[T]Listen",
+		">No video", ">[T]No video",
+	).Replace(body)
+
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(body), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapter := out[chapterName(1)]; !strings.Contains(chapter, "<body>"+want+"</body>") {
+		t.Errorf("got:\n%s\nwant the body\n%s", chapter, want)
+	}
+	if api.requested("<video") != 0 || api.requested("<mi>") != 0 {
+		t.Errorf("the media or the MathML case were sent to the model: %q", api.requests())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// memoryBudget bounds the bytes held in memory between translating a file and
+// writing it out. Workers block in acquire while the writer lags behind,
+// which is the backpressure that keeps large books from piling up in RAM.
+type memoryBudget struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int64
+	used     int64
+	isClosed bool
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes fit into the budget. A single reservation
+// larger than the whole budget is granted once nothing else is in flight.
+// It returns false if the budget was closed while waiting.
+func (b *memoryBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for !b.isClosed && b.limit > 0 && b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	if b.isClosed {
+		return false
+	}
+
+	b.used += n
+	return true
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= n
+	b.cond.Broadcast()
+}
+
+func (b *memoryBudget) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.isClosed = true
+	b.cond.Broadcast()
+}
+
+func (b *memoryBudget) closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.isClosed
+}
+
+// reservationFor estimates the memory a file occupies while it's translated:
+// the source plus the translated output, which is usually of similar size.
+func reservationFor(file *zip.File) int64 {
+	return 2 * int64(file.UncompressedSize64)
+}
+
+// parseByteSize parses sizes like "512MB", "2G" or "1048576".
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
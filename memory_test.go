@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingAllocator tracks the bytes reserved from a memoryBudget and the
+// most that were held at once.
+type countingAllocator struct {
+	mu         sync.Mutex
+	held, peak int64
+}
+
+func (a *countingAllocator) alloc(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.held += n
+	a.peak = max(a.peak, a.held)
+}
+
+func (a *countingAllocator) free(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.held -= n
+}
+
+func TestMemoryBudgetCap(t *testing.T) {
+	const limit = 1000
+	budget := newMemoryBudget(limit)
+	var alloc countingAllocator
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := int64(100 + 50*(i%7))
+			if !budget.acquire(n) {
+				t.Error("acquire failed on an open budget")
+				return
+			}
+			alloc.alloc(n)
+			time.Sleep(time.Millisecond)
+			alloc.free(n)
+			budget.release(n)
+		}()
+	}
+	wg.Wait()
+
+	if alloc.peak > limit {
+		t.Errorf("held %d bytes at once, the cap is %d", alloc.peak, limit)
+	}
+	if alloc.peak < 400 {
+		t.Errorf("held at most %d bytes at once, the reservations didn't overlap", alloc.peak)
+	}
+}
+
+func TestMemoryBudgetOversizedReservation(t *testing.T) {
+	budget := newMemoryBudget(100)
+	if !budget.acquire(500) {
+		t.Fatal("a reservation larger than the budget was refused with nothing in flight")
+	}
+
+	granted := make(chan bool)
+	go func() { granted <- budget.acquire(10) }()
+	select {
+	case <-granted:
+		t.Fatal("a reservation was granted over the cap")
+	case <-time.After(20 * time.Millisecond):
+	}
+	budget.release(500)
+	if !<-granted {
+		t.Error("the waiting reservation wasn't granted after the release")
+	}
+
+	budget.close()
+	if budget.acquire(1000) {
+		t.Error("acquire succeeded on a closed budget")
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"1048576", 1 << 20},
+		{"512MB", 512 << 20},
+		{"2G", 2 << 30},
+		{"64kb", 64 << 10},
+	}
+	for _, tt := range tests {
+		if got, err := parseByteSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "lots", "-5MB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestMaxMemoryRun(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.Concurrency = 4
+	cfg.MaxMemory = 1
+	out, err := translate(t, testBook(`<p>One.</p>`, `<p>Two.</p>`, `<p>Three.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if !strings.Contains(out[chapterName(i)], "[T]") {
+			t.Errorf("chapter %d is not translated:\n%s", i, out[chapterName(i)])
+		}
+	}
+}
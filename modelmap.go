@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// modelRule picks the model for the files matching pattern, a path.Match
+// pattern for the file name (or the entry name if it contains a slash), or
+// for "type=NAME" files whose <body> or a <section> has that epub:type.
+type modelRule struct {
+	pattern  string
+	epubType string
+	model    string
+}
+
+// parseModelMap parses a -model-map value such as
+// "chapter*:strong-model,type=frontmatter:cheap-model,*:cheap-model".
+// Model names may contain colons, patterns can't.
+func parseModelMap(s string) ([]modelRule, error) {
+	var rules []modelRule
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, model, ok := strings.Cut(entry, ":")
+		pattern, model = strings.TrimSpace(pattern), strings.TrimSpace(model)
+		if !ok || pattern == "" || model == "" {
+			return nil, fmt.Errorf("invalid -model-map entry %q, expected pattern:model", entry)
+		}
+
+		rule := modelRule{model: model}
+		if t, isType := strings.CutPrefix(pattern, "type="); isType {
+			rule.epubType = t
+		} else if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid -model-map pattern %q: %w", pattern, err)
+		} else {
+			rule.pattern = pattern
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// modelFor returns the model of the first rule matching the file, or "" if
+// none does.
+func modelFor(rules []modelRule, name string, source []byte) string {
+	var types map[string]bool
+	for _, r := range rules {
+		if r.epubType == "" {
+			target := path.Base(name)
+			if strings.Contains(r.pattern, "/") {
+				target = name
+			}
+			if ok, _ := path.Match(r.pattern, target); ok {
+				return r.model
+			}
+			continue
+		}
+
+		if types == nil {
+			types = documentTypes(source)
+		}
+		if types[r.epubType] {
+			return r.model
+		}
+	}
+	return ""
+}
+
+// documentTypes collects the epub:type values of the <body> and the
+// <section> elements of an (X)HTML file.
+func documentTypes(source []byte) map[string]bool {
+	types := make(map[string]bool)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(source))
+	if err != nil {
+		return types
+	}
+	doc.Find("body, section").Each(func(i int, s *goquery.Selection) {
+		for _, t := range strings.Fields(s.AttrOr("epub:type", "")) {
+			types[t] = true
+		}
+	})
+	return types
+}
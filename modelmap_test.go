@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModelMap(t *testing.T) {
+	rules, err := parseModelMap("ch1*:strong-model, type=frontmatter:vendor:cheap-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.ModelMap = rules
+	if _, err := translate(t, testBook(`<p>Main text.</p>`, `<section epub:type="frontmatter"><p>Front text.</p></section>`, `<p>Other text.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for text, want := range map[string]string{
+		"Main text.":  "strong-model",
+		"Front text.": "vendor:cheap-model",
+		"Other text.": "test-model",
+	} {
+		if api.requested(text) != 1 {
+			t.Errorf("%s was requested %d times", text, api.requested(text))
+		}
+		for i, c := range api.requests() {
+			if strings.Contains(c, text) {
+				if got := api.payload(i)["model"]; got != want {
+					t.Errorf("%s was translated with %v, want %s", text, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestParseModelMapErrors(t *testing.T) {
+	for _, s := range []string{"chapter*", ":model", "[:model"} {
+		if _, err := parseModelMap(s); err == nil {
+			t.Errorf("no error for %q", s)
+		}
+	}
+}
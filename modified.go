@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// modifiedFormat is the form EPUB 3 requires of dcterms:modified.
+const modifiedFormat = "2006-01-02T15:04:05Z"
+
+var (
+	metadataEndPattern = regexp.MustCompile(`</(?:\w+:)?metadata\s*>`)
+
+	// modifiedMetaPattern matches a dcterms:modified meta, as its start tag,
+	// content and end tag.
+	modifiedMetaPattern = regexp.MustCompile(`(?s)(<(?:\w+:)?meta\b[^>]*\bproperty\s*=\s*["']dcterms:modified["'][^>]*>)(.*?)(</(?:\w+:)?meta\s*>)`)
+
+	// modificationDatePattern matches the EPUB 2 modification date,
+	// <dc:date opf:event="modification">.
+	modificationDatePattern = regexp.MustCompile(`(?s)(<(?:\w+:)?date\b[^>]*\bevent\s*=\s*["']modification["'][^>]*>)(.*?)(</(?:\w+:)?date\s*>)`)
+)
+
+// modificationTime is the date the output records as its last modification:
+// now, or the fixed time of -reproducible output.
+func modificationTime(cfg *Config) time.Time {
+	if cfg.Reproducible {
+		return reproducibleTime()
+	}
+	return time.Now().UTC()
+}
+
+// touchesPackage reports whether touchModified changes the entry name.
+func touchesPackage(name string, pkg *epubPackage, cfg *Config) bool {
+	return pkg != nil && name == pkg.Path && (cfg.UpdateModified || cfg.UpdateDate)
+}
+
+// touchModified sets the modification date in the OPF data to t. With
+// -update-modified that's the dcterms:modified meta, which is added to EPUB 3
+// packages that lack one; with -update-date, also an EPUB 2
+// <dc:date opf:event="modification">. Other dates, such as the publication
+// date, are left alone.
+func touchModified(data []byte, version string, t time.Time, cfg *Config) []byte {
+	stamp := t.UTC().Format(modifiedFormat)
+	if cfg.UpdateModified {
+		replaced := false
+		data = replaceContent(data, modifiedMetaPattern, func(start []byte) bool {
+			// A meta refining another property isn't the package's date
+			if replaced || bytes.Contains(start, []byte("refines")) {
+				return false
+			}
+			replaced = true
+			return true
+		}, stamp)
+		if !replaced && strings.HasPrefix(version, "3") {
+			data = insertBefore(data, metadataEndPattern, `<meta property="dcterms:modified">`+stamp+"</meta>\n")
+		}
+	}
+	if cfg.UpdateDate {
+		data = replaceContent(data, modificationDatePattern, func([]byte) bool { return true }, stamp)
+	}
+	return data
+}
+
+// replaceContent replaces the content of the elements matched by pattern, a
+// start tag, content and end tag, with s where replace accepts the start tag.
+func replaceContent(data []byte, pattern *regexp.Regexp, replace func(start []byte) bool, s string) []byte {
+	return pattern.ReplaceAllFunc(data, func(m []byte) []byte {
+		parts := pattern.FindSubmatch(m)
+		if !replace(parts[1]) {
+			return m
+		}
+		out := append([]byte{}, parts[1]...)
+		out = append(out, s...)
+		return append(out, parts[3]...)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+var modifiedMeta = regexp.MustCompile(`<meta property="dcterms:modified">([^<]*)</meta>`)
+
+func TestModifiedDateIsRefreshed(t *testing.T) {
+	api := newStubAPI(t, nil)
+	start := time.Now().UTC().Truncate(time.Second)
+	out, err := translate(t, testBook(`<p>Text.</p>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opf := out["OEBPS/content.opf"]
+	m := modifiedMeta.FindAllStringSubmatch(opf, -1)
+	if len(m) != 1 {
+		t.Fatalf("got %d dcterms:modified metas, want 1:\n%s", len(m), opf)
+	}
+	modified, err := time.Parse(modifiedFormat, m[0][1])
+	if err != nil {
+		t.Fatalf("dcterms:modified %q isn't CCYY-MM-DDThh:mm:ssZ: %v", m[0][1], err)
+	}
+	if modified.Before(start) || modified.After(time.Now().UTC()) {
+		t.Errorf("dcterms:modified is %v, not the time of the run", modified)
+	}
+}
+
+func TestTouchModified(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("CEST", 2*60*60))
+	const stamp = "2024-05-06T05:08:09Z"
+	tests := []struct {
+		name, version, in, want string
+		modified, date          bool
+	}{
+		{"replaced", "3.0",
+			`<metadata><meta property="dcterms:modified">2020-01-01T00:00:00Z</meta></metadata>`,
+			`<metadata><meta property="dcterms:modified">` + stamp + `</meta></metadata>`, true, false},
+		{"added to EPUB 3", "3.0",
+			`<opf:metadata><dc:title>T</dc:title></opf:metadata>`,
+			`<opf:metadata><dc:title>T</dc:title><meta property="dcterms:modified">` + stamp + "</meta>\n</opf:metadata>", true, false},
+		{"not added to EPUB 2", "2.0",
+			`<metadata><dc:title>T</dc:title></metadata>`,
+			`<metadata><dc:title>T</dc:title></metadata>`, true, false},
+		{"refining meta left alone", "3.0",
+			`<metadata><meta refines="#c" property="dcterms:modified">2019</meta><meta property="dcterms:modified">2020-01-01T00:00:00Z</meta></metadata>`,
+			`<metadata><meta refines="#c" property="dcterms:modified">2019</meta><meta property="dcterms:modified">` + stamp + `</meta></metadata>`, true, false},
+		{"-update-modified=false", "3.0",
+			`<metadata><meta property="dcterms:modified">2020-01-01T00:00:00Z</meta></metadata>`,
+			`<metadata><meta property="dcterms:modified">2020-01-01T00:00:00Z</meta></metadata>`, false, false},
+		{"EPUB 2 date", "2.0",
+			`<metadata><dc:date opf:event="publication">2001</dc:date><dc:date opf:event="modification">2010-01-01</dc:date></metadata>`,
+			`<metadata><dc:date opf:event="publication">2001</dc:date><dc:date opf:event="modification">` + stamp + `</dc:date></metadata>`, false, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{UpdateModified: tt.modified, UpdateDate: tt.date}
+		if got := string(touchModified([]byte(tt.in), tt.version, at, cfg)); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
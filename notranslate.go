@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// keepPlaceholderAttr marks the stand-ins for translate="no" elements while
+// their surrounding block is translated.
+const keepPlaceholderAttr = "data-epub-translator-keep"
+
+// isNoTranslate reports whether the author excluded n from translation with
+// the standard translate="no" attribute or with data-no-translate.
+func isNoTranslate(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, a := range n.Attr {
+		switch {
+		case a.Key == "translate" && strings.EqualFold(strings.TrimSpace(a.Val), "no"):
+			return true
+		case a.Key == "data-no-translate" && !strings.EqualFold(a.Val, "false"):
+			return true
+		}
+	}
+	return false
+}
+
+// insideNoTranslate reports whether n or one of its ancestors is excluded
+// from translation.
+func insideNoTranslate(n *html.Node) bool {
+	for p := n; p != nil; p = p.Parent {
+		if isNoTranslate(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNoTranslate(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isNoTranslate(c) || containsNoTranslate(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectNoTranslate swaps the excluded elements inside a block for
+// placeholders, like protectMedia, so they come back exactly as they were.
+func protectNoTranslate(n *html.Node) []*html.Node {
+	return protectElements(n, keepPlaceholderAttr, isNoTranslate)
+}
+
+func restoreNoTranslate(n *html.Node, kept []*html.Node) error {
+	return restoreElements(n, keepPlaceholderAttr, kept)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslateNo(t *testing.T) {
+	kept := []string{
+		`<div translate="no"><p>Keep this <em>exactly</em>.</p><h2 id="h">Also kept</h2></div>`,
+		`<p data-no-translate="">Data attribute.</p>`,
+		`<p translate="No ">Any case.</p>`,
+	}
+	api := newStubAPI(t, nil)
+	out, err := translate(t, testBook(strings.Join(kept, "")+
+		`<p>Brand <span translate="no" class="brand">Acme  Widgets</span> is great.</p>`+
+		`<p data-no-translate="false">Translated anyway.</p>`), testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range append(kept,
+		`<p>[T]Brand <span translate="no" class="brand">Acme  Widgets</span> is great.</p>`,
+		`<p data-no-translate="false">[T]Translated anyway.</p>`) {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	for _, text := range []string{"Keep this", "Also kept", "Data attribute.", "Any case.", "Acme"} {
+		if api.requested(text) != 0 {
+			t.Errorf("sent %q, which is marked not to be translated", text)
+		}
+	}
+	if strings.Contains(chapter, keepPlaceholderAttr) {
+		t.Errorf("a placeholder was left in the output:\n%s", chapter)
+	}
+}
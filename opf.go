@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+)
+
+type containerXML struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Version  string    `xml:"version,attr"`
+	Titles   []string  `xml:"metadata>title"`
+	Manifest []opfItem `xml:"manifest>item"`
+	Spine    []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// epubPackage holds the parts of the OPF we care about, with all hrefs
+// resolved to zip entry names.
+type epubPackage struct {
+	Path       string
+	Version    string             // of the package format, "3.0" for EPUB 3
+	Title      string             // the first dc:title
+	Manifest   map[string]opfItem // keyed by zip entry name
+	Spine      []string
+	SpineIndex map[string]int
+}
+
+// readPackage locates the OPF via META-INF/container.xml and parses its
+// manifest and spine.
+func readPackage(files []*zip.File) (*epubPackage, error) {
+	var container containerXML
+	if err := decodeZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return nil, fmt.Errorf("container.xml has no rootfile")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	var opf opfPackage
+	if err := decodeZipXML(files, opfPath, &opf); err != nil {
+		return nil, err
+	}
+
+	pkg := &epubPackage{
+		Path:       opfPath,
+		Version:    opf.Version,
+		Manifest:   make(map[string]opfItem),
+		SpineIndex: make(map[string]int),
+	}
+	if len(opf.Titles) > 0 {
+		pkg.Title = opf.Titles[0]
+	}
+
+	byID := make(map[string]string)
+	for _, item := range opf.Manifest {
+		name := resolveHref(opfPath, item.Href)
+		pkg.Manifest[name] = item
+		byID[item.ID] = name
+	}
+
+	for _, ref := range opf.Spine {
+		name, ok := byID[ref.IDRef]
+		if !ok {
+			continue
+		}
+		pkg.SpineIndex[name] = len(pkg.Spine)
+		pkg.Spine = append(pkg.Spine, name)
+	}
+
+	return pkg, nil
+}
+
+// resolveHref turns an href relative to the file at base into a zip entry name.
+func resolveHref(base, href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+func findZipFile(files []*zip.File, name string) *zip.File {
+	for _, f := range files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func decodeZipXML(files []*zip.File, name string, v interface{}) error {
+	f := findZipFile(files, name)
+	if f == nil {
+		return fmt.Errorf("%s not found", name)
+	}
+
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("could not parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// spineOrder returns files with the spine documents in reading order. They
+// take the positions spine documents had in the zip, so everything else
+// (container, OPF, styles) stays where it was; mimetype is moved to the
+// front as the EPUB spec requires.
+func spineOrder(files []*zip.File, pkg *epubPackage) []*zip.File {
+	var spineFiles []*zip.File
+	for _, name := range pkg.Spine {
+		if f := findZipFile(files, name); f != nil {
+			spineFiles = append(spineFiles, f)
+		}
+	}
+
+	ordered := make([]*zip.File, 0, len(files))
+	next := 0
+	for _, f := range mimetypeFirst(files) {
+		if _, inSpine := pkg.SpineIndex[f.Name]; inSpine {
+			ordered = append(ordered, spineFiles[next])
+			next++
+		} else {
+			ordered = append(ordered, f)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReorderBySpine(t *testing.T) {
+	book := testBook(`<p>One.</p>`, `<p>Two.</p>`, `<p>Three.</p>`)
+	// The zip has the chapters backwards and mimetype after them
+	var shuffled []zipEntry
+	for _, i := range []int{7, 6, 5, 0, 1, 2, 3, 4, 8} {
+		shuffled = append(shuffled, book[i])
+	}
+
+	for _, tt := range []struct {
+		reorder bool
+		want    []string
+	}{
+		{false, []string{chapterName(3), chapterName(2), chapterName(1), "mimetype"}},
+		{true, []string{"mimetype", chapterName(1), chapterName(2), chapterName(3)}},
+	} {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.ReorderBySpine = tt.reorder
+
+		dir := t.TempDir()
+		input := writeZip(t, dir, "book.epub", shuffled)
+		output := filepath.Join(dir, "out.epub")
+		if err := processEpub(input, output, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, name := range entryNames(t, output) {
+			if name == "mimetype" || slices.Contains(tt.want, name) {
+				got = append(got, name)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("reorder %v: got %q, want %q", tt.reorder, got, tt.want)
+		}
+		if names := entryNames(t, output); tt.reorder && names[0] != "mimetype" {
+			t.Errorf("mimetype isn't the first entry: %q", names)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// originalSectionLabel heads the part of the table of contents that lists
+// the original chapters.
+const originalSectionLabel = "Original"
+
+// originalEdition adds the untranslated chapters to the output
+// (-keep-original-file): every translated spine document gets an original
+// copy next to it, listed after the translated ones in the spine and in a
+// table-of-contents section of its own.
+type originalEdition struct {
+	pkg   *epubPackage
+	files []*zip.File
+
+	// copies maps source entry names to the names of their original copies,
+	// in spine order; links is copies plus the other renames of the run, for
+	// rewriting the links of the copies.
+	copies map[string]string
+	order  []string
+	links  map[string]string
+}
+
+// originalName is the entry name of the original copy of the file written
+// as name.
+func originalName(name string) string {
+	return path.Join(path.Dir(name), "original-"+path.Base(name))
+}
+
+// newOriginalEdition plans the copies of the spine documents for which
+// translated reports true. renames are the output names of renamed files.
+func newOriginalEdition(files []*zip.File, pkg *epubPackage, translated func(string) bool, renames map[string]string) *originalEdition {
+	e := &originalEdition{pkg: pkg, files: files, copies: make(map[string]string), links: make(map[string]string)}
+	for from, to := range renames {
+		e.links[from] = to
+	}
+
+	taken := make(map[string]bool, len(files))
+	for _, f := range files {
+		taken[f.Name] = true
+	}
+	for _, name := range pkg.Spine {
+		if !isTranslatable(name) || isNavDocument(name, pkg) || !translated(name) {
+			continue
+		}
+		out := name
+		if r, ok := renames[name]; ok {
+			out = r
+		}
+		c := originalName(out)
+		if taken[c] {
+			continue
+		}
+		e.copies[name] = c
+		e.order = append(e.order, name)
+		e.links[name] = c
+	}
+	return e
+}
+
+// copyOf returns the name of the original copy of name, if it gets one.
+func (e *originalEdition) copyOf(name string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	c, ok := e.copies[name]
+	return c, ok
+}
+
+// original is the content of the copy of file: its source, linking to the
+// other copies.
+func (e *originalEdition) original(file *zip.File) ([]byte, error) {
+	data, err := readZipFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	return rewriteLinks(file.Name, data, e.links), nil
+}
+
+// edits reports whether edit changes the entry name.
+func (e *originalEdition) edits(name string) bool {
+	if e == nil || len(e.copies) == 0 {
+		return false
+	}
+	return name == e.pkg.Path || isNavDocument(name, e.pkg) || isNCX(name) && e.pkg.Manifest[name].ID != ""
+}
+
+// edit adds the copies to the OPF, the navigation document or the NCX,
+// whose output content is data. Other entries are returned unchanged.
+func (e *originalEdition) edit(name string, data []byte) ([]byte, error) {
+	if !e.edits(name) {
+		return data, nil
+	}
+	switch {
+	case name == e.pkg.Path:
+		return e.editOPF(data), nil
+	case isNCX(name):
+		return e.editNCX(name, data)
+	default:
+		return e.editNav(name, data)
+	}
+}
+
+var (
+	manifestEndPattern = regexp.MustCompile(`</(?:\w+:)?manifest\s*>`)
+	spineEndPattern    = regexp.MustCompile(`</(?:\w+:)?spine\s*>`)
+)
+
+// editOPF adds manifest items for the copies, with the media type and
+// properties of their translated counterpart, and appends them to the spine.
+func (e *originalEdition) editOPF(data []byte) []byte {
+	var items, refs strings.Builder
+	for _, name := range e.order {
+		item := e.pkg.Manifest[name]
+		id := "original-" + item.ID
+		href := escapePath(relativePath(path.Dir(e.pkg.Path), e.copies[name]))
+		fmt.Fprintf(&items, `<item id="%s" href="%s" media-type="%s"`, html.EscapeString(id), html.EscapeString(href), html.EscapeString(item.MediaType))
+		if item.Properties != "" {
+			fmt.Fprintf(&items, ` properties="%s"`, html.EscapeString(item.Properties))
+		}
+		items.WriteString("/>\n")
+		fmt.Fprintf(&refs, "<itemref idref=\"%s\"/>\n", html.EscapeString(id))
+	}
+
+	data = insertBefore(data, manifestEndPattern, items.String())
+	return insertBefore(data, spineEndPattern, refs.String())
+}
+
+// insertBefore inserts s in front of the last match of pattern.
+func insertBefore(data []byte, pattern *regexp.Regexp, s string) []byte {
+	matches := pattern.FindAllIndex(data, -1)
+	if len(matches) == 0 {
+		return data
+	}
+	at := matches[len(matches)-1][0]
+	return append(append(append([]byte{}, data[:at]...), s...), data[at:]...)
+}
+
+// editNav appends a section to the toc <nav> that repeats the entries of the
+// untranslated navigation document, pointing to the copies.
+func (e *originalEdition) editNav(name string, data []byte) ([]byte, error) {
+	source := findZipFile(e.files, name)
+	if source == nil {
+		return data, nil
+	}
+	original, err := readZipFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	originalDoc, err := goquery.NewDocumentFromReader(bytes.NewReader(stripBOMBytes(original)))
+	if err != nil {
+		return nil, err
+	}
+	body, hadBOM := stripBOM(data)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	list := tocList(doc)
+	entries := tocList(originalDoc).Children().Clone()
+	if list.Length() == 0 || entries.Length() == 0 {
+		return data, nil
+	}
+	entries.Find("[href]").Each(func(i int, s *goquery.Selection) {
+		s.SetAttr("href", renamedLink(name, s.AttrOr("href", ""), e.links))
+	})
+	entries.Find("[id]").AddSelection(entries.Filter("[id]")).Each(func(i int, s *goquery.Selection) {
+		s.SetAttr("id", "original-"+s.AttrOr("id", ""))
+	})
+
+	first := escapePath(relativePath(path.Dir(name), e.copies[e.order[0]]))
+	section := fmt.Sprintf(`<li><a href="%s">%s</a><ol></ol></li>`, html.EscapeString(first), originalSectionLabel)
+	list.AppendHtml(section)
+	list.Children().Last().Find("ol").AppendSelection(entries)
+
+	out, err := renderDocument(doc, body)
+	if err != nil {
+		return nil, err
+	}
+	return restoreBOM([]byte(out), hadBOM, bomPreserve), nil
+}
+
+// tocList is the top-level list of the toc <nav> of a navigation document.
+func tocList(doc *goquery.Document) *goquery.Selection {
+	return doc.Find("nav").FilterFunction(func(i int, s *goquery.Selection) bool {
+		for _, t := range strings.Fields(s.AttrOr("epub:type", "")) {
+			if t == "toc" {
+				return true
+			}
+		}
+		return false
+	}).First().ChildrenFiltered("ol")
+}
+
+func stripBOMBytes(data []byte) []byte {
+	data, _ = stripBOM(data)
+	return data
+}
+
+var (
+	navMapPattern    = regexp.MustCompile(`(?s)<navMap[^>]*>(.*)</navMap\s*>`)
+	navMapEndPattern = regexp.MustCompile(`</navMap\s*>`)
+	idAttrPattern    = regexp.MustCompile(`\bid\s*=\s*"([^"]*)"`)
+	playOrderPattern = regexp.MustCompile(`\bplayOrder\s*=\s*"(\d+)"`)
+)
+
+// editNCX appends a navPoint to the NCX of data that holds the navPoints of
+// the untranslated NCX, pointing to the copies and numbered after the
+// existing ones.
+func (e *originalEdition) editNCX(name string, data []byte) ([]byte, error) {
+	source := findZipFile(e.files, name)
+	if source == nil {
+		return data, nil
+	}
+	original, err := readZipFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	m := navMapPattern.FindSubmatch(original)
+	if m == nil {
+		return data, nil
+	}
+
+	last := 0
+	for _, po := range playOrderPattern.FindAllSubmatch(data, -1) {
+		if n, err := strconv.Atoi(string(po[1])); err == nil && n > last {
+			last = n
+		}
+	}
+
+	points := rewriteLinks(name, m[1], e.links)
+	points = idAttrPattern.ReplaceAll(points, []byte(`id="original-$1"`))
+
+	// navPoints with the same target must have the same playOrder, so the
+	// section shares it with the entry that links to the first copy, if
+	// there is one, and otherwise comes before the entries
+	first := escapePath(relativePath(path.Dir(name), e.copies[e.order[0]]))
+	offset, order := last+1, last+1
+	if n, ok := ncxPlayOrders(points)[first]; ok {
+		offset, order = last, last+n
+	}
+	points = playOrderPattern.ReplaceAllFunc(points, func(attr []byte) []byte {
+		n, _ := strconv.Atoi(string(playOrderPattern.FindSubmatch(attr)[1]))
+		return []byte(fmt.Sprintf(`playOrder="%d"`, offset+n))
+	})
+
+	section := fmt.Sprintf(`<navPoint id="original-edition" playOrder="%d"><navLabel><text>%s</text></navLabel><content src="%s"/>%s</navPoint>`,
+		order, originalSectionLabel, html.EscapeString(first), points)
+	return insertBefore(data, navMapEndPattern, section), nil
+}
+
+// ncxPlayOrders maps the content targets of the navPoints in points, a part
+// of a navMap, to their playOrder.
+func ncxPlayOrders(points []byte) map[string]int {
+	orders := make(map[string]int)
+	dec := xml.NewDecoder(bytes.NewReader(append(append([]byte("<navMap>"), points...), "</navMap>"...)))
+	dec.Strict = false
+	var stack []int
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return orders
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "navPoint":
+				n, _ := strconv.Atoi(xmlAttr(t, "playOrder"))
+				stack = append(stack, n)
+			case "content":
+				if len(stack) > 0 {
+					if _, ok := orders[xmlAttr(t, "src")]; !ok {
+						orders[xmlAttr(t, "src")] = stack[len(stack)-1]
+					}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "navPoint" && len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKeepOriginalFile(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.KeepOriginalFile = true
+	out, err := translate(t, testBook(`<p>First chapter.</p>`, `<p>Second chapter.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opf := out["OEBPS/content.opf"]
+	spine := regexp.MustCompile(`idref="([^"]*)"`).FindAllStringSubmatch(opf, -1)
+	var refs []string
+	for _, m := range spine {
+		refs = append(refs, m[1])
+	}
+	if got, want := strings.Join(refs, " "), "c1 c2 original-c1 original-c2"; got != want {
+		t.Errorf("got spine %s, want %s", got, want)
+	}
+	for _, want := range []string{`<item id="original-c1" href="text/original-ch1.xhtml" media-type="application/xhtml+xml"/>`, `<item id="original-c2" href="text/original-ch2.xhtml"`} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("manifest lacks %s:\n%s", want, opf)
+		}
+	}
+
+	if !strings.Contains(out[chapterName(1)], "[T]First chapter.") {
+		t.Errorf("chapter not translated:\n%s", out[chapterName(1)])
+	}
+	original := out["OEBPS/text/original-ch1.xhtml"]
+	if !strings.Contains(original, "<p>First chapter.</p>") || strings.Contains(original, "[T]") {
+		t.Errorf("original copy is not the original:\n%s", original)
+	}
+
+	nav := out["OEBPS/nav.xhtml"]
+	for _, want := range []string{`<a href="text/ch1.xhtml">`, `<a href="text/original-ch1.xhtml">Original</a>`, `<a href="text/original-ch2.xhtml">Chapter 2</a>`} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("nav lacks %s:\n%s", want, nav)
+		}
+	}
+	checkPlayOrder(t, out["OEBPS/toc.ncx"])
+}
+
+// checkPlayOrder checks that the navPoints of an NCX have the same playOrder
+// if and only if they link to the same target.
+func checkPlayOrder(t *testing.T, ncx string) {
+	t.Helper()
+	type point struct {
+		order int
+		src   string
+	}
+	var points []*point
+	var stack []*point
+	dec := xml.NewDecoder(strings.NewReader(ncx))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "navPoint":
+				n, _ := strconv.Atoi(xmlAttr(tok, "playOrder"))
+				p := &point{order: n}
+				points = append(points, p)
+				stack = append(stack, p)
+			case "content":
+				stack[len(stack)-1].src = xmlAttr(tok, "src")
+			}
+		case xml.EndElement:
+			if tok.Name.Local == "navPoint" {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	bySrc, byOrder := make(map[string]int), make(map[int]string)
+	for _, p := range points {
+		if n, ok := bySrc[p.src]; ok && n != p.order {
+			t.Errorf("%s has playOrder %d and %d:\n%s", p.src, n, p.order, ncx)
+		}
+		if src, ok := byOrder[p.order]; ok && src != p.src {
+			t.Errorf("playOrder %d is used for %s and %s:\n%s", p.order, src, p.src, ncx)
+		}
+		bySrc[p.src], byOrder[p.order] = p.order, p.src
+	}
+	if len(bySrc) != 4 {
+		t.Errorf("got %d targets in the NCX, want 4:\n%s", len(bySrc), ncx)
+	}
+}
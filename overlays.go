@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// isMediaOverlay reports whether name is a caption or media overlay file
+// handled by -translate-media-overlays.
+func isMediaOverlay(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".vtt" || ext == ".smil"
+}
+
+// translateOverlay translates the text of a .vtt or .smil file, leaving
+// timings, ids and src references as they are.
+func translateOverlay(name string, source []byte, cfg *Config) ([]byte, []blockFailure, error) {
+	if strings.EqualFold(filepath.Ext(name), ".vtt") {
+		data, failures := translateVTT(source, cfg)
+		return data, failures, nil
+	}
+	return translateSMIL(source, cfg)
+}
+
+// translateVTT translates the cue text of a WebVTT file. Cues are the blocks
+// with a timing line ("00:01.000 --> 00:04.000"); the header, NOTE, STYLE and
+// REGION blocks, cue identifiers and timing lines are kept byte for byte,
+// including their line endings.
+func translateVTT(source []byte, cfg *Config) ([]byte, []blockFailure) {
+	var failures []blockFailure
+	var out bytes.Buffer
+
+	lines := strings.SplitAfter(string(source), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		out.WriteString(line)
+		if !strings.Contains(line, "-->") {
+			continue
+		}
+
+		// The cue text runs up to the next blank line
+		start := i + 1
+		end := start
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "" {
+			end++
+		}
+		if end == start {
+			continue
+		}
+
+		text, eol := joinCueLines(lines[start:end])
+		suffix := closingTags(text)
+		translated, err := translateNode(text+suffix, "This is the text of a subtitle cue. Keep the line breaks and the cue tags such as <v Name>, <i> or <c.class>.", cfg)
+		if err != nil {
+			failures = append(failures, blockFailure{Path: fmt.Sprintf("cue %d", cueNumber(lines[:i])), Err: err})
+			translated = text
+		}
+
+		translated = strings.ReplaceAll(strings.TrimSpace(translated), "\r\n", "\n")
+		translated = strings.TrimSuffix(translated, suffix)
+		out.WriteString(strings.ReplaceAll(translated, "\n", eol))
+		if strings.HasSuffix(lines[end-1], "\n") {
+			out.WriteString(eol)
+		}
+		i = end - 1
+	}
+
+	return out.Bytes(), failures
+}
+
+// closingTags returns the end tags of the spans left open in a cue. WebVTT
+// allows a <v> voice span covering the whole cue to stay unclosed, but the
+// fragment check doesn't, so they are closed for the request and removed
+// from the translation again.
+func closingTags(text string) string {
+	var open []string
+	z := html.NewTokenizer(strings.NewReader(text))
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		name, _ := z.TagName()
+		switch tt {
+		case html.StartTagToken:
+			open = append(open, string(name))
+		case html.EndTagToken:
+			if len(open) > 0 && open[len(open)-1] == string(name) {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// joinCueLines returns the text of a cue with "\n" line breaks and the line
+// ending the file uses.
+func joinCueLines(lines []string) (string, string) {
+	eol := "\n"
+	if strings.HasSuffix(lines[0], "\r\n") {
+		eol = "\r\n"
+	}
+	var text []string
+	for _, l := range lines {
+		text = append(text, strings.TrimRight(l, "\r\n"))
+	}
+	return strings.Join(text, "\n"), eol
+}
+
+// cueNumber is the 1-based number of the cue whose timing line follows
+// lines, for reports.
+func cueNumber(lines []string) int {
+	n := 1
+	for _, l := range lines {
+		if strings.Contains(l, "-->") {
+			n++
+		}
+	}
+	return n
+}
+
+// translateSMIL translates the text content of SMIL <text> elements. In
+// EPUB 3 they usually only point into the content document via src, and
+// then there is nothing to do.
+func translateSMIL(source []byte, cfg *Config) ([]byte, []blockFailure, error) {
+	return translateXMLText(source, func(path []string) bool {
+		return path[len(path)-1] == "text"
+	}, "This is the text of a media overlay (read-aloud) fragment. Output plain text only.", cfg)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testVTT = "WEBVTT\n\nNOTE Timings from the audiobook\n\nintro\n00:00:01.000 --> 00:00:04.000 align:start\n<v Narrator>Once upon a time\n\n00:00:04.500 --> 00:00:06.000\nThe end.\n"
+
+	testSMIL = `<?xml version="1.0" encoding="utf-8"?>
+<smil xmlns="http://www.w3.org/ns/SMIL" version="3.0"><body><seq id="s1"><par id="p1"><text src="../text/ch1.xhtml#w1">Once upon a time</text><audio src="../audio/ch1.mp3" clipBegin="0:00:01.000" clipEnd="0:00:04.000"/></par></seq></body></smil>`
+)
+
+func TestMediaOverlays(t *testing.T) {
+	book := testBook(`<p>Text.</p>`)
+	book = replaceEntry(book, "OEBPS/audio/ch1.vtt", testVTT)
+	book = replaceEntry(book, "OEBPS/smil/ch1.smil", testSMIL)
+
+	api := newStubAPI(t, nil)
+	out, err := translate(t, book, testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["OEBPS/audio/ch1.vtt"] != testVTT || out["OEBPS/smil/ch1.smil"] != testSMIL {
+		t.Errorf("overlays changed without -translate-media-overlays")
+	}
+
+	api = newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.TranslateMediaOverlays = true
+	out, err = translate(t, book, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantVTT := "WEBVTT\n\nNOTE Timings from the audiobook\n\nintro\n00:00:01.000 --> 00:00:04.000 align:start\n[T]<v Narrator>Once upon a time\n\n00:00:04.500 --> 00:00:06.000\n[T]The end.\n"
+	if got := out["OEBPS/audio/ch1.vtt"]; got != wantVTT {
+		t.Errorf("got VTT\n%q\nwant\n%q", got, wantVTT)
+	}
+	if api.requested("Timings") != 0 || api.requested("00:00") != 0 {
+		t.Errorf("sent the note or timings of the VTT: %q", api.requests())
+	}
+
+	smil := out["OEBPS/smil/ch1.smil"]
+	for _, want := range []string{
+		`<text src="../text/ch1.xhtml#w1">[T]Once upon a time</text>`,
+		`<audio src="../audio/ch1.mp3" clipBegin="0:00:01.000" clipEnd="0:00:04.000"/>`,
+		`<seq id="s1"><par id="p1">`,
+	} {
+		if !strings.Contains(smil, want) {
+			t.Errorf("SMIL lacks %s:\n%s", want, smil)
+		}
+	}
+}
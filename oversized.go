@@ -0,0 +1,25 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+)
+
+// Values of -max-file-size-action.
+const (
+	oversizedCopy  = "copy"
+	oversizedChunk = "chunk"
+)
+
+func validateOversizedAction(action string) error {
+	switch action {
+	case oversizedCopy, oversizedChunk:
+		return nil
+	}
+	return fmt.Errorf("unknown -max-file-size-action %q, expected copy or chunk", action)
+}
+
+// tooLarge reports whether file exceeds -max-file-size.
+func tooLarge(file *zip.File, cfg *Config) bool {
+	return cfg.MaxFileSize > 0 && int64(file.UncompressedSize64) > cfg.MaxFileSize
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMaxFileSize(t *testing.T) {
+	var big strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&big, "<p>Paragraph %d of the whole book in one file.</p>", i+1)
+	}
+	book := testBook(big.String(), `<p>Small text.</p>`)
+
+	for _, action := range []string{oversizedCopy, oversizedChunk} {
+		t.Run(action, func(t *testing.T) {
+			api := newStubAPI(t, batchReply)
+			cfg := testConfig(api.URL)
+			cfg.MaxFileSize = 1000
+			cfg.OversizedAction = action
+			out, err := translate(t, book, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(out[chapterName(2)], "<p>[T]Small text.</p>") {
+				t.Errorf("the small file wasn't translated:\n%s", out[chapterName(2)])
+			}
+			requests := api.requested("of the whole book")
+			switch action {
+			case oversizedCopy:
+				if out[chapterName(1)] != book[5].data || requests != 0 {
+					t.Errorf("the oversized file wasn't copied untranslated (%d requests):\n%s", requests, out[chapterName(1)])
+				}
+			case oversizedChunk:
+				if n := strings.Count(out[chapterName(1)], "[T]Paragraph"); n != 40 {
+					t.Errorf("translated %d of 40 paragraphs of the oversized file", n)
+				}
+				if requests == 0 || requests >= 40 {
+					t.Errorf("got %d requests for 40 paragraphs, want them in batches", requests)
+				}
+			}
+		})
+	}
+}
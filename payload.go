@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values of -role.
+const (
+	roleAuto      = "auto"
+	roleSystem    = "system"
+	roleDeveloper = "developer"
+)
+
+func validateRole(role string) error {
+	switch role {
+	case roleAuto, roleSystem, roleDeveloper:
+		return nil
+	}
+	return fmt.Errorf("unknown role %q, expected auto, system or developer", role)
+}
+
+// isReasoningModel reports whether model is one of OpenAI's o-series
+// reasoning models (o1, o3-mini, o4-mini, ...), optionally with a gateway
+// prefix like "openai/". These reject the system role and sampling
+// parameters such as temperature.
+func isReasoningModel(model string) bool {
+	model = strings.ToLower(model)
+	if i := strings.LastIndexByte(model, '/'); i >= 0 {
+		model = model[i+1:]
+	}
+	return len(model) >= 2 && model[0] == 'o' && model[1] >= '0' && model[1] <= '9'
+}
+
+// instructionRole is the role the system prompt is sent with.
+func instructionRole(cfg *Config) string {
+	if cfg.Role != roleAuto && cfg.Role != "" {
+		return cfg.Role
+	}
+	if isReasoningModel(cfg.Model) {
+		return roleDeveloper
+	}
+	return roleSystem
+}
+
+// buildPayload creates the chat completion request body, leaving out
+// parameters the model doesn't accept. -examples go between the system
+// prompt and the block, as earlier turns of the conversation.
+func buildPayload(systemPrompt, content string, cfg *Config) map[string]interface{} {
+	messages := []map[string]string{{"role": instructionRole(cfg), "content": systemPrompt}}
+	messages = append(messages, cfg.Examples.messages()...)
+	messages = append(messages, map[string]string{"role": "user", "content": content})
+
+	payload := map[string]interface{}{
+		"model":    cfg.Model,
+		"messages": messages,
+	}
+
+	if cfg.Temperature != nil && !isReasoningModel(cfg.Model) {
+		payload["temperature"] = *cfg.Temperature
+	}
+	if cfg.BestOf > 1 {
+		payload["n"] = cfg.BestOf
+	}
+	return payload
+}
+
+// buildAnthropicPayload creates a Messages API request body. The system
+// prompt has a field of its own there.
+func buildAnthropicPayload(systemPrompt, content string, cfg *Config) map[string]interface{} {
+	messages := cfg.Examples.messages()
+	messages = append(messages, map[string]string{"role": "user", "content": content})
+
+	payload := map[string]interface{}{
+		"model":      cfg.Model,
+		"max_tokens": anthropicMaxTokens,
+		"system":     systemPrompt,
+		"messages":   messages,
+	}
+	if cfg.Temperature != nil {
+		payload["temperature"] = *cfg.Temperature
+	}
+	return payload
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestPayloadRole(t *testing.T) {
+	temperature := 0.3
+	tests := []struct {
+		model, role string
+		wantRole    string
+		temperature bool
+	}{
+		{"gpt-4o", roleAuto, roleSystem, true},
+		{"o1", roleAuto, roleDeveloper, false},
+		{"o3-mini", roleAuto, roleDeveloper, false},
+		{"openai/o4-mini", roleAuto, roleDeveloper, false},
+		{"omni-large", roleAuto, roleSystem, true},
+		{"gpt-4o", roleDeveloper, roleDeveloper, true},
+		{"o3-mini", roleSystem, roleSystem, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig("")
+		cfg.Model = tt.model
+		cfg.Role = tt.role
+		cfg.Temperature = &temperature
+
+		payload := buildPayload("Translate.", "Hello", cfg)
+		messages := payload["messages"].([]map[string]string)
+		if got := messages[0]["role"]; got != tt.wantRole {
+			t.Errorf("%s with -role %s: got role %q, want %q", tt.model, tt.role, got, tt.wantRole)
+		}
+		if last := messages[len(messages)-1]; last["role"] != "user" || last["content"] != "Hello" {
+			t.Errorf("%s: got last message %v", tt.model, last)
+		}
+		if _, ok := payload["temperature"]; ok != tt.temperature {
+			t.Errorf("%s: temperature sent: %v, want %v", tt.model, ok, tt.temperature)
+		}
+	}
+}
+
+func TestValidateRole(t *testing.T) {
+	for _, role := range []string{roleAuto, roleSystem, roleDeveloper} {
+		if err := validateRole(role); err != nil {
+			t.Errorf("%s: %v", role, err)
+		}
+	}
+	if err := validateRole("assistant"); err == nil {
+		t.Error("accepted -role assistant")
+	}
+}
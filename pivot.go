@@ -0,0 +1,46 @@
+package main
+
+// translateViaPivot translates a block into cfg.PivotLang first and the
+// result into the target language. Both hops go through translateNode, so
+// each is cached on its own; the translation memory, the word counts and the
+// session only record source and final translation.
+func translateViaPivot(htmlContent, context string, cfg *Config) (string, error) {
+	if known, ok := cfg.ImportedMemory[htmlContent]; ok {
+		cfg.remember(htmlContent, known)
+		return known, nil
+	}
+
+	// The glossary, the examples and a -prompt-dir prompt are written for
+	// the target language, so the first hop uses the default prompt without them
+	first := *cfg
+	first.PivotLang = ""
+	first.TargetLang = cfg.PivotLang
+	first.LanguagePrompt = ""
+	first.Glossary = nil
+	first.Examples = nil
+	first.ImportedMemory = nil
+	first.ExportMemory = nil
+	first.Stats = nil
+	first.Session = nil
+
+	intermediate, err := translateNode(htmlContent, context, &first)
+	if err != nil {
+		return intermediate, err
+	}
+
+	second := *cfg
+	second.PivotLang = ""
+	second.SourceLang = cfg.PivotLang
+	second.ImportedMemory = nil
+	second.ExportMemory = nil
+	second.Stats = nil
+	second.Session = nil
+
+	translated, err := translateNode(intermediate, context, &second)
+	if err != nil {
+		return htmlContent + failureMarker, err
+	}
+
+	cfg.remember(htmlContent, translated)
+	return translated, nil
+}
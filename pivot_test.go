@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPivotLang(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.PivotLang = "English"
+	cfg.SourceLang = "Finnish"
+	cfg.Cache = newMemoryCache()
+	out, err := translate(t, testBook(`<p>Hyvää päivää.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := api.requested("Hyvää päivää."); n != 2 {
+		t.Fatalf("got %d requests for the block, want 2: %q", n, api.requests())
+	}
+	if n := api.requested("[T]Hyvää päivää."); n != 1 {
+		t.Errorf("the intermediate was sent %d times, want once: %q", n, api.requests())
+	}
+	var hops []string
+	for i, c := range api.requests() {
+		if strings.Contains(c, "Hyvää päivää.") {
+			hops = append(hops, api.systemPrompt(i))
+		}
+	}
+	if !strings.Contains(hops[0], "Translate from Finnish to English.") {
+		t.Errorf("first hop isn't into the pivot language: %s", hops[0])
+	}
+	if !strings.Contains(hops[1], "Translate from English to German.") {
+		t.Errorf("second hop isn't from the pivot language: %s", hops[1])
+	}
+	if chapter := out[chapterName(1)]; !strings.Contains(chapter, "<p>[T][T]Hyvää päivää.</p>") {
+		t.Errorf("chapter doesn't have the translation of the intermediate:\n%s", chapter)
+
+	}
+
+	cache := cfg.Cache
+	api = newStubAPI(t, nil)
+	cfg = testConfig(api.URL)
+	cfg.PivotLang = "English"
+	cfg.SourceLang = "Finnish"
+	cfg.Cache = cache
+	if _, err := translate(t, testBook(`<p>Hyvää päivää.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.requested("Hyvää päivää."); n != 0 {
+		t.Errorf("got %d requests for the block with both hops cached: %q", n, api.requests())
+	}
+}
+
+func TestPivotLangWithFileSession(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.PivotLang = "English"
+	cfg.FileSession = true
+	body := `<figure><img src="../img/a.png" alt=""/><figcaption>The old lighthouse.</figcaption></figure><p>We walked up to the lighthouse.</p>`
+	if _, err := translate(t, testBook(body), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pivot text of the body is "[T]We walked ...", its final
+	// translation "[T][T]We walked ..."
+	pivot := regexp.MustCompile(`"\[T\]We walked`)
+	found := false
+	for i, c := range api.requests() {
+		if !strings.Contains(c, "The old lighthouse.") {
+			continue
+		}
+		prompt := api.systemPrompt(i)
+		if pivot.MatchString(prompt) {
+			t.Errorf("the caption's context has the pivot text of the body:\n%s", prompt)
+		}
+		found = found || strings.Contains(prompt, `"We walked up to the lighthouse." was translated as "[T][T]We walked up to the lighthouse."`)
+	}
+	if !found {
+		t.Errorf("no request for the caption has the body's final translation as context: %q", api.requests())
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// placeholderAttr marks a block that still has to be translated, see
+// -translate-placeholder. Its value numbers the marked blocks of a file.
+const placeholderAttr = "data-epub-translator-placeholder"
+
+// markPlaceholders marks every block of selection that has text and leaves
+// it untranslated.
+func markPlaceholders(selection *goquery.Selection, cfg *Config) int {
+	marked := 0
+	selection.Each(func(i int, s *goquery.Selection) {
+		if !hasTranslatableText(s, cfg) {
+			return
+		}
+		marked++
+		s.SetAttr(placeholderAttr, strconv.Itoa(marked))
+	})
+	return marked
+}
+
+// clearPlaceholders removes the marker from the blocks of selection that
+// were translated; failed ones keep it for the next -fill-placeholders run.
+func clearPlaceholders(selection *goquery.Selection, failures []blockFailure) {
+	failed := make(map[string]bool, len(failures))
+	for _, f := range failures {
+		failed[f.Path] = true
+	}
+	selection.Each(func(i int, s *goquery.Selection) {
+		if !failed[nodePath(s.Get(0))] {
+			s.RemoveAttr(placeholderAttr)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarkAndFillPlaceholders(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		if strings.Contains(content, "Broken") {
+			return http.StatusInternalServerError, ""
+		}
+		return prefixReply(content)
+	})
+	dir := t.TempDir()
+	book := testBook(`<h1>Title</h1><p>First text.</p><p>Broken text.</p><p>Done by hand.</p>`)
+	input := writeZip(t, dir, "book.epub", book)
+
+	cfg := testConfig(api.URL)
+	cfg.MarkPlaceholders = true
+	marked := filepath.Join(dir, "marked.epub")
+	if err := processEpub(input, marked, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(api.requests()); n != 0 {
+		t.Fatalf("marking sent %d requests", n)
+	}
+	entries := readEntries(t, marked)
+	chapter := entries[chapterName(1)]
+	for _, want := range []string{
+		`<h1 data-epub-translator-placeholder="1">Title</h1>`,
+		`<p data-epub-translator-placeholder="2">First text.</p>`,
+		`<p data-epub-translator-placeholder="4">Done by hand.</p>`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("marked chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if entries["OEBPS/toc.ncx"] != book[4].data {
+		t.Errorf("marking changed the NCX:\n%s", entries["OEBPS/toc.ncx"])
+	}
+
+	// A translator finishes one block by hand before the second pass
+	chapter = strings.Replace(chapter, `<p data-epub-translator-placeholder="4">Done by hand.</p>`, `<p>Von Hand übersetzt.</p>`, 1)
+	var edited []zipEntry
+	for _, name := range entryNames(t, marked) {
+		edited = append(edited, zipEntry{name, entries[name]})
+	}
+	marked = writeZip(t, dir, "edited.epub", replaceEntry(edited, chapterName(1), chapter))
+
+	cfg = testConfig(api.URL)
+	cfg.FillPlaceholders = true
+	cfg.MetadataFields = []string{"dc:title"}
+	filled := filepath.Join(dir, "filled.epub")
+	if err := processEpub(marked, filled, cfg); err == nil {
+		t.Fatal("a failed block didn't make the run incomplete")
+	}
+	// The nav document's entries were marked too, the NCX's labels and the
+	// title weren't
+	for _, c := range api.requests() {
+		if c == "Chapter 1" || c == "Test Book" || strings.Contains(c, "Done by hand.") {
+			t.Errorf("sent a block that wasn't marked: %q", c)
+		}
+	}
+	if api.requested("First text.") != 1 {
+		t.Errorf("sent %q %d times, want once", "First text.", api.requested("First text."))
+	}
+
+	entries = readEntries(t, filled)
+	chapter = entries[chapterName(1)]
+	for _, want := range []string{
+		`<h1>[T]Title</h1>`,
+		`<p>[T]First text.</p>`,
+		`<p data-epub-translator-placeholder="3">Broken text.`,
+		`<p>Von Hand übersetzt.</p>`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("filled chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if strings.Contains(entries["OEBPS/toc.ncx"], "[T]") || strings.Contains(entries["OEBPS/content.opf"], "[T]") {
+		t.Errorf("filling translated the NCX or the OPF")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// findEntry looks up a zip entry by its full name or, failing that, by a
+// unique file name or path suffix ("ch1.xhtml", "text/ch1.xhtml").
+func findEntry(files []*zip.File, name string) (*zip.File, error) {
+	if f := findZipFile(files, name); f != nil {
+		return f, nil
+	}
+
+	var matches []*zip.File
+	for _, f := range files {
+		if path.Base(f.Name) == name || strings.HasSuffix(f.Name, "/"+name) {
+			matches = append(matches, f)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no entry named %s", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%s is ambiguous, use the full entry name (see -list)", name)
+	}
+}
+
+// previewChapter translates a single entry and writes an HTML page that shows
+// the original and the translation, with a button to switch between them.
+// It returns the path of that page.
+func previewChapter(inputPath, name string, cfg *Config) (string, error) {
+	reader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return "", fmt.Errorf("could not open input epub: %w: %w", ErrInvalidEpub, err)
+	}
+	defer reader.Close()
+
+	file, err := findEntry(reader.File, name)
+	if err != nil {
+		return "", err
+	}
+	if !isTranslatable(file.Name) {
+		return "", fmt.Errorf("%s is not an (X)HTML file", file.Name)
+	}
+
+	source, err := readZipFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+
+	log.Printf("Translating %s for preview...", file.Name)
+	var translated bytes.Buffer
+	if _, err := translateHTML(bytes.NewReader(source), &translated, cfg); err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp("", "epub-preview-*.html")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	_, err = fmt.Fprintf(out, previewTemplate,
+		html.EscapeString(file.Name),
+		html.EscapeString(string(source)),
+		html.EscapeString(translated.String()),
+	)
+	return out.Name(), err
+}
+
+const previewTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Preview: %[1]s</title>
+<style>
+  body { margin: 0; font-family: sans-serif; }
+  header { padding: 0.5em 1em; background: #eee; display: flex; gap: 1em; align-items: center; }
+  iframe { width: 100%%; height: calc(100vh - 3em); border: 0; }
+  .hidden { display: none; }
+</style>
+</head>
+<body>
+<header>
+  <strong>%[1]s</strong>
+  <button onclick="toggle()">Show <span id="other">original</span></button>
+  <span id="current">Translation</span>
+</header>
+<iframe id="original" class="hidden" srcdoc="%[2]s"></iframe>
+<iframe id="translated" srcdoc="%[3]s"></iframe>
+<script>
+function toggle() {
+  var o = document.getElementById("original"), t = document.getElementById("translated");
+  var showOriginal = o.classList.contains("hidden");
+  o.classList.toggle("hidden", !showOriginal);
+  t.classList.toggle("hidden", showOriginal);
+  document.getElementById("current").textContent = showOriginal ? "Original" : "Translation";
+  document.getElementById("other").textContent = showOriginal ? "translation" : "original";
+}
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestPreviewChapter(t *testing.T) {
+	api := newStubAPI(t, nil)
+	input := writeZip(t, t.TempDir(), "book.epub", testBook(`<p>First chapter.</p>`, `<p>Second chapter.</p>`))
+
+	path, err := previewChapter(input, "ch2.xhtml", testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	page, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := page.Find("iframe#original").AttrOr("srcdoc", "")
+	translated := page.Find("iframe#translated").AttrOr("srcdoc", "")
+	if !strings.Contains(original, "<p>Second chapter.</p>") || strings.Contains(original, "[T]") {
+		t.Errorf("got original:\n%s", original)
+	}
+	if !strings.Contains(translated, "<p>[T]Second chapter.</p>") {
+		t.Errorf("got translation:\n%s", translated)
+	}
+	if !strings.Contains(page.Find("header strong").Text(), chapterName(2)) {
+		t.Errorf("the page doesn't name the chapter")
+	}
+	if requests := api.requests(); len(requests) != 1 || requests[0] != "Second chapter." {
+		t.Errorf("sent %q, want only the chapter", requests)
+	}
+}
+
+func TestPreviewChapterErrors(t *testing.T) {
+	api := newStubAPI(t, nil)
+	entries := append(testBook(`<p>Text.</p>`), zipEntry{"OEBPS/extra/ch1.xhtml", xhtml(`<p>Other.</p>`)})
+	input := writeZip(t, t.TempDir(), "book.epub", entries)
+
+	for _, name := range []string{"ch1.xhtml", "ch9.xhtml", "img/a.png"} {
+		if path, err := previewChapter(input, name, testConfig(api.URL)); err == nil {
+			os.Remove(path)
+			t.Errorf("%s: previewed", name)
+		}
+	}
+	if n := len(api.requests()); n != 0 {
+		t.Errorf("sent %d requests", n)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// languageProfile is a -lang-profile: a target that is a variety of a
+// language rather than a language, such as plain language or a house style,
+// e.g.
+//
+//	language: en
+//	instructions: |
+//	  Use short sentences and common words. Explain technical terms.
+//
+// The target language is then the name of the profile ("Simple English").
+type languageProfile struct {
+	// Language is the language the profile is written in, for the features
+	// that need a real one (-localize-punctuation, -bidi-fixup, TMX). It is
+	// optional; without it, those features are skipped.
+	Language string `yaml:"language"`
+
+	// Instructions are added to the system prompt.
+	Instructions string `yaml:"instructions"`
+}
+
+// loadLanguageProfile reads a -lang-profile file.
+func loadLanguageProfile(path string) (*languageProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p languageProfile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	p.Language = strings.TrimSpace(p.Language)
+	p.Instructions = strings.TrimSpace(p.Instructions)
+	if p.Instructions == "" {
+		return nil, fmt.Errorf("%s has no instructions", path)
+	}
+	if p.Language != "" {
+		if _, ok := lookupLanguage(p.Language); !ok {
+			return nil, fmt.Errorf("%s: unknown language %q, see -list-languages", path, p.Language)
+		}
+	}
+	return &p, nil
+}
+
+// profilePrompt is the part of the system prompt for the profile target.
+func profilePrompt(target, instructions string) string {
+	return fmt.Sprintf("%s is defined by these rules, follow them: %s", target, instructions)
+}
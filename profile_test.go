@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLanguageProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "simple.yaml")
+	os.WriteFile(path, []byte("instructions: |\n  Use short sentences and common words.\n  Explain technical terms.\n"), 0o644)
+	profile, err := loadLanguageProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Language != "" {
+		t.Errorf("got language %q for a profile without one", profile.Language)
+	}
+
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.TargetLang = "Simple English"
+	cfg.LanguageProfile = profile.Instructions
+	chapter := `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="en" xml:lang="en"><head><title>Test</title></head><body><p>The mitochondrion is the powerhouse of the cell.</p></body></html>`
+	out, err := translate(t, replaceEntry(testBook(`<p>x</p>`), chapterName(1), chapter), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := api.systemPromptFor("mitochondrion")
+	for _, want := range []string{"Simple English", "Simple English is defined by these rules, follow them: Use short sentences and common words.\nExplain technical terms."} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("system prompt lacks %q:\n%s", want, prompt)
+		}
+	}
+	if opf := out["OEBPS/content.opf"]; !strings.Contains(opf, "<dc:language>en</dc:language>") {
+		t.Errorf("the metadata language was rewritten:\n%s", opf)
+	}
+	if got := out[chapterName(1)]; !strings.Contains(got, `lang="en" xml:lang="en"`) {
+		t.Errorf("the language of the chapter was rewritten:\n%s", got)
+	}
+}
+
+func TestLoadLanguageProfileErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"no instructions":  "language: en\n",
+		"unknown language": "language: Elvish\ninstructions: Be brief.\n",
+		"not yaml":         "instructions: [\n",
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".yaml")
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadLanguageProfile(path); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	path := filepath.Join(dir, "b1.yaml")
+	os.WriteFile(path, []byte("language: German\ninstructions: Use B1-level vocabulary.\n"), 0o644)
+	if p, err := loadLanguageProfile(path); err != nil || p.Language != "German" {
+		t.Errorf("got %+v, %v", p, err)
+	}
+}
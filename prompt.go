@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadLanguagePrompt looks in dir for a prompt written for targetLang. Files
+// are named after the language code (de.txt, ja.txt) or, for languages not in
+// the table, the lower-cased name (klingon.txt). It returns the prompt and
+// the file it came from, or empty strings if there is no such file.
+func loadLanguagePrompt(dir, targetLang string) (string, string, error) {
+	var candidates []string
+	if l, ok := lookupLanguage(targetLang); ok {
+		candidates = append(candidates, l.Code+".txt", strings.ToLower(l.Code)+".txt")
+	}
+	candidates = append(candidates, strings.ToLower(strings.TrimSpace(targetLang))+".txt")
+
+	for _, name := range candidates {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return strings.TrimSpace(string(data)), path, nil
+	}
+	return "", "", nil
+}
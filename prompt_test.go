@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLanguagePrompt(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "de.txt"), []byte("Du übersetzt nach {language}. Verwende die neue Rechtschreibung.\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "klingon.txt"), []byte("Translate to {language}."), 0o644)
+
+	tests := []struct {
+		lang, file string
+	}{
+		{"German", "de.txt"},
+		{"de", "de.txt"},
+		{"Klingon", "klingon.txt"},
+		{"French", ""},
+	}
+	for _, tt := range tests {
+		_, path, err := loadLanguagePrompt(dir, tt.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.file == "" && path != "" || tt.file != "" && filepath.Base(path) != tt.file {
+			t.Errorf("%s: got prompt %q, want %q", tt.lang, path, tt.file)
+		}
+	}
+
+	prompt, _, _ := loadLanguagePrompt(dir, "German")
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.LanguagePrompt = prompt
+	if _, err := translate(t, testBook(`<p>Text.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.systemPromptFor("Text."); !strings.HasPrefix(got, "Du übersetzt nach German. Verwende die neue Rechtschreibung.") {
+		t.Errorf("got system prompt %q", got)
+	}
+
+	api = newStubAPI(t, nil)
+	if _, err := translate(t, testBook(`<p>Text.</p>`), testConfig(api.URL)); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.systemPromptFor("Text."); !strings.HasPrefix(got, "You are a professional translator. Translate to German.") {
+		t.Errorf("without a prompt file: got system prompt %q", got)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Values of -provider.
+const (
+	providerOpenAI    = "openai"
+	providerAnthropic = "anthropic"
+	providerIdentity  = "identity"
+)
+
+func validateProvider(provider string) error {
+	switch provider {
+	case providerOpenAI, providerAnthropic, providerIdentity:
+		return nil
+	}
+	return fmt.Errorf("unknown provider %q, expected openai, anthropic or identity", provider)
+}
+
+// Anthropic Messages API settings.
+const (
+	anthropicURL     = "https://api.anthropic.com/v1/messages"
+	anthropicVersion = "2023-06-01"
+
+	// anthropicMaxTokens is the required cap on the length of an answer,
+	// set to what every current model supports.
+	anthropicMaxTokens = 4096
+)
+
+// AnthropicResponse is the part of a Messages API response we need.
+type AnthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+// requestPayload is the request body for the provider, or from
+// -request-template.
+func requestPayload(systemPrompt, content string, cfg *Config) map[string]interface{} {
+	if cfg.RequestTemplate != nil {
+		return cfg.RequestTemplate.payload(systemPrompt, content, cfg)
+	}
+	if cfg.Provider == providerAnthropic {
+		return buildAnthropicPayload(systemPrompt, content, cfg)
+	}
+	return buildPayload(systemPrompt, content, cfg)
+}
+
+// setAuthHeaders adds the credentials (and, for Anthropic, the API version)
+// to req.
+func setAuthHeaders(req *http.Request, cfg *Config) {
+	if cfg.Provider == providerAnthropic {
+		req.Header.Set("x-api-key", cfg.APIKey)
+		req.Header.Set("anthropic-version", anthropicVersion)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+}
+
+// responseText extracts the translation of content from a successful
+// response body, at -response-path if given. It reports false if the body
+// doesn't contain one. Of several choices, pickChoice decides.
+func responseText(body []byte, content string, cfg *Config) (string, bool) {
+	if cfg.ResponsePath != nil {
+		return cfg.ResponsePath.text(body)
+	}
+	if cfg.Provider == providerAnthropic {
+		var resp AnthropicResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return "", false
+		}
+		var text strings.Builder
+		found := false
+		for _, c := range resp.Content {
+			if c.Type == "text" {
+				text.WriteString(c.Text)
+				found = true
+			}
+		}
+		return strings.TrimSpace(text.String()), found
+	}
+
+	var resp OpenAIResponse
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Choices) == 0 {
+		return "", false
+	}
+	choices := make([]string, len(resp.Choices))
+	for i, c := range resp.Choices {
+		choices[i] = strings.TrimSpace(string(c.Message.Content))
+	}
+	return pickChoice(choices, content, cfg), true
+}
+
+// responseUsage returns the input and output tokens a response body reports,
+// in the OpenAI or the Anthropic format, or zeros.
+func responseUsage(body []byte) (int, int) {
+	var resp struct {
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			InputTokens      int `json:"input_tokens"`
+			OutputTokens     int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, 0
+	}
+	u := resp.Usage
+	return u.PromptTokens + u.InputTokens, u.CompletionTokens + u.OutputTokens
+}
+
+// identityResponse is the answer of the identity provider: the content
+// unchanged, with cfg.IdentityMarker in front of each block (of a batch, the
+// text inside each <x-block>).
+func identityResponse(content string, cfg *Config) string {
+	if cfg.IdentityMarker == "" {
+		return content
+	}
+	if !strings.HasPrefix(content, "<x-block ") {
+		return cfg.IdentityMarker + content
+	}
+	return batchBlockPattern.ReplaceAllStringFunc(content, func(block string) string {
+		open := strings.IndexByte(block, '>') + 1
+		return block[:open] + cfg.IdentityMarker + block[open:]
+	})
+}
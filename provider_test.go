@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIdentityProvider(t *testing.T) {
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.Provider = providerIdentity
+	cfg.IdentityMarker = "[de]"
+	out, err := translate(t, testBook(`<h1>Title</h1><blockquote><p>Quoted text.</p></blockquote><p translate="no">Keep me.</p><p>1984</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		"<h1>[de]Title</h1>",
+		"<blockquote>[de]<p>Quoted text.</p></blockquote>",
+		`<p translate="no">Keep me.</p>`,
+		"<p>1984</p>",
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if strings.Count(chapter, "[de]") != 2 {
+		t.Errorf("blocks were wrapped more than once:\n%s", chapter)
+	}
+	if !strings.Contains(out["OEBPS/toc.ncx"], "<text>[de]Chapter 1</text>") {
+		t.Errorf("NCX label not passed through the provider:\n%s", out["OEBPS/toc.ncx"])
+	}
+	if out["OEBPS/img/a.png"] != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("image changed")
+	}
+}
+
+func TestIdentityProviderWritesAValidEpub(t *testing.T) {
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.Provider = providerIdentity
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", testBook(`<p>Text.</p>`))
+	output := filepath.Join(dir, "out.epub")
+	if err := processEpub(input, output, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if first := r.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Errorf("the first entry is %s with method %d, want a stored mimetype", first.Name, first.Method)
+	}
+	entries := readEntries(t, output)
+	if entries["mimetype"] != "application/epub+zip" {
+		t.Errorf("got mimetype %q", entries["mimetype"])
+	}
+	if !strings.Contains(entries[chapterName(1)], "<p>Text.</p>") {
+		t.Errorf("the identity provider changed the text without a marker:\n%s", entries[chapterName(1)])
+	}
+	if _, err := readPackage(r.File); err != nil {
+		t.Errorf("the output's package can't be read: %v", err)
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	var requests []*http.Request
+	var bodies []map[string]any
+	responses := []fakeResponse{
+		{status: http.StatusTooManyRequests, body: `{"type":"error","error":{"type":"rate_limit_error"}}`},
+		{status: http.StatusOK, body: `{"content":[{"type":"thinking","thinking":"..."},{"type":"text","text":"Hallo "},{"type":"text","text":"Welt"}],"usage":{"input_tokens":12,"output_tokens":3}}`},
+	}
+	calls := 0
+	send := fakeAPI(responses, &calls)
+
+	cfg := testConfig(anthropicURL)
+	cfg.Provider = providerAnthropic
+	cfg.Model = "claude-test"
+	cfg.DoRequest = func(req *http.Request) (*http.Response, error) {
+		var body map[string]any
+		data, _ := io.ReadAll(req.Body)
+		json.Unmarshal(data, &body)
+		requests = append(requests, req)
+		bodies = append(bodies, body)
+		return send(req)
+	}
+
+	got, err := requestTranslation("Translate to German.", "Hello world", nil, cfg)
+	if err != nil || got != "Hallo Welt" {
+		t.Fatalf("got %q, %v, want the text blocks of the answer", got, err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want the 429 retried once", calls)
+	}
+
+	req := requests[0]
+	if req.URL.String() != anthropicURL {
+		t.Errorf("sent to %s", req.URL)
+	}
+	for header, want := range map[string]string{"x-api-key": cfg.APIKey, "anthropic-version": anthropicVersion, "Authorization": ""} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("header %s is %q, want %q", header, got, want)
+		}
+	}
+
+	body := bodies[0]
+	if body["system"] != "Translate to German." || body["model"] != "claude-test" || body["max_tokens"] != float64(anthropicMaxTokens) {
+		t.Errorf("got payload %v", body)
+	}
+	messages, _ := body["messages"].([]any)
+	if len(messages) != 1 {
+		t.Fatalf("got messages %v, want only the user turn", messages)
+	}
+	if m, _ := messages[0].(map[string]any); m["role"] != "user" || m["content"] != "Hello world" {
+		t.Errorf("got message %v", m)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// quoteStyle holds a language's primary (double) and secondary (single)
+// quotation marks.
+type quoteStyle struct {
+	Open, Close             string
+	OpenSingle, CloseSingle string
+}
+
+// quoteStyles is keyed by the codes of the languages table.
+var quoteStyles = map[string]quoteStyle{
+	"cs":    {"„", "“", "‚", "‘"},
+	"da":    {"»", "«", "›", "‹"},
+	"de":    {"„", "“", "‚", "‘"},
+	"en":    {"“", "”", "‘", "’"},
+	"es":    {"«", "»", "“", "”"},
+	"fi":    {"”", "”", "’", "’"},
+	"fr":    {"«\u00a0", "\u00a0»", "‹\u00a0", "\u00a0›"},
+	"hu":    {"„", "”", "»", "«"},
+	"it":    {"«", "»", "“", "”"},
+	"ja":    {"「", "」", "『", "』"},
+	"nb":    {"«", "»", "‘", "’"},
+	"nl":    {"“", "”", "‘", "’"},
+	"pl":    {"„", "”", "«", "»"},
+	"pt":    {"«", "»", "“", "”"},
+	"pt-BR": {"“", "”", "‘", "’"},
+	"ru":    {"«", "»", "„", "“"},
+	"sk":    {"„", "“", "‚", "‘"},
+	"sv":    {"”", "”", "’", "’"},
+	"uk":    {"«", "»", "„", "“"},
+	"zh":    {"“", "”", "‘", "’"},
+	"zh-TW": {"「", "」", "『", "』"},
+}
+
+// nested is the style of quotes within a quotation: both kinds of quotes
+// become the secondary marks.
+func (q quoteStyle) nested() quoteStyle {
+	return quoteStyle{q.OpenSingle, q.CloseSingle, q.OpenSingle, q.CloseSingle}
+}
+
+// quoteStyleFor returns the quotation marks for the target language.
+func quoteStyleFor(targetLang string) (quoteStyle, bool) {
+	l, ok := lookupLanguage(targetLang)
+	if !ok {
+		return quoteStyle{}, false
+	}
+	style, ok := quoteStyles[l.Code]
+	return style, ok
+}
+
+// localizeQuotes replaces straight and English curly quotes in the text of an
+// HTML fragment with the target language's quotation marks. Tags, attribute
+// values and the content of code elements are left alone; quotes written as
+// entities, like &quot;, are replaced as well. Whether a quote opens or
+// closes is decided by the character before it, which is tracked across
+// inline tags, so "<em>Hi</em>" is handled like "Hi". A <q> renders its own
+// (primary) marks, so quotes inside it get the secondary ones.
+func localizeQuotes(fragment string, style quoteStyle) string {
+	var b strings.Builder
+	prev := ' '
+	codeDepth, qDepth := 0, 0
+
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+
+		raw := string(z.Raw())
+		switch tt {
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			if isCodeElement(string(name)) {
+				if tt == html.StartTagToken {
+					codeDepth++
+				} else if codeDepth > 0 {
+					codeDepth--
+				}
+			}
+			if string(name) == "q" {
+				if tt == html.StartTagToken {
+					qDepth++
+					prev = '('
+				} else if qDepth > 0 {
+					qDepth--
+					prev = '.'
+				}
+			}
+			b.WriteString(raw)
+
+		case html.TextToken:
+			if codeDepth > 0 {
+				b.WriteString(raw)
+				continue
+			}
+			textStyle := style
+			if qDepth > 0 {
+				textStyle = style.nested()
+			}
+			// Quotes may come as entities (&quot; &#34; &#39;); a text
+			// without any to replace keeps its entities as they were
+			text := html.UnescapeString(raw)
+			if localized := localizeText(text, textStyle, &prev); localized != text {
+				b.WriteString(textEscaper.Replace(localized))
+			} else {
+				b.WriteString(raw)
+			}
+
+		default:
+			b.WriteString(raw)
+		}
+	}
+}
+
+func isCodeElement(name string) bool {
+	return name == "code" || name == "pre" || name == "kbd" || name == "samp"
+}
+
+func localizeText(s string, style quoteStyle, prev *rune) string {
+	var b strings.Builder
+	for i, r := range s {
+		next, _ := utf8.DecodeRuneInString(s[i+utf8.RuneLen(r):])
+
+		// After a replaced mark, prev stands for what it did rather than
+		// the ambiguous straight quote: '(' after an opening, '.' after a
+		// closing quote.
+		switch r {
+		case '"', '“', '”', '„':
+			if opensQuote(*prev) {
+				b.WriteString(style.Open)
+				*prev = '('
+			} else {
+				b.WriteString(style.Close)
+				*prev = '.'
+			}
+			continue
+
+		case '\'', '‘', '’':
+			switch {
+			case isWordRune(*prev) && isWordRune(next):
+				// Apostrophe inside a word (don't, l'homme)
+				b.WriteRune('’')
+				*prev = 'a'
+			case opensQuote(*prev) && isWordRune(next):
+				b.WriteString(style.OpenSingle)
+				*prev = '('
+			case !opensQuote(*prev):
+				b.WriteString(style.CloseSingle)
+				*prev = '.'
+			default:
+				b.WriteRune(r)
+				*prev = r
+			}
+			continue
+		}
+
+		b.WriteRune(r)
+		*prev = r
+	}
+	return b.String()
+}
+
+// opensQuote reports whether a quote following r starts a quotation.
+func opensQuote(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("([{—–-/", r)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isQuoteMark reports whether r is a quotation mark of any of the styles.
+func isQuoteMark(r rune) bool {
+	return unicode.In(r, unicode.Pi, unicode.Pf) || strings.ContainsRune("\"'„‚「」『』", r)
+}
+
+// hasQuotedQ reports whether a <q> inside n starts and ends with quotation
+// marks of its own.
+func hasQuotedQ(n *html.Node) bool {
+	for _, q := range qElements(n) {
+		if _, _, ok := quotedEnds(q); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unquoteQ removes the quotation marks a translation put around the text of
+// the <q> elements in n; the reading system adds its own, so they would show
+// up twice. Call it only if hasQuotedQ was false for the source.
+func unquoteQ(n *html.Node) {
+	for _, q := range qElements(n) {
+		first, last, ok := quotedEnds(q)
+		if !ok {
+			continue
+		}
+		// Along with the space inside the mark, like the no-break space of « »
+		text := first.Data
+		lead := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+		_, size := utf8.DecodeRuneInString(text[lead:])
+		first.Data = text[:lead] + strings.TrimLeftFunc(text[lead+size:], unicode.IsSpace)
+
+		text = last.Data
+		end := len(strings.TrimRightFunc(text, unicode.IsSpace))
+		_, size = utf8.DecodeLastRuneInString(text[:end])
+		last.Data = strings.TrimRightFunc(text[:end-size], unicode.IsSpace) + text[end:]
+	}
+}
+
+// qElements returns the <q> elements inside n, outermost first.
+func qElements(n *html.Node) []*html.Node {
+	var qs []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == "q" {
+				qs = append(qs, c)
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return qs
+}
+
+// quotedEnds returns the first and last text node of q if the text starts
+// and ends with a quotation mark.
+func quotedEnds(q *html.Node) (first, last *html.Node, ok bool) {
+	var texts []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+				texts = append(texts, c)
+			} else if c.Type == html.ElementNode {
+				walk(c)
+			}
+		}
+	}
+	walk(q)
+	if len(texts) == 0 {
+		return nil, nil, false
+	}
+
+	first, last = texts[0], texts[len(texts)-1]
+	start, _ := utf8.DecodeRuneInString(strings.TrimLeftFunc(first.Data, unicode.IsSpace))
+	end, _ := utf8.DecodeLastRuneInString(strings.TrimRightFunc(last.Data, unicode.IsSpace))
+	if !isQuoteMark(start) || !isQuoteMark(end) {
+		return nil, nil, false
+	}
+	// A single mark can't be both ends
+	if first == last && utf8.RuneCountInString(strings.TrimSpace(first.Data)) < 2 {
+		return nil, nil, false
+	}
+	return first, last, true
+}
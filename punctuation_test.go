@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLocalizeQuotes(t *testing.T) {
+	tests := []struct {
+		lang, in, want string
+	}{
+		{"de", `Er sagte "Hallo" und ging.`, `Er sagte „Hallo“ und ging.`},
+		{"de", `“Hallo”, sagte sie.`, `„Hallo“, sagte sie.`},
+		{"de", `Er sagte 'ja' und ging's an.`, `Er sagte ‚ja‘ und ging’s an.`},
+		{"de", `"Das ist <em>gut</em>"`, `„Das ist <em>gut</em>“`},
+		{"de", `Er sagte &quot;Hallo&quot; und &#34;Tschüss&#34;.`, `Er sagte „Hallo“ und „Tschüss“.`},
+		{"de", `Sie sagte &#39;ja&#39; &amp; ging.`, `Sie sagte ‚ja‘ &amp; ging.`},
+		{"de", `<a title="x">"Link"</a> <code>"code"</code>`, `<a title="x">„Link“</a> <code>"code"</code>`},
+		{"de", `Kein Zitat &amp; &nbsp;hier.`, `Kein Zitat &amp; &nbsp;hier.`},
+		{"de", `<q>Er sagte "nein"</q>`, `<q>Er sagte ‚nein‘</q>`},
+		{"fr", `Il a dit "bonjour".`, "Il a dit «\u00a0bonjour\u00a0»."},
+		{"fr", `Il a dit &quot;bonjour&quot;.`, "Il a dit «\u00a0bonjour\u00a0»."},
+		{"fr", `C'est 'vrai'.`, "C’est ‹\u00a0vrai\u00a0›."},
+	}
+	for _, tt := range tests {
+		style, ok := quoteStyleFor(tt.lang)
+		if !ok {
+			t.Fatalf("no quote style for %s", tt.lang)
+		}
+		if got := localizeQuotes(tt.in, style); got != tt.want {
+			t.Errorf("%s: localizeQuotes(%q) = %q, want %q", tt.lang, tt.in, got, tt.want)
+		}
+	}
+}
+
+var (
+	qStart = regexp.MustCompile(`(<q[^>]*>)([^“])`)
+	qEnd   = regexp.MustCompile(`([^”])</q>`)
+)
+
+func TestInlineQuotes(t *testing.T) {
+	// The model puts quotation marks inside the <q> elements that have none,
+	// which the reading system renders with marks of its own
+	api := newStubAPI(t, func(content string) (int, string) {
+		content = qStart.ReplaceAllString(content, `$1"$2`)
+		content = qEnd.ReplaceAllString(content, `$1"</q>`)
+		return http.StatusOK, "[T]" + content
+	})
+	cfg := testConfig(api.URL)
+	style, _ := quoteStyleFor("de")
+	cfg.QuoteStyle = &style
+	out, err := translate(t, testBook(
+		`<p>She said <q cite="#s1">He told me <q>go home</q> twice</q> and left.</p>`+
+			`<div><q>A quote of its own.</q></div>`+
+			`<p>Already quoted: <q>“As printed”</q>.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := out[chapterName(1)]
+	for _, want := range []string{
+		`<p>[T]She said <q cite="#s1">He told me <q>go home</q> twice</q> and left.</p>`,
+		`<div><q>[T]A quote of its own.</q></div>`,
+		`<p>[T]Already quoted: <q>‚As printed‘</q>.</p>`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if api.requested("go home") != 1 || api.requested("A quote of its own.") != 1 {
+		t.Errorf("the <q> elements weren't translated once each: %q", api.requests())
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rampUp staggers the first requests of a run over a window (-ramp-up), so
+// the workers don't all hit the API in the same instant and run into 429s
+// before the retries spread them out. The k-th of the first slots requests
+// waits until k*window/slots after the first one; later requests aren't
+// delayed. Its methods are safe on a nil rampUp, which doesn't wait.
+type rampUp struct {
+	mu     sync.Mutex
+	window time.Duration
+	slots  int
+	start  time.Time
+	next   int
+}
+
+// newRampUp returns the ramp-up for slots parallel requests, or nil if there
+// is nothing to stagger.
+func newRampUp(window time.Duration, slots int) *rampUp {
+	if window <= 0 || slots <= 1 {
+		return nil
+	}
+	return &rampUp{window: window, slots: slots}
+}
+
+// wait blocks until the calling request may start.
+func (r *rampUp) wait() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.next >= r.slots {
+		r.mu.Unlock()
+		return
+	}
+	if r.next == 0 {
+		r.start = time.Now()
+	}
+	at := r.start.Add(time.Duration(r.next) * r.window / time.Duration(r.slots))
+	r.next++
+	r.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rampUpStarts returns when each of workers goroutines, started together,
+// got past r.wait, relative to the earliest.
+func rampUpStarts(r *rampUp, workers int) []time.Duration {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var times []time.Time
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.wait()
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	starts := make([]time.Duration, len(times))
+	for i, t := range times {
+		starts[i] = t.Sub(times[0])
+	}
+	return starts
+}
+
+func TestRampUp(t *testing.T) {
+	const window, workers = 400 * time.Millisecond, 4
+	r := newRampUp(window, workers)
+	starts := rampUpStarts(r, workers)
+	// The k-th worker waits k quarters of the window
+	for k := 1; k < workers; k++ {
+		if min := time.Duration(k)*window/workers - 20*time.Millisecond; starts[k] < min {
+			t.Errorf("worker %d started %v after the first, want at least %v", k+1, starts[k], min)
+		}
+	}
+
+	start := time.Now()
+	r.wait()
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("a request after the ramp-up waited %v", elapsed)
+	}
+
+	// Without a ramp-up, they all start in the same instant
+	if starts := rampUpStarts(newRampUp(0, workers), workers); starts[workers-1] > 20*time.Millisecond {
+		t.Errorf("without -ramp-up, the last worker started %v after the first", starts[workers-1])
+	}
+}
+
+func TestRampUpRun(t *testing.T) {
+	const window = 300 * time.Millisecond
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.Concurrency = 3
+	cfg.RampUp = newRampUp(window, 3)
+
+	start := time.Now()
+	out, err := translate(t, testBook(`<p>One.</p>`, `<p>Two.</p>`, `<p>Three.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*window/3 {
+		t.Errorf("the run took %v, less than the ramp-up", elapsed)
+	}
+	if chapter := out[chapterName(3)]; !strings.Contains(chapter, "<p>[T]Three.</p>") {
+		t.Errorf("the last chapter wasn't translated:\n%s", chapter)
+	}
+}
+
+func TestRampUpIsOffForOneSlot(t *testing.T) {
+	if r := newRampUp(time.Second, 1); r != nil {
+		t.Error("a ramp-up for a single request at a time")
+	}
+	if r := newRampUp(0, 8); r != nil {
+		t.Error("a ramp-up without a window")
+	}
+}
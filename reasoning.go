@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z][\w.:-]*$`)
+
+// reasoningFilter removes the reasoning some models write before their
+// answer, e.g. <think>…</think>, see -strip-tags.
+type reasoningFilter struct {
+	tags     []string
+	closed   []*regexp.Regexp // a complete block at the start
+	unclosed []*regexp.Regexp // a start tag at the start that is never closed
+}
+
+// parseReasoningTags parses the comma-separated -strip-tags value, or returns
+// nil for an empty one.
+func parseReasoningTags(s string) (*reasoningFilter, error) {
+	f := &reasoningFilter{}
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if !tagNamePattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid -strip-tags name %q, expected tag names such as think,reasoning", tag)
+		}
+		q := regexp.QuoteMeta(tag)
+		f.tags = append(f.tags, strings.ToLower(tag))
+		f.closed = append(f.closed, regexp.MustCompile(`(?is)^\s*<`+q+`(?:\s[^>]*)?>.*?</`+q+`\s*>`))
+		f.unclosed = append(f.unclosed, regexp.MustCompile(`(?i)^\s*<`+q+`(?:\s[^>]*)?>`))
+	}
+	if len(f.tags) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// strip removes the reasoning blocks at the start of the response to
+// content. Only leading blocks are removed, and only of tags content doesn't
+// use itself, so the translation of an element with the same name stays.
+// A block that is never closed takes the whole response with it, which then
+// counts as empty.
+func (f *reasoningFilter) strip(response, content string) string {
+	if f == nil {
+		return response
+	}
+	used := tagNames(content)
+	for removed := true; removed; {
+		removed = false
+		for i, tag := range f.tags {
+			if used[tag] {
+				continue
+			}
+			if m := f.closed[i].FindStringIndex(response); m != nil {
+				response = response[m[1]:]
+				removed = true
+			} else if f.unclosed[i].MatchString(response) {
+				return ""
+			}
+		}
+	}
+	return strings.TrimSpace(response)
+}
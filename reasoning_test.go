@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStripReasoning(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		return http.StatusOK, "<think>\nThe user wants German. <p>Hallo</p> is the answer.\n</think>\n\n[T]" + content
+	})
+	cfg := testConfig(api.URL)
+	filter, err := parseReasoningTags("think, reasoning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ReasoningFilter = filter
+	out, err := translate(t, testBook(`<p>Hello <em>world</em>.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapter := out[chapterName(1)]
+	if !strings.Contains(chapter, "<p>[T]Hello <em>world</em>.</p>") || strings.Contains(chapter, "think") || strings.Contains(chapter, "The user wants") {
+		t.Errorf("the reasoning wasn't removed:\n%s", chapter)
+	}
+}
+
+func TestReasoningFilter(t *testing.T) {
+	filter, err := parseReasoningTags("think,reasoning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, response, content, want string
+	}{
+		{"leading block", "<think>hmm</think> Hallo", "Hello", "Hallo"},
+		{"several blocks", "<THINK a=\"1\">hmm</THINK>\n<reasoning>so</reasoning>Hallo", "Hello", "Hallo"},
+		{"not at the start", "Hallo <think>hmm</think>", "Hello", "Hallo <think>hmm</think>"},
+		{"tag used by the source", "<think>Ich denke</think>", "<think>I think</think>", "<think>Ich denke</think>"},
+		{"never closed", "<think>hmm, let me see. Hallo", "Hello", ""},
+		{"no reasoning", "Hallo", "Hello", "Hallo"},
+	}
+	for _, tt := range tests {
+		if got := filter.strip(tt.response, tt.content); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	var none *reasoningFilter
+	if got := none.strip("<think>hmm</think>Hallo", "Hello"); got != "<think>hmm</think>Hallo" {
+		t.Errorf("without -strip-tags: got %q", got)
+	}
+	if f, err := parseReasoningTags(" , "); f != nil || err != nil {
+		t.Errorf("empty -strip-tags: got %v, %v", f, err)
+	}
+	if _, err := parseReasoningTags("think,<b>"); err == nil {
+		t.Error("no error for an invalid tag name")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// secretPatterns match things that look like credentials: authorization
+// headers echoed back by gateways and the common API key formats.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(authorization["']?\s*[:=]\s*["']?)(bearer\s+)?[^\s"',}]+`),
+	regexp.MustCompile(`(?i)(x-api-key["']?\s*[:=]\s*["']?)[^\s"',}]+`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`()\bAIza[0-9A-Za-z_-]{30,}`),
+	regexp.MustCompile(`()\bsk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`(?i)([?&]key=)[^&\s"']+`),
+}
+
+// maxRedactedSnippet is how much of an error body is logged with -redact-log.
+// Error bodies may quote the request, i.e. the book's content.
+const maxRedactedSnippet = 120
+
+// minMaskedKeyLength is the length from which the configured API key is
+// masked wherever it appears. A shorter one, such as the "x" of a local
+// server, would match all over ordinary text.
+const minMaskedKeyLength = 8
+
+// maskKey masks the configured API key in s.
+func maskKey(s, apiKey string) string {
+	if len(apiKey) < minMaskedKeyLength {
+		return s
+	}
+	return strings.ReplaceAll(s, apiKey, "[REDACTED]")
+}
+
+// redactSecrets masks the configured API key and anything resembling a key.
+func redactSecrets(s, apiKey string) string {
+	s = maskKey(s, apiKey)
+	for _, p := range secretPatterns {
+		s = p.ReplaceAllString(s, "${1}[REDACTED]")
+	}
+	return s
+}
+
+// logSnippet prepares a response body for the log. The configured key is
+// always masked, if it has minMaskedKeyLength; -redact-log additionally masks
+// key-like strings and truncates the body.
+func logSnippet(body []byte, cfg *Config) string {
+	s := string(body)
+	if !cfg.RedactLog {
+		return maskKey(s, cfg.APIKey)
+	}
+
+	s = redactSecrets(s, cfg.APIKey)
+	if len([]rune(s)) > maxRedactedSnippet {
+		s = string([]rune(s)[:maxRedactedSnippet]) + "... (truncated)"
+	}
+	return s
+}
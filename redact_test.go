@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogSnippet(t *testing.T) {
+	tests := []struct {
+		name, key, body string
+		redactLog       bool
+		want            string
+	}{
+		{"key masked", "secret-key-123456", `{"error":"invalid key secret-key-123456"}`, false, `{"error":"invalid key [REDACTED]"}`},
+		{"empty key", "", `{"prompt_tokens":5}`, false, `{"prompt_tokens":5}`},
+		{"short key", "k", `{"prompt_tokens":5}`, false, `{"prompt_tokens":5}`},
+		{"short key, -redact-log", "k", `{"prompt_tokens":5}`, true, `{"prompt_tokens":5}`},
+		{"key-like string, -redact-log", "", `{"error":"bad key sk-abcdefghijklmnopqrstuv"}`, true, `{"error":"bad key [REDACTED]"}`},
+		{"bearer header, -redact-log", "", `Authorization: Bearer abc.def`, true, `Authorization: [REDACTED]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{APIKey: tt.key, RedactLog: tt.redactLog}
+			if got := logSnippet([]byte(tt.body), cfg); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseBodyKeyIsMaskedInLog(t *testing.T) {
+	var logged bytes.Buffer
+	calls := 0
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.Logger = log.New(&logged, "", 0)
+	cfg.DoRequest = fakeAPI([]fakeResponse{{status: 500, body: `{"error":"key ` + cfg.APIKey + ` is over quota"}`}}, &calls)
+
+	requestTranslation("Translate.", "Hello", nil, cfg)
+	if strings.Contains(logged.String(), cfg.APIKey) {
+		t.Errorf("the key is in the log:\n%s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "key [REDACTED] is over quota") {
+		t.Errorf("the error body is not in the log:\n%s", logged.String())
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// translatorManifestName is written into every output EPUB. It records the
+// hash of each translated source file so a later run can tell which files
+// changed since, see -reference.
+const translatorManifestName = "META-INF/epub-translator.json"
+
+type translatorManifest struct {
+	TargetLang string            `json:"targetLang"`
+	Sources    map[string]string `json:"sources"` // entry name -> sha256 of the source file
+}
+
+// referenceEpub is a previously translated EPUB whose translations are reused
+// for source files that haven't changed.
+type referenceEpub struct {
+	reader   *zip.ReadCloser
+	manifest translatorManifest
+}
+
+func openReference(path, targetLang string) (*referenceEpub, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open reference epub: %w", err)
+	}
+
+	ref := &referenceEpub{reader: reader}
+	f := findZipFile(reader.File, translatorManifestName)
+	if f == nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s has no %s, it was not produced by this tool", path, translatorManifestName)
+	}
+
+	data, err := readZipFile(f)
+	if err == nil {
+		err = json.Unmarshal(data, &ref.manifest)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("could not read %s: %w", translatorManifestName, err)
+	}
+
+	if ref.manifest.TargetLang != targetLang {
+		reader.Close()
+		return nil, fmt.Errorf("reference was translated to %s, not %s", ref.manifest.TargetLang, targetLang)
+	}
+
+	return ref, nil
+}
+
+// translationFor returns the reference's translated file if its source had
+// the given hash.
+func (r *referenceEpub) translationFor(name, sourceHash string) ([]byte, bool) {
+	if r.manifest.Sources[name] != sourceHash {
+		return nil, false
+	}
+
+	f := findZipFile(r.reader.File, name)
+	if f == nil {
+		return nil, false
+	}
+
+	data, err := readZipFile(f)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (r *referenceEpub) Close() error {
+	return r.reader.Close()
+}
+
+func writeTranslatorManifest(writer *entryWriter, m translatorManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeEntry(writer, translatorManifestName, data)
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
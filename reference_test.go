@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReferenceReusesUnchangedFiles(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	first := writeZip(t, dir, "v1.epub", testBook(`<p>Unchanged chapter.</p>`, `<p>Old wording.</p>`))
+	reference := filepath.Join(dir, "v1-de.epub")
+	if err := processEpub(first, reference, testConfig(api.URL)); err != nil {
+		t.Fatal(err)
+	}
+	sent := len(api.requests())
+
+	second := writeZip(t, dir, "v2.epub", testBook(`<p>Unchanged chapter.</p>`, `<p>New wording.</p>`))
+	ref, err := openReference(reference, "German")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ref.Close()
+	cfg := testConfig(api.URL)
+	cfg.Reference = ref
+	output := filepath.Join(dir, "v2-de.epub")
+	if err := processEpub(second, output, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range api.requests()[sent:] {
+		if strings.Contains(c, "Unchanged chapter.") {
+			t.Errorf("the unchanged chapter was sent again")
+		}
+	}
+	if api.requested("New wording.") != 1 {
+		t.Errorf("the changed chapter wasn't translated: %q", api.requests()[sent:])
+	}
+	out := readEntries(t, output)
+	if !strings.Contains(out[chapterName(1)], "[T]Unchanged chapter.") || !strings.Contains(out[chapterName(2)], "[T]New wording.") {
+		t.Errorf("got chapters:\n%s\n%s", out[chapterName(1)], out[chapterName(2)])
+	}
+}
+
+func TestReferenceOfAnotherLanguage(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", testBook(`<p>Text.</p>`))
+	reference := filepath.Join(dir, "de.epub")
+	if err := processEpub(input, reference, testConfig(api.URL)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openReference(reference, "French"); err == nil {
+		t.Error("a German reference was accepted for French")
+	}
+	if _, err := openReference(input, "German"); err == nil {
+		t.Error("an EPUB that wasn't translated was accepted as reference")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// retryFromReport re-translates exactly the blocks listed as failed in a
+// report written by -report, inside the EPUBs that run produced. Each EPUB is
+// repaired in place. It returns the number of blocks that still failed.
+func retryFromReport(reportPath string, cfg *Config) (int, error) {
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return 0, fmt.Errorf("could not parse report: %w", err)
+	}
+
+	remaining := 0
+	for _, book := range report.Books {
+		if len(book.Failures) == 0 {
+			continue
+		}
+
+		// A report whose output is its input would have the source rewritten
+		if err := checkNotInput(book.Input, book.Output); err != nil {
+			return remaining, err
+		}
+
+		log.Printf("Repairing %d blocks in %s", len(book.Failures), book.Output)
+		failures, err := repairEpub(book.Output, book.Failures, cfg)
+		if cfg.Report != nil {
+			cfg.Report.addBook(newBookReport(book.Input, book.Output, cfg, nil, failures, err))
+		}
+		if err != nil {
+			return remaining, fmt.Errorf("could not repair %s: %w", book.Output, err)
+		}
+		remaining += len(failures)
+	}
+
+	return remaining, nil
+}
+
+// repairEpub rewrites path with the listed blocks translated again.
+func repairEpub(path string, blocks []FailureReport, cfg *Config) ([]blockFailure, error) {
+	byFile := make(map[string][]string)
+	for _, b := range blocks {
+		byFile[b.File] = append(byFile[b.File], b.Block)
+	}
+
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".repair-*.epub")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := newEntryWriter(tmp, cfg)
+	defer writer.Close()
+
+	var failures []blockFailure
+	for _, file := range safeEntries(reader.File) {
+		paths, ok := byFile[file.Name]
+		if !ok {
+			if err := copyFile(file, writer); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data, fileFailures, err := repairFile(file, paths, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error repairing file %s: %w", file.Name, err)
+		}
+		failures = append(failures, fileFailures...)
+
+		// Nothing of the file was found to repair
+		if data == nil {
+			err = copyFile(file, writer)
+		} else {
+			err = writeEntry(writer, file.Name, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	return failures, nil
+}
+
+// repairFile translates the blocks at paths of file again and returns the
+// repaired file, or nil data if none of them was found. XML files (the NCX,
+// the OPF, media overlays) get their texts translated as in translateFile,
+// since the HTML parser would mangle them. Blocks that aren't found are
+// returned as failures, as they are still broken.
+func repairFile(file *zip.File, paths []string, cfg *Config) ([]byte, []blockFailure, error) {
+	source, err := readZipFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, hadBOM := stripBOM(source)
+
+	var failures []blockFailure
+	notFound := func(path string) {
+		log.Printf("  -> %s: block %s not found", file.Name, path)
+		failures = append(failures, blockFailure{File: file.Name, Path: path, Err: fmt.Errorf("%w: block not found in the output", ErrTranslation)})
+	}
+
+	if translateXML := xmlTranslator(file.Name); translateXML != nil {
+		xmlCfg := *cfg
+		xmlCfg.RepairXMLPaths = make(map[string]bool, len(paths))
+		for _, path := range paths {
+			xmlCfg.RepairXMLPaths[path] = false
+		}
+		data, xmlFailures, err := translateXML(source, &xmlCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		found := false
+		for _, path := range paths {
+			if xmlCfg.RepairXMLPaths[path] {
+				found = true
+			} else {
+				notFound(path)
+			}
+		}
+		for _, f := range xmlFailures {
+			f.File = file.Name
+			failures = append(failures, f)
+		}
+		if !found {
+			return nil, failures, nil
+		}
+		return restoreBOM(data, hadBOM, cfg.BOM), failures, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(source))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := false
+	for _, path := range paths {
+		n := findNodeByPath(doc.Get(0), path)
+		if n == nil {
+			notFound(path)
+			continue
+		}
+		found = true
+
+		removeFailureMarker(n)
+		if f := translateBlock(goquery.NewDocumentFromNode(n).Selection, cfg); f != nil {
+			f.File = file.Name
+			failures = append(failures, *f)
+		}
+	}
+	if !found {
+		return nil, failures, nil
+	}
+
+	out, err := renderDocument(doc, source)
+	return restoreBOM([]byte(out), hadBOM, cfg.BOM), failures, err
+}
+
+// removeFailureMarker strips the failureMarker a failed block was given and
+// reports whether it had one.
+func removeFailureMarker(n *html.Node) bool {
+	last := n.LastChild
+	if last == nil || last.Type != html.ElementNode || last.Data != "span" {
+		return false
+	}
+	if strings.TrimSpace(goquery.NewDocumentFromNode(last).Text()) != "(⚠️ Translation failed)" {
+		return false
+	}
+
+	n.RemoveChild(last)
+	if prev := n.LastChild; prev != nil && prev.Type == html.TextNode {
+		prev.Data = strings.TrimSuffix(prev.Data, " ")
+	}
+	return true
+}
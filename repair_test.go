@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRetryFromReport(t *testing.T) {
+	failing := true
+	api := newStubAPI(t, func(content string) (int, string) {
+		if failing && (strings.Contains(content, "Broken") || content == "Chapter 1" || content == "Test Book") {
+			return http.StatusInternalServerError, ""
+		}
+		return prefixReply(content)
+	})
+
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", testBook(`<h1>Title</h1><p>Good text.</p><p>Broken text.</p>`))
+	output := filepath.Join(dir, "out.epub")
+	reportPath := filepath.Join(dir, "report.json")
+
+	cfg := testConfig(api.URL)
+	cfg.MetadataFields = []string{"dc:title"}
+	cfg.Report = newReport(reportPath)
+	if err := processEpub(input, output, cfg); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("first run: got %v, want ErrIncomplete", err)
+	}
+	before := readEntries(t, output)
+	if !strings.Contains(before[chapterName(1)], "Translation failed") {
+		t.Fatalf("first run has no failure marker:\n%s", before[chapterName(1)])
+	}
+
+	failing = false
+	cfg = testConfig(api.URL)
+	cfg.MetadataFields = []string{"dc:title"}
+	remaining, err := retryFromReport(reportPath, cfg)
+	if err != nil || remaining != 0 {
+		t.Fatalf("repair: %d remaining, %v", remaining, err)
+	}
+
+	after := readEntries(t, output)
+	chapter := after[chapterName(1)]
+	if strings.Contains(chapter, "Translation failed") || !strings.Contains(chapter, "[T]Broken text.") {
+		t.Errorf("chapter not repaired:\n%s", chapter)
+	}
+	if strings.Count(chapter, "[T]") != 3 {
+		t.Errorf("translated blocks were translated again:\n%s", chapter)
+	}
+
+	ncx := after["OEBPS/toc.ncx"]
+	for _, want := range []string{`<?xml version="1.0" encoding="utf-8"?>`, "<navMap>", `playOrder="1"`, `<content src="text/ch1.xhtml"/>`, "<text>[T]Chapter 1</text>"} {
+		if !strings.Contains(ncx, want) {
+			t.Errorf("NCX lacks %s:\n%s", want, ncx)
+		}
+	}
+	opf := after["OEBPS/content.opf"]
+	for _, want := range []string{`<?xml version="1.0" encoding="utf-8"?>`, "<dc:title>[T]Test Book</dc:title>", `<meta property="dcterms:modified">`} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("OPF lacks %s:\n%s", want, opf)
+		}
+	}
+	for name, data := range before {
+		if name != chapterName(1) && name != "OEBPS/toc.ncx" && name != "OEBPS/nav.xhtml" && name != "OEBPS/content.opf" && after[name] != data {
+			t.Errorf("%s changed although nothing in it was repaired", name)
+		}
+	}
+}
+
+func TestRetryFromReportCountsMissingBlocks(t *testing.T) {
+	api := newStubAPI(t, nil)
+	dir := t.TempDir()
+	output := writeZip(t, dir, "out.epub", testBook(`<p>Text.</p>`))
+	before, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reportPath := filepath.Join(dir, "report.json")
+	report := newReport(reportPath)
+	report.addBook(&BookReport{Output: output, Failures: []FailureReport{
+		{File: chapterName(1), Block: "html/body/p[7]"},
+		{File: "OEBPS/toc.ncx", Block: "ncx/navMap/navPoint/navLabel/text #9"},
+	}})
+
+	remaining, err := retryFromReport(reportPath, testConfig(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 2 {
+		t.Errorf("got %d remaining blocks, want 2", remaining)
+	}
+	if len(api.requests()) != 0 {
+		t.Errorf("sent %d requests for blocks that don't exist", len(api.requests()))
+	}
+	after, _ := os.ReadFile(output)
+	if !sameEntries(t, before, after) {
+		t.Errorf("files without a repaired block were changed")
+	}
+}
+
+// sameEntries reports whether two zip files have the same entries.
+func sameEntries(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	dir := t.TempDir()
+	pa, pb := filepath.Join(dir, "a.zip"), filepath.Join(dir, "b.zip")
+	os.WriteFile(pa, a, 0o644)
+	os.WriteFile(pb, b, 0o644)
+	ea, eb := readEntries(t, pa), readEntries(t, pb)
+	if len(ea) != len(eb) {
+		return false
+	}
+	for name, data := range ea {
+		if eb[name] != data {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// replacement is one rule of a -replace-map: a literal string or, written as
+// /pattern/, a regular expression, and what to put in its place.
+type replacement struct {
+	literal string
+	pattern *regexp.Regexp
+	target  string
+}
+
+// Replacements are applied to every translated block, see apply.
+type Replacements []replacement
+
+// textEscaper escapes what would be taken for markup in text between tags,
+// and nothing else, so quotes stay as they are for -localize-punctuation.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// loadReplacements reads a -replace-map file with one "from => to" rule per
+// line, applied in order. A from written as /pattern/ is a regular
+// expression, whose groups the target can use as $1 or ${name}. The target
+// may be empty to delete the match. Empty lines and lines starting with #
+// are ignored.
+func loadReplacements(path string) (Replacements, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r Replacements
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		from, to, ok := strings.Cut(line, "=>")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"from => to\"", path, lineNo)
+		}
+
+		rule := replacement{literal: from, target: to}
+		if len(from) > 2 && strings.HasPrefix(from, "/") && strings.HasSuffix(from, "/") {
+			pattern, err := regexp.Compile(from[1 : len(from)-1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			rule = replacement{pattern: pattern, target: to}
+		}
+		r = append(r, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// apply runs the rules on the text of the translated fragment. Tags and
+// their attributes are left alone, and each rule sees the text between two
+// tags with entities decoded, as it reads.
+func (r Replacements) apply(fragment string) string {
+	if len(r) == 0 {
+		return fragment
+	}
+	return replaceInText(fragment, func(text string) string {
+		if text == "" {
+			return text
+		}
+		decoded := html.UnescapeString(text)
+		replaced := decoded
+		for _, rule := range r {
+			if rule.pattern != nil {
+				replaced = rule.pattern.ReplaceAllString(replaced, rule.target)
+			} else {
+				replaced = strings.ReplaceAll(replaced, rule.literal, rule.target)
+			}
+		}
+		if replaced == decoded {
+			return text
+		}
+		return textEscaper.Replace(replaced)
+	})
+}
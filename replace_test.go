@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReplaceMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replace.txt")
+	os.WriteFile(path, []byte(`# House style
+Iphone => iPhone
+/\b(\d+) ?Prozent\b/ => $1 %
+/Sehr geehrte(r)? / =>
+Tom & Jerry => Tom und Jerry
+`), 0o644)
+	replacements, err := loadReplacements(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replacements) != 4 {
+		t.Fatalf("got %d rules, want 4", len(replacements))
+	}
+
+	api := newStubAPI(t, func(content string) (int, string) {
+		return http.StatusOK, `Sehr geehrter Leser, das <a href="https://example.com/Iphone" title="50 Prozent">Iphone</a> kostet 50 Prozent mehr als <em>Tom &amp; Jerry</em>.`
+	})
+	cfg := testConfig(api.URL)
+	cfg.Replacements = replacements
+	out, err := translate(t, testBook(`<p>Dear reader, the <a href="https://example.com/Iphone" title="50 Prozent">Iphone</a> costs 50 percent more than <em>Tom &amp; Jerry</em>.</p>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<p>Leser, das <a href="https://example.com/Iphone" title="50 Prozent">iPhone</a> kostet 50 % mehr als <em>Tom und Jerry</em>.</p>`
+	if chapter := out[chapterName(1)]; !strings.Contains(chapter, want) {
+		t.Errorf("got:\n%s\nwant %s", chapter, want)
+	}
+}
+
+func TestReplacementsApply(t *testing.T) {
+	r := Replacements{
+		{literal: "a<b", target: "a≤b"},
+		{pattern: regexp.MustCompile(`(?i)colou?r`), target: "Farbe"},
+	}
+	tests := []struct{ in, want string }{
+		{`<span class="color">Color</span>`, `<span class="color">Farbe</span>`},
+		{`x a&lt;b y`, `x a≤b y`},
+		{`<img alt="colour"/>`, `<img alt="colour"/>`},
+		{`keine Regel`, `keine Regel`},
+	}
+	for _, tt := range tests {
+		if got := r.apply(tt.in); got != tt.want {
+			t.Errorf("apply(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadReplacementsErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"no arrow":      "Iphone iPhone\n",
+		"empty from":    " => x\n",
+		"invalid regex": "/(/ => x\n",
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-"))
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadReplacements(path); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
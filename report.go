@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+// Report collects the outcome of a run for -report. It covers every book
+// processed by the invocation and is rewritten after each one, so it is
+// current in batch and watch mode too.
+type Report struct {
+	path string
+
+	mu    sync.Mutex
+	Books []*BookReport `json:"books"`
+}
+
+type BookReport struct {
+	Input      string                `json:"input"`
+	Output     string                `json:"output"`
+	TargetLang string                `json:"targetLang"`
+	Model      string                `json:"model"`
+	Error      string                `json:"error,omitempty"`
+	Counts     TextCounts            `json:"counts"`
+	Files      map[string]TextCounts `json:"files,omitempty"`
+	Failures   []FailureReport       `json:"failures"`
+	Warnings   []WarningReport       `json:"warnings,omitempty"`
+}
+
+// FailureReport identifies a block that kept its original text. File and
+// Block (the element path inside the file) together identify it; Spine is
+// the file's 1-based position in the reading order, if it has one.
+type FailureReport struct {
+	File  string `json:"file"`
+	Block string `json:"block"`
+	Spine int    `json:"spine,omitempty"`
+	Error string `json:"error"`
+}
+
+// WarningReport is a translated block that may need a look, located like a
+// FailureReport. -retry-report leaves these alone.
+type WarningReport struct {
+	File    string `json:"file"`
+	Block   string `json:"block"`
+	Spine   int    `json:"spine,omitempty"`
+	Warning string `json:"warning"`
+}
+
+func newReport(path string) *Report {
+	return &Report{path: path, Books: []*BookReport{}}
+}
+
+func (r *Report) addBook(b *BookReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Books = append(r.Books, b)
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.WriteFile(r.path, data, 0o644)
+	}
+	if err != nil {
+		log.Printf("Could not write report: %v", err)
+	}
+}
+
+func newBookReport(inputPath, outputPath string, cfg *Config, counts map[string]TextCounts, failures []blockFailure, err error) *BookReport {
+	b := &BookReport{
+		Input:      inputPath,
+		Output:     outputPath,
+		TargetLang: cfg.TargetLang,
+		Model:      cfg.Model,
+		Counts:     totalCounts(counts),
+		Files:      counts,
+		Failures:   []FailureReport{},
+	}
+	if err != nil && !errors.Is(err, ErrIncomplete) {
+		b.Error = err.Error()
+	}
+	for _, f := range failures {
+		b.Failures = append(b.Failures, FailureReport{File: f.File, Block: f.Path, Spine: f.Spine, Error: f.Err.Error()})
+	}
+	return b
+}
+
+func (b *BookReport) addWarnings(warnings []blockWarning) {
+	for _, w := range warnings {
+		b.Warnings = append(b.Warnings, WarningReport{File: w.File, Block: w.Path, Spine: w.Spine, Warning: w.Message})
+	}
+}
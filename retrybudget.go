@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// retryBudget bounds the time a whole run may lose to failed requests and
+// the waits before their retries (-total-retry-budget). Once it is used up,
+// blocks fail on their first error instead of being retried. Its methods are
+// safe on a nil budget, which is unlimited.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining time.Duration
+	exhausted bool
+}
+
+func newRetryBudget(total time.Duration) *retryBudget {
+	if total <= 0 {
+		return nil
+	}
+	return &retryBudget{remaining: total}
+}
+
+// take charges d, the time a failed attempt took plus the wait before the
+// next one. It reports false, and charges nothing, if d doesn't fit anymore.
+func (b *retryBudget) take(d time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.exhausted && d <= b.remaining {
+		b.remaining -= d
+		return true
+	}
+	if !b.exhausted {
+		b.exhausted = true
+		log.Printf("Warning: the -total-retry-budget is used up, failing blocks are no longer retried")
+	}
+	return false
+}
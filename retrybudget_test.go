@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetTrips(t *testing.T) {
+	api := newStubAPI(t, func(string) (int, string) { return http.StatusServiceUnavailable, "" })
+
+	var body strings.Builder
+	for i := 1; i <= 8; i++ {
+		fmt.Fprintf(&body, "<p>Block %d.</p>", i)
+	}
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", testBook(body.String()))
+	output := filepath.Join(dir, "out.epub")
+	reportPath := filepath.Join(dir, "report.json")
+
+	cfg := testConfig(api.URL)
+	// Without the budget, each block would wait 0.2+0.4+0.8+1.6+3.2 seconds
+	cfg.RetryDelay = 200 * time.Millisecond
+	cfg.RetryBudget = newRetryBudget(300 * time.Millisecond)
+	cfg.Report = newReport(reportPath)
+
+	start := time.Now()
+	if err := processEpub(input, output, cfg); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("got %v, want ErrIncomplete", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("the run took %v despite -total-retry-budget", elapsed)
+	}
+
+	blocks := make(map[string]bool)
+	for _, c := range api.requests() {
+		blocks[c] = true
+	}
+	if n := len(api.requests()); n > len(blocks)+1 {
+		t.Errorf("sent %d requests for %d blocks, want retries to stop once the budget is used up", n, len(blocks))
+	}
+
+	chapter := readEntries(t, output)[chapterName(1)]
+	for i := 1; i <= 8; i++ {
+		if !strings.Contains(chapter, fmt.Sprintf("<p>Block %d. <span", i)) {
+			t.Errorf("block %d didn't keep its original text:\n%s", i, chapter)
+		}
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Books) != 1 || len(report.Books[0].Failures) != len(blocks) {
+		t.Errorf("report doesn't list the %d failed blocks:\n%s", len(blocks), data)
+	}
+}
+
+func TestRetryBudgetTake(t *testing.T) {
+	var unlimited *retryBudget
+	if !unlimited.take(time.Hour) {
+		t.Error("a nil budget refused a retry")
+	}
+
+	b := newRetryBudget(time.Second)
+	if !b.take(600*time.Millisecond) || !b.take(400*time.Millisecond) {
+		t.Fatal("the budget refused retries that fit")
+	}
+	if b.take(time.Millisecond) {
+		t.Error("the budget allowed a retry after it was used up")
+	}
+	if newRetryBudget(0) != nil {
+		t.Error("-total-retry-budget 0 isn't unlimited")
+	}
+}
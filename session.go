@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// captionSelector matches the blocks -file-session translates with the
+// context of the file's text.
+const captionSelector = "figcaption, caption"
+
+// Limits of the context -file-session adds to a caption or label.
+const (
+	sessionMaxPassages  = 5
+	sessionMaxChars     = 300 // per passage and side
+	sessionMinWordChars = 4   // shorter words don't make passages related
+)
+
+// fileSession collects the translations of one file's blocks
+// (-file-session), so its captions and labels, translated after the text,
+// can be given the passages that use the same words and render terms the
+// same way.
+type fileSession struct {
+	mu       sync.Mutex
+	passages []sessionPassage
+}
+
+type sessionPassage struct {
+	source, translated string
+	words              map[string]bool
+}
+
+// add records a translation. Nil-safe, for files without a session.
+func (s *fileSession) add(source, translated string) {
+	if s == nil {
+		return
+	}
+	source, translated = collapseSpace(fragmentText(source)), collapseSpace(fragmentText(translated))
+	if source == "" || translated == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passages = append(s.passages, sessionPassage{source, translated, sessionWords(source)})
+}
+
+// contextFor returns a context listing the earlier passages that share a
+// word with text, the latest first, or "" if there are none.
+func (s *fileSession) contextFor(text string) string {
+	if s == nil {
+		return ""
+	}
+	words := sessionWords(fragmentText(text))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	for i := len(s.passages) - 1; i >= 0 && len(lines) < sessionMaxPassages; i-- {
+		p := s.passages[i]
+		for w := range words {
+			if p.words[w] {
+				lines = append(lines, fmt.Sprintf("%q was translated as %q", truncateRunes(p.source, sessionMaxChars), truncateRunes(p.translated, sessionMaxChars)))
+				break
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Earlier in this chapter, " + strings.Join(lines, "; ") + ". Use the same terms."
+}
+
+// withSession appends the session context for text to context.
+func (s *fileSession) withSession(context, text string) string {
+	if c := s.contextFor(text); c != "" {
+		if context == "" {
+			return c
+		}
+		return context + " " + c
+	}
+	return context
+}
+
+func sessionWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if utf8.RuneCountInString(w) >= sessionMinWordChars {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
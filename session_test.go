@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFileSession(t *testing.T) {
+	body := `<figure><img src="../img/a.png" alt=""/><figcaption>The old lighthouse at night.</figcaption></figure><p>We walked up to the lighthouse.</p>`
+	for _, session := range []bool{false, true} {
+		// The model renders the term differently in the caption, unless it is
+		// told how the body translated it
+		var api *stubAPI
+		api = newStubAPI(t, func(content string) (int, string) {
+			term := "Leuchtturm"
+			if strings.HasPrefix(content, "The old") && !strings.Contains(api.systemPromptFor(content), term) {
+				term = "Leuchtfeuer"
+			}
+			return http.StatusOK, strings.ReplaceAll(content, "lighthouse", term)
+		})
+		cfg := testConfig(api.URL)
+		cfg.FileSession = session
+		out, err := translate(t, testBook(body), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chapter := out[chapterName(1)]
+		if !strings.Contains(chapter, "<p>We walked up to the Leuchtturm.</p>") {
+			t.Fatalf("-file-session %v: unexpected body translation:\n%s", session, chapter)
+		}
+		caption := "<figcaption>The old Leuchtturm at night.</figcaption>"
+		if got := strings.Contains(chapter, caption); got != session {
+			t.Errorf("-file-session %v: caption renders the term like the body: %v\n%s", session, got, chapter)
+		}
+		if session {
+			prompt := api.systemPromptFor("The old lighthouse")
+			if !strings.Contains(prompt, `"We walked up to the lighthouse." was translated as "We walked up to the Leuchtturm."`) {
+				t.Errorf("the caption's prompt lacks the passage with the term:\n%s", prompt)
+			}
+		}
+	}
+}
+
+func TestFileSessionContext(t *testing.T) {
+	var s *fileSession
+	s.add("Ignored", "Ignoriert")
+	if c := s.contextFor("Ignored"); c != "" {
+		t.Errorf("a nil session has context %q", c)
+	}
+
+	s = &fileSession{}
+	s.add("<em>Harbour</em> lights", "Hafen lichter")
+	s.add("The market square", "Der Marktplatz")
+	if c := s.contextFor("A map of the harbour"); !strings.Contains(c, `"Harbour lights" was translated as "Hafen lichter"`) || strings.Contains(c, "market") {
+		t.Errorf("got context %q, want only the passage sharing a word", c)
+	}
+	if c := s.contextFor("The end"); c != "" {
+		t.Errorf("short words made passages related: %q", c)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// maxGroupFiles caps a -merge-small-files group, so a book of hundreds
+	// of tiny files still spreads over the workers.
+	maxGroupFiles = 50
+
+	// defaultGroupTokenBudget is the batch size of a group without
+	// -batch-token-budget.
+	defaultGroupTokenBudget = 1500
+)
+
+// groupable reports whether file is small enough to share its batches with
+// its neighbours (-merge-small-files).
+func groupable(file *zip.File, cfg *Config) bool {
+	if cfg.MergeSmallFiles <= 0 || cfg.MarkPlaceholders || !isTranslatable(file.Name) {
+		return false
+	}
+	return int64(file.UncompressedSize64) < cfg.MergeSmallFiles
+}
+
+// translateFileGroup translates consecutive small (X)HTML files like
+// translateFile, except that their blocks are batched together, so a run of
+// tiny files doesn't cost a request each. Every file is still written on its
+// own. cfgs holds the settings of each file.
+func translateFileGroup(files []*zip.File, cfgs []*Config) []fileResult {
+	type member struct {
+		index     int
+		doc       *htmlDocument
+		selection *goquery.Selection
+		source    []byte
+		hadBOM    bool
+		cfg       *Config
+	}
+
+	budget := cfgs[0].BatchTokenBudget
+	if budget <= 0 {
+		budget = defaultGroupTokenBudget
+	}
+	b := &batcher{budget: budget}
+
+	results := make([]fileResult, len(files))
+	var members []member
+	for i, file := range files {
+		res, source, hadBOM, cfg, done := loadFile(file, cfgs[i])
+		results[i] = res
+		if done {
+			continue
+		}
+
+		d, selection, err := parseHTML(source, cfg)
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		selection = d.prepare(selection, cfg)
+		b.add(selection, file.Name, cfg)
+		members = append(members, member{i, d, selection, source, hadBOM, cfg})
+	}
+	b.flush()
+
+	for _, m := range members {
+		name := files[m.index].Name
+		var blockFailures []blockFailure
+		for _, f := range b.failures {
+			if f.File == name {
+				blockFailures = append(blockFailures, f)
+			}
+		}
+		m.doc.finish(m.selection, blockFailures, m.cfg)
+
+		res := results[m.index]
+		res.failures = m.doc.failures
+		for i := range res.failures {
+			res.failures[i].File = name
+		}
+		htmlStr, err := m.doc.render()
+		if err != nil {
+			res.err = err
+			results[m.index] = res
+			continue
+		}
+		results[m.index] = finishFile(name, res, []byte(htmlStr), m.source, m.hadBOM, m.cfg)
+	}
+	return results
+}
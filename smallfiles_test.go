@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeSmallFiles(t *testing.T) {
+	var chapters []string
+	for i := range 20 {
+		chapters = append(chapters, fmt.Sprintf("<p>Tiny chapter %d.</p>", i+1))
+	}
+	big := "<p>" + strings.Repeat("A chapter that is too big to be merged. ", 40) + "</p>"
+	chapters = append(chapters, big)
+
+	run := func(merge int64) (map[string]string, int) {
+		t.Helper()
+		api := newStubAPI(t, batchReply)
+		cfg := testConfig(api.URL)
+		cfg.MergeSmallFiles = merge
+		out, err := translate(t, testBook(chapters...), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chapterRequests := 0
+		for _, c := range api.requests() {
+			if strings.Contains(c, "chapter") {
+				chapterRequests++
+			}
+		}
+		return out, chapterRequests
+	}
+
+	separate, separateRequests := run(0)
+	merged, mergedRequests := run(1000)
+	if separateRequests != len(chapters) {
+		t.Errorf("without -merge-small-files: got %d requests, want one per file, %d", separateRequests, len(chapters))
+	}
+	if mergedRequests >= separateRequests/4 {
+		t.Errorf("with -merge-small-files: got %d requests for %d files", mergedRequests, len(chapters))
+	}
+
+	if !slices.Equal(sortedKeys(merged), sortedKeys(separate)) {
+		t.Fatalf("the files of the output differ:\n%q\n%q", sortedKeys(merged), sortedKeys(separate))
+	}
+	for name, data := range separate {
+		// The OPF has the time of the run
+		if name != "OEBPS/content.opf" && merged[name] != data {
+			t.Errorf("%s differs:\n%s\n%s", name, merged[name], data)
+		}
+	}
+	if !strings.Contains(merged[chapterName(7)], "<p>[T]Tiny chapter 7.</p>") {
+		t.Errorf("a merged file wasn't translated:\n%s", merged[chapterName(7)])
+	}
+}
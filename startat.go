@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// startPosition resolves -start-at for pkg to a 1-based spine position. The
+// value is either such a position or the name of a spine file, as its path in
+// the zip or just its last elements ("ch05.xhtml", "text/ch05.xhtml").
+func startPosition(value string, pkg *epubPackage) (int, error) {
+	if pkg == nil {
+		return 0, fmt.Errorf("-start-at needs the spine, which could not be read")
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 1 || n > len(pkg.Spine) {
+			return 0, fmt.Errorf("-start-at %d is outside the spine of %d files", n, len(pkg.Spine))
+		}
+		return n, nil
+	}
+
+	match := 0
+	for i, name := range pkg.Spine {
+		if name != value && !hasPathSuffix(name, value) {
+			continue
+		}
+		if match != 0 {
+			return 0, fmt.Errorf("-start-at %q matches both %s and %s, give more of the path", value, pkg.Spine[match-1], name)
+		}
+		match = i + 1
+	}
+	if match == 0 {
+		return 0, fmt.Errorf("-start-at %q is not a file of the spine", value)
+	}
+	return match, nil
+}
+
+// hasPathSuffix reports whether the last elements of name are suffix.
+func hasPathSuffix(name, suffix string) bool {
+	return strings.HasSuffix(name, "/"+strings.TrimPrefix(suffix, "/"))
+}
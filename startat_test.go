@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStartAt(t *testing.T) {
+	book := testBook(`<p>One.</p>`, `<p>Two.</p>`, `<p>Three.</p>`, `<p>Four.</p>`)
+	for _, tt := range []struct {
+		startAt string
+		first   int
+	}{
+		{"ch3.xhtml", 3},
+		{"text/ch3.xhtml", 3},
+		{"2", 2},
+	} {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.StartAt = tt.startAt
+		out, err := translate(t, book, cfg)
+		if err != nil {
+			t.Fatalf("-start-at %s: %v", tt.startAt, err)
+		}
+
+		for i, text := range []string{"One.", "Two.", "Three.", "Four."} {
+			chapter := out[chapterName(i+1)]
+			if i+1 < tt.first {
+				if chapter != xhtml(fmt.Sprintf("<p>%s</p>", text)) || api.requested(text) > 0 {
+					t.Errorf("-start-at %s: %s before the start wasn't copied:\n%s", tt.startAt, chapterName(i+1), chapter)
+				}
+			} else if !strings.Contains(chapter, "<p>[T]"+text+"</p>") {
+				t.Errorf("-start-at %s: %s wasn't translated:\n%s", tt.startAt, chapterName(i+1), chapter)
+			}
+		}
+		for _, name := range []string{"mimetype", "META-INF/container.xml", "OEBPS/content.opf", "OEBPS/img/a.png"} {
+			if _, ok := out[name]; !ok {
+				t.Errorf("-start-at %s: output lacks %s", tt.startAt, name)
+			}
+		}
+	}
+}
+
+func TestStartPositionErrors(t *testing.T) {
+	pkg := &epubPackage{Spine: []string{"OEBPS/a/ch1.xhtml", "OEBPS/b/ch1.xhtml", "OEBPS/b/ch2.xhtml"}}
+	if n, err := startPosition("b/ch1.xhtml", pkg); err != nil || n != 2 {
+		t.Errorf("b/ch1.xhtml: got %d, %v", n, err)
+	}
+	for _, value := range []string{"0", "4", "ch1.xhtml", "ch3.xhtml", "1.xhtml"} {
+		if _, err := startPosition(value, pkg); err == nil {
+			t.Errorf("%s: no error", value)
+		}
+	}
+	if _, err := startPosition("1", nil); err == nil {
+		t.Error("no error without a spine")
+	}
+}
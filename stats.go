@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// TextCounts are the words and characters of the translated text, before and
+// after translation. Characters don't include whitespace.
+type TextCounts struct {
+	SourceWords     int `json:"sourceWords"`
+	SourceChars     int `json:"sourceChars"`
+	TranslatedWords int `json:"translatedWords"`
+	TranslatedChars int `json:"translatedChars"`
+}
+
+func (c *TextCounts) add(o TextCounts) {
+	c.SourceWords += o.SourceWords
+	c.SourceChars += o.SourceChars
+	c.TranslatedWords += o.TranslatedWords
+	c.TranslatedChars += o.TranslatedChars
+}
+
+func (c TextCounts) String() string {
+	return fmt.Sprintf("%d words (%d characters) into %d words (%d characters)",
+		c.SourceWords, c.SourceChars, c.TranslatedWords, c.TranslatedChars)
+}
+
+func totalCounts(files map[string]TextCounts) TextCounts {
+	var total TextCounts
+	for _, c := range files {
+		total.add(c)
+	}
+	return total
+}
+
+// textStats adds up the counts of the segments translated for one file, and
+// collects the blocks whose translation has a suspicious length.
+type textStats struct {
+	mu       sync.Mutex
+	counts   TextCounts
+	warnings []blockWarning
+}
+
+// blockWarning is a translated block that may need a look. It is located
+// like a blockFailure.
+type blockWarning struct {
+	File    string
+	Path    string
+	Spine   int
+	Message string
+}
+
+func (s *textStats) add(source, translated string) {
+	var c TextCounts
+	c.SourceWords, c.SourceChars = countText(fragmentText(source))
+	c.TranslatedWords, c.TranslatedChars = countText(fragmentText(translated))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts.add(c)
+}
+
+func (s *textStats) get() TextCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts
+}
+
+func (s *textStats) warn(w blockWarning) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.warnings = append(s.warnings, w)
+}
+
+func (s *textStats) getWarnings() []blockWarning {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warnings
+}
+
+// minRatioChars is the source length below which the length ratio isn't
+// checked; short labels legitimately vary a lot ("OK" -> "Einverstanden").
+const minRatioChars = 20
+
+// checkLength warns if the translation of the block at s is much longer or
+// shorter than its source, see -length-ratio-min and -length-ratio-max. That
+// usually means the model added commentary or dropped part of the text.
+func checkLength(s *goquery.Selection, source, translated string, cfg *Config) {
+	_, sourceChars := countText(fragmentText(source))
+	if sourceChars < minRatioChars {
+		return
+	}
+	_, translatedChars := countText(fragmentText(translated))
+
+	ratio := float64(translatedChars) / float64(sourceChars)
+	if (cfg.LengthRatioMin <= 0 || ratio >= cfg.LengthRatioMin) && (cfg.LengthRatioMax <= 0 || ratio <= cfg.LengthRatioMax) {
+		return
+	}
+
+	w := blockWarning{
+		Path:    nodePath(s.Get(0)),
+		Message: fmt.Sprintf("translation has %d characters for %d in the source (ratio %.2f)", translatedChars, sourceChars, ratio),
+	}
+	cfg.logf("  -> Warning: block %s: %s", w.Path, w.Message)
+	if cfg.Stats != nil {
+		cfg.Stats.warn(w)
+	}
+}
+
+// fragmentText is the text of an HTML fragment, without tags and entities.
+func fragmentText(fragment string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return fragment
+	}
+	return doc.Text()
+}
+
+// countText counts the words and non-space characters of text. Chinese and
+// Japanese are written without spaces, so each of their characters counts as
+// a word, as is usual for invoicing them; anything else is split at
+// whitespace, and runs of punctuation don't count as words.
+func countText(text string) (words, chars int) {
+	inWord := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			inWord = false
+			continue
+		}
+		chars++
+
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+			words++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if !inWord {
+				words++
+				inWord = true
+			}
+		}
+	}
+	return words, chars
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountText(t *testing.T) {
+	tests := []struct {
+		text         string
+		words, chars int
+	}{
+		{"", 0, 0},
+		{"Hello world, again.", 3, 17},
+		{"  It's 42 -- isn't it?\n", 4, 16},
+		{"日本語のテキスト。", 8, 9},
+		{"中文 text", 3, 6},
+	}
+	for _, tt := range tests {
+		words, chars := countText(tt.text)
+		if words != tt.words || chars != tt.chars {
+			t.Errorf("countText(%q) = %d words, %d characters, want %d, %d", tt.text, words, chars, tt.words, tt.chars)
+		}
+	}
+}
+
+func TestReportCounts(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		if content == "Hello world, again." {
+			return http.StatusOK, "日本語のテキスト。"
+		}
+		return prefixReply(content)
+	})
+	cfg := testConfig(api.URL)
+	path := filepath.Join(t.TempDir(), "report.json")
+	cfg.Report = newReport(path)
+	if _, err := translate(t, testBook(`<p>Hello world, again.</p><p>Two <em>more</em> words.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	book := report.Books[0]
+	want := TextCounts{SourceWords: 6, SourceChars: 30, TranslatedWords: 11, TranslatedChars: 25}
+	if got := book.Files[chapterName(1)]; got != want {
+		t.Errorf("got %+v for the chapter, want %+v", got, want)
+	}
+	if book.Counts != totalCounts(book.Files) || book.Counts.SourceWords <= want.SourceWords {
+		t.Errorf("book counts %+v aren't the sum of the files %+v", book.Counts, book.Files)
+	}
+}
+
+func TestLengthRatioWarning(t *testing.T) {
+	normal := "This sentence is long enough to be checked."
+	long := "This one is long enough as well, and the model rambles."
+	api := newStubAPI(t, func(content string) (int, string) {
+		if content == long {
+			return http.StatusOK, strings.Repeat("Sure! Here is the translation with my comments. ", 10)
+		}
+		return prefixReply(content)
+	})
+	logged := captureLog(t)
+	cfg := testConfig(api.URL)
+	path := filepath.Join(t.TempDir(), "report.json")
+	cfg.Report = newReport(path)
+	if _, err := translate(t, testBook(`<p>`+normal+`</p><p>`+long+`</p><p>Short.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	warnings := report.Books[0].Warnings
+	if len(warnings) != 1 {
+		t.Fatalf("got %d warnings, want 1 for the long translation: %+v", len(warnings), warnings)
+	}
+	if w := warnings[0]; w.File != chapterName(1) || w.Block != "html/body/p[2]" || !strings.Contains(w.Warning, "ratio") {
+		t.Errorf("got warning %+v", w)
+	}
+	if !strings.Contains(strings.Join(logged.lines(), "\n"), "Warning: block html/body/p[2]: translation has") {
+		t.Errorf("no warning logged:\n%s", strings.Join(logged.lines(), "\n"))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// stripOutputPath is where -strip-markers writes the clean copy of input.
+func stripOutputPath(inputPath, outDir string) string {
+	return filepath.Join(outDir, "clean-"+filepath.Base(inputPath))
+}
+
+// stripMarkers writes a copy of the EPUB at inputPath without the failure
+// markers of blocks that kept their original text and, with originals, without
+// the -original-class blocks of bilingual output. Files without either are
+// copied byte for byte.
+func stripMarkers(inputPath, outputPath string, originals bool, cfg *Config) error {
+	reader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return fmt.Errorf("could not open input epub: %w: %w", ErrInvalidEpub, err)
+	}
+	defer reader.Close()
+
+	if err := checkNotInput(inputPath, outputPath); err != nil {
+		return err
+	}
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w: %w", ErrWrite, err)
+	}
+	defer outputFile.Close()
+
+	writer := newEntryWriter(outputFile, cfg)
+	defer writer.Close()
+
+	markers, blocks := 0, 0
+	for _, file := range safeEntries(reader.File) {
+		if !isTranslatable(file.Name) {
+			if err := copyFile(file, writer); err != nil {
+				return err
+			}
+			continue
+		}
+
+		source, err := readZipFile(file)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+		}
+		data, m, b, err := stripFile(source, originals, cfg)
+		if err != nil {
+			return fmt.Errorf("error processing file %s: %w", file.Name, err)
+		}
+		if m+b == 0 {
+			data = source
+		}
+		markers += m
+		blocks += b
+
+		if err := writeEntry(writer, file.Name, data); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("could not finish output file: %w: %w", ErrWrite, err)
+	}
+	if err := outputFile.Close(); err != nil {
+		return fmt.Errorf("could not finish output file: %w: %w", ErrWrite, err)
+	}
+
+	log.Printf("Removed %d failure markers and %d original blocks", markers, blocks)
+	return nil
+}
+
+// stripFile removes the markers (and original blocks) from one (X)HTML file
+// and returns how many of each it removed.
+func stripFile(source []byte, originals bool, cfg *Config) ([]byte, int, int, error) {
+	body, hadBOM := stripBOM(source)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	markers := 0
+	doc.Find("span").Each(func(i int, s *goquery.Selection) {
+		if s.Parent().Length() > 0 && removeFailureMarker(s.Parent().Get(0)) {
+			markers++
+		}
+	})
+
+	blocks := 0
+	if originals && cfg.OriginalClass != "" {
+		o := doc.Find("." + cfg.OriginalClass)
+		blocks = o.Length()
+		o.Remove()
+	}
+
+	out, err := renderDocument(doc, body)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return restoreBOM([]byte(out), hadBOM, bomPreserve), markers, blocks, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripMarkers(t *testing.T) {
+	marked := xhtml(`<p class="original">Good text.</p><p>[T]Good text.</p><p>Broken text.` + failureMarker + `</p>`)
+	book := replaceEntry(testBook(`<p>Chapter text.</p>`, ``), chapterName(2), marked)
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", book)
+	cfg := &Config{OriginalClass: "original"}
+
+	for _, originals := range []bool{false, true} {
+		output := filepath.Join(dir, "clean.epub")
+		if err := stripMarkers(input, output, originals, cfg); err != nil {
+			t.Fatal(err)
+		}
+		out := readEntries(t, output)
+
+		chapter := out[chapterName(2)]
+		if strings.Contains(chapter, "Translation failed") || strings.Contains(chapter, "<span") {
+			t.Errorf("originals %v: marker left:\n%s", originals, chapter)
+		}
+		for _, want := range []string{"<p>[T]Good text.</p>", "<p>Broken text.</p>"} {
+			if !strings.Contains(chapter, want) {
+				t.Errorf("originals %v: chapter lacks %s:\n%s", originals, want, chapter)
+			}
+		}
+		if hasOriginal := strings.Contains(chapter, `<p class="original">Good text.</p>`); hasOriginal == originals {
+			t.Errorf("originals %v: original block kept %v:\n%s", originals, hasOriginal, chapter)
+		}
+		for _, e := range book {
+			if e.name != chapterName(2) && out[e.name] != e.data {
+				t.Errorf("originals %v: %s changed without a marker in it", originals, e.name)
+			}
+		}
+		if len(out) != len(book) {
+			t.Errorf("originals %v: got %d entries, want %d", originals, len(out), len(book))
+		}
+	}
+}
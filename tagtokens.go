@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// tagTokenPrompt is added to the system prompt for -tokenize-tags blocks.
+const tagTokenPrompt = ` The text marks its formatting with placeholders: {{N}} opens and {{/N}} closes a formatted part, a {{N}} without a closing placeholder stands for an element without text. Keep every placeholder exactly once and put it around the words it belongs to, even if they move in the translation.`
+
+var tagTokenPattern = regexp.MustCompile(`\{\{(/?)(\d+)\}\}`)
+
+// hasChildElements reports whether n contains more than text.
+func hasChildElements(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.TextNode {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenizeBlock turns the content of n into its text with a placeholder for
+// every element (and comment), e.g. "Hello {{1}}world{{/1}}.". It returns
+// the elements in the order of their numbers.
+func tokenizeBlock(n *html.Node) (string, []*html.Node) {
+	var b strings.Builder
+	var nodes []*html.Node
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				b.WriteString(encodeNbsp(html.EscapeString(c.Data)))
+				continue
+			}
+
+			nodes = append(nodes, c)
+			id := strconv.Itoa(len(nodes))
+			b.WriteString("{{" + id + "}}")
+			if isEmptyToken(c) {
+				continue
+			}
+			walk(c)
+			b.WriteString("{{/" + id + "}}")
+		}
+	}
+	walk(n)
+
+	return b.String(), nodes
+}
+
+// isEmptyToken reports whether n has a single placeholder rather than an
+// opening and a closing one.
+func isEmptyToken(n *html.Node) bool {
+	return n.Type != html.ElementNode || voidElements[n.Data]
+}
+
+// detokenize rebuilds the HTML of a translated tokenizeBlock text, with
+// copies of nodes in place of the placeholders. The placeholders may have
+// moved, but each has to appear once and they have to nest properly.
+func detokenize(text string, nodes []*html.Node) (string, error) {
+	root := &html.Node{Type: html.ElementNode, Data: "div"}
+	stack := []*html.Node{root}
+	open := []int{0}
+	used := make([]bool, len(nodes)+1)
+
+	appendText := func(s string) {
+		if s != "" {
+			stack[len(stack)-1].AppendChild(&html.Node{Type: html.TextNode, Data: html.UnescapeString(s)})
+		}
+	}
+
+	last := 0
+	for _, m := range tagTokenPattern.FindAllStringSubmatchIndex(text, -1) {
+		appendText(text[last:m[0]])
+		last = m[1]
+
+		closing := m[3] > m[2]
+		id, _ := strconv.Atoi(text[m[4]:m[5]])
+		if id < 1 || id > len(nodes) {
+			return "", fmt.Errorf("unknown placeholder %d", id)
+		}
+
+		if closing {
+			if open[len(open)-1] != id {
+				return "", fmt.Errorf("placeholder %d closed out of order", id)
+			}
+			stack, open = stack[:len(stack)-1], open[:len(open)-1]
+			continue
+		}
+
+		if used[id] {
+			return "", fmt.Errorf("placeholder %d appears twice", id)
+		}
+		used[id] = true
+
+		original := nodes[id-1]
+		if isEmptyToken(original) {
+			stack[len(stack)-1].AppendChild(cloneNode(original))
+			continue
+		}
+		c := &html.Node{Type: original.Type, Data: original.Data, DataAtom: original.DataAtom, Namespace: original.Namespace}
+		c.Attr = append([]html.Attribute(nil), original.Attr...)
+		stack[len(stack)-1].AppendChild(c)
+		stack, open = append(stack, c), append(open, id)
+	}
+	appendText(text[last:])
+
+	if len(open) > 1 {
+		return "", fmt.Errorf("placeholder %d is not closed", open[len(open)-1])
+	}
+	for id := 1; id <= len(nodes); id++ {
+		if !used[id] {
+			return "", fmt.Errorf("placeholder %d is missing", id)
+		}
+	}
+
+	var b bytes.Buffer
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// cloneNode copies n and its descendants.
+func cloneNode(n *html.Node) *html.Node {
+	c := &html.Node{Type: n.Type, Data: n.Data, DataAtom: n.DataAtom, Namespace: n.Namespace}
+	c.Attr = append([]html.Attribute(nil), n.Attr...)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.AppendChild(cloneNode(child))
+	}
+	return c
+}
+
+// translateTokenized translates the block n, whose inner HTML is content,
+// with -tokenize-tags: the model only sees the text and placeholders. If
+// its answer doesn't have the placeholders of the block, the block is sent
+// with its tags after all. The translation memory, the word counts and the
+// session record the block with its tags, not the placeholder text.
+func translateTokenized(n *html.Node, content, context string, cfg *Config) (string, error) {
+	text, nodes := tokenizeBlock(n)
+	if len(nodes) == 0 {
+		return translateNode(content, context, cfg)
+	}
+
+	tokenized := *cfg
+	tokenized.Session = nil
+	tokenized.ExportMemory = nil
+	tokenized.Stats = nil
+
+	response, err := translateNode(text, context, &tokenized)
+	if err != nil {
+		return content + failureMarker, err
+	}
+
+	translated, err := detokenize(response, nodes)
+	if err != nil {
+		cfg.logf("  -> Placeholders of the translation don't match the block (%v), sending it with its tags", err)
+		return translateNode(content, context, cfg)
+	}
+	cfg.remember(content, translated)
+	return translated, nil
+}
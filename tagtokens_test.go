@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeTags(t *testing.T) {
+	block := `<p>Hello <em class="x">dear</em> <a href="b.xhtml#n1">world</a>.</p>`
+	tests := []struct {
+		name  string
+		reply func(string) (int, string)
+		want  string
+		sent  int
+	}{
+		{"placeholders kept", prefixReply, `<p>[T]Hello <em class="x">dear</em> <a href="b.xhtml#n1">world</a>.</p>`, 1},
+		{"placeholders reordered", func(content string) (int, string) {
+			if strings.Contains(content, "{{") {
+				return 200, "Hallo {{2}}Welt{{/2}}, {{1}}liebe{{/1}}."
+			}
+			return prefixReply(content)
+		}, `<p>Hallo <a href="b.xhtml#n1">Welt</a>, <em class="x">liebe</em>.</p>`, 1},
+		{"placeholder lost", func(content string) (int, string) {
+			if strings.Contains(content, "{{") {
+				return 200, "Hallo {{1}}liebe{{/1}} Welt."
+			}
+			return prefixReply(content)
+		}, `<p>[T]Hello <em class="x">dear</em> <a href="b.xhtml#n1">world</a>.</p>`, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStubAPI(t, tt.reply)
+			cfg := testConfig(api.URL)
+			cfg.TokenizeTags = true
+			cfg.ExportMemory = newTranslationMemory("", "en", "de")
+			out, err := translate(t, testBook(block), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if chapter := out[chapterName(1)]; !strings.Contains(chapter, tt.want) {
+				t.Errorf("got:\n%s\nwant %s", chapter, tt.want)
+			}
+			requests := api.requests()
+			if got := api.requested("Hello"); got != tt.sent {
+				t.Errorf("sent the block %d times, want %d: %q", got, tt.sent, requests)
+			}
+			if got := api.requested(`Hello {{1}}dear{{/1}} {{2}}world{{/2}}.`); got != 1 {
+				t.Errorf("sent the tokenized block %d times, want once: %q", got, requests)
+			}
+			if got := api.requested("<em"); got != tt.sent-1 {
+				t.Errorf("sent the block with its tags %d times, want %d", got, tt.sent-1)
+			}
+
+			remembered := false
+			for _, u := range cfg.ExportMemory.units {
+				for _, v := range u.Variants {
+					if tagTokenPattern.MatchString(v.Seg) {
+						t.Errorf("translation memory has placeholder text %q", v.Seg)
+					}
+				}
+				remembered = remembered || u.Variants[0].Seg == `Hello <em class="x">dear</em> <a href="b.xhtml#n1">world</a>.`
+			}
+			if !remembered {
+				t.Errorf("the block is missing from the translation memory: %+v", cfg.ExportMemory.units)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/zip"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// maxSlugRunes keeps file names from -name-from-title well below the limits
+// of file systems.
+const maxSlugRunes = 80
+
+// titleSlug returns the translated title of the EPUB at inputPath as a file
+// name without extension (-name-from-title), or "" if it has no title or the
+// title couldn't be translated.
+func titleSlug(inputPath string, cfg *Config) string {
+	reader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+
+	pkg, err := readPackage(safeEntries(reader.File))
+	if err != nil || strings.TrimSpace(pkg.Title) == "" {
+		log.Printf("Warning: %s has no title, naming the output after the input", inputPath)
+		return ""
+	}
+
+	// Sent like the title in -translate-metadata, so the two share a cache entry
+	translated, err := translateNode(strings.TrimSpace(pkg.Title), metadataContext, cfg)
+	if err != nil {
+		log.Printf("Warning: could not translate the title of %s, naming the output after the input: %v", inputPath, err)
+		return ""
+	}
+	return slugify(fragmentText(translated))
+}
+
+// slugify turns s into a safe file name: lower-case letters and digits of
+// any script, with a dash for each run of anything else.
+func slugify(s string) string {
+	var b strings.Builder
+	n, gap := 0, false
+	for _, r := range strings.ToLower(s) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.In(r, unicode.Mn, unicode.Mc) {
+			gap = true
+			continue
+		}
+		// A dash only with room for a letter after it
+		if n >= maxSlugRunes || gap && n > 0 && n+2 > maxSlugRunes {
+			break
+		}
+		if gap && n > 0 {
+			b.WriteByte('-')
+			n++
+		}
+		b.WriteRune(r)
+		n++
+		gap = false
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNameFromTitle(t *testing.T) {
+	api := newStubAPI(t, func(content string) (int, string) {
+		if content == "Test Book" {
+			return http.StatusOK, "Das Testbuch: Ein <em>Roman</em>!"
+		}
+		return http.StatusInternalServerError, ""
+	})
+	dir := t.TempDir()
+	input := writeZip(t, dir, "book.epub", testBook(`<p>Text.</p>`))
+
+	cfg := testConfig(api.URL)
+	cfg.NameFromTitle = true
+	output := outputPathFor(input, dir, cfg)
+	if filepath.Dir(output) != dir {
+		t.Errorf("output %s isn't in the output directory", output)
+	}
+	if !regexp.MustCompile(`^translated-\d{8}-\d{4}-das-testbuch-ein-roman\.epub$`).MatchString(filepath.Base(output)) {
+		t.Errorf("got output name %s, want it from the translated title", filepath.Base(output))
+	}
+
+	if base := filepath.Base(outputPathFor(input, dir, testConfig(api.URL))); !strings.HasSuffix(base, "-book.epub") {
+		t.Errorf("without -name-from-title: got %s", base)
+	}
+
+	failing := newStubAPI(t, func(string) (int, string) { return http.StatusBadRequest, "" })
+	cfg = testConfig(failing.URL)
+	cfg.NameFromTitle = true
+	if base := filepath.Base(outputPathFor(input, dir, cfg)); !strings.HasSuffix(base, "-book.epub") {
+		t.Errorf("with an untranslated title: got %s, want the input's name", base)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Les Misérables", "les-misérables"},
+		{"  ../A/B\\C: D?  ", "a-b-c-d"},
+		{"東京物語", "東京物語"},
+		{"हिन्दी कहानी", "हिन्दी-कहानी"},
+		{"2001: A Space Odyssey", "2001-a-space-odyssey"},
+		{"?!", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	got := slugify(strings.Repeat("word ", 40))
+	if want := strings.TrimSuffix(strings.Repeat("word-", 16), "-"); got != want || utf8.RuneCountInString(got) > maxSlugRunes {
+		t.Errorf("long title: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tmxDocument is a TMX 1.4 translation memory. Segments are the blocks'
+// inner HTML, stored as escaped text (datatype "html").
+type tmxDocument struct {
+	XMLName xml.Name  `xml:"tmx"`
+	Version string    `xml:"version,attr"`
+	Header  tmxHeader `xml:"header"`
+	Units   []tmxUnit `xml:"body>tu"`
+}
+
+type tmxHeader struct {
+	CreationTool        string `xml:"creationtool,attr"`
+	CreationToolVersion string `xml:"creationtoolversion,attr"`
+	SegType             string `xml:"segtype,attr"`
+	OTMF                string `xml:"o-tmf,attr"`
+	AdminLang           string `xml:"adminlang,attr"`
+	SrcLang             string `xml:"srclang,attr"`
+	DataType            string `xml:"datatype,attr"`
+}
+
+type tmxUnit struct {
+	Variants []tmxVariant `xml:"tuv"`
+}
+
+type tmxVariant struct {
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Seg  string `xml:"seg"`
+}
+
+// tmxUndetermined is the language of source segments without -source-lang.
+const tmxUndetermined = "und"
+
+// tmxLang is the language code written for a language.
+func tmxLang(lang string) string {
+	if lang == "" {
+		return tmxUndetermined
+	}
+	if l, ok := lookupLanguage(lang); ok {
+		return l.Code
+	}
+	return lang
+}
+
+// translationMemory collects the segment pairs of a run for -export-tmx.
+// Like the report, it is rewritten after every book.
+type translationMemory struct {
+	path       string
+	sourceLang string
+	targetLang string
+
+	mu    sync.Mutex
+	seen  map[string]bool
+	units []tmxUnit
+}
+
+func newTranslationMemory(path, sourceLang, targetLang string) *translationMemory {
+	return &translationMemory{
+		path:       path,
+		sourceLang: tmxLang(sourceLang),
+		targetLang: tmxLang(targetLang),
+		seen:       make(map[string]bool),
+	}
+}
+
+// Add records a translated segment. Repeated sources are kept once.
+func (m *translationMemory) Add(source, target string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.seen[source] {
+		return
+	}
+	m.seen[source] = true
+	m.units = append(m.units, tmxUnit{Variants: []tmxVariant{
+		{Lang: m.sourceLang, Seg: source},
+		{Lang: m.targetLang, Seg: target},
+	}})
+}
+
+func (m *translationMemory) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	srcLang := m.sourceLang
+	if srcLang == tmxUndetermined {
+		srcLang = "*all*"
+	}
+
+	doc := tmxDocument{
+		Version: "1.4",
+		Header: tmxHeader{
+			CreationTool:        "epub-translator",
+			CreationToolVersion: appVersion(),
+			SegType:             "paragraph",
+			OTMF:                "epub-translator",
+			AdminLang:           "en",
+			SrcLang:             srcLang,
+			DataType:            "html",
+		},
+		Units: m.units,
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, append([]byte(xml.Header), data...), 0o644)
+}
+
+// loadTMX reads the segment pairs for targetLang from a TMX file, keyed by
+// source segment. The target variant is the one whose language matches
+// targetLang, exactly or by its primary subtag ("de-DE" for German); the
+// source is the other one.
+func loadTMX(path, targetLang string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc tmxDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	target := tmxLang(targetLang)
+	memory := make(map[string]string)
+	for _, u := range doc.Units {
+		ti := targetVariant(u.Variants, target)
+		if ti < 0 || len(u.Variants) != 2 {
+			continue
+		}
+		source := u.Variants[1-ti].Seg
+		if _, ok := memory[source]; !ok {
+			memory[source] = u.Variants[ti].Seg
+		}
+	}
+	return memory, nil
+}
+
+func targetVariant(variants []tmxVariant, target string) int {
+	for i, v := range variants {
+		if strings.EqualFold(v.Lang, target) {
+			return i
+		}
+	}
+	primary := func(lang string) string {
+		return strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+	}
+	for i, v := range variants {
+		if primary(v.Lang) == primary(target) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTMXRoundTrip(t *testing.T) {
+	book := testBook(`<p>First <em>segment</em> &amp; more.</p><p>Second segment.</p>`)
+	path := filepath.Join(t.TempDir(), "memory.tmx")
+
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.ExportMemory = newTranslationMemory(path, "English", "German")
+	first, err := translate(t, book, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory, err := loadTMX(path, "German")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for source, target := range map[string]string{
+		"First <em>segment</em> &amp; more.": "[T]First <em>segment</em> &amp; more.",
+		"Second segment.":                    "[T]Second segment.",
+	} {
+		if memory[source] != target {
+			t.Errorf("memory has %q for %q, want %q", memory[source], source, target)
+		}
+	}
+	if other, err := loadTMX(path, "French"); err != nil || len(other) != 0 {
+		t.Errorf("got %d segments for French from a German memory, %v", len(other), err)
+	}
+
+	api = newStubAPI(t, nil)
+	cfg = testConfig(api.URL)
+	cfg.ImportedMemory = memory
+	second, err := translate(t, book, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := api.requested("segment"); n != 0 {
+		t.Errorf("requested %d segments that are in the imported memory", n)
+	}
+	if second[chapterName(1)] != first[chapterName(1)] {
+		t.Errorf("imported translation differs from the exported one:\n%s\n%s", second[chapterName(1)], first[chapterName(1)])
+	}
+	if !strings.Contains(second[chapterName(1)], "<p>[T]Second segment.</p>") {
+		t.Errorf("chapter lacks the imported segment:\n%s", second[chapterName(1)])
+	}
+}
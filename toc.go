@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+func isNCX(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".ncx")
+}
+
+func isOPF(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".opf")
+}
+
+// isNavDocument reports whether name is the EPUB 3 navigation document.
+func isNavDocument(name string, pkg *epubPackage) bool {
+	if pkg == nil {
+		return false
+	}
+	for _, p := range strings.Fields(pkg.Manifest[name].Properties) {
+		if p == "nav" {
+			return true
+		}
+	}
+	return false
+}
+
+// isTOCFile reports whether name is one of the files -toc-only translates:
+// the OPF, the NCX and the navigation document.
+func isTOCFile(name string, pkg *epubPackage) bool {
+	return isNCX(name) || isNavDocument(name, pkg) || pkg != nil && name == pkg.Path
+}
+
+// translateNCX translates the labels of an EPUB 2 table of contents: the
+// book title and the navLabel of every entry, leaving ids, play order and
+// content references as they are.
+func translateNCX(source []byte, cfg *Config) ([]byte, []blockFailure, error) {
+	return translateXMLText(source, func(path []string) bool {
+		if len(path) < 2 || path[len(path)-1] != "text" {
+			return false
+		}
+		parent := path[len(path)-2]
+		return parent == "navLabel" || parent == "docTitle"
+	}, "This is an entry of the book's table of contents. Output plain text only.", cfg)
+}
+
+// translateOPFMetadata translates the metadata fields of cfg.MetadataFields
+// in the OPF, as shown in library catalogs: "dc:NAME" is the text of a Dublin
+// Core element such as <dc:title>, any other name either the text of an
+// EPUB 3 <meta property="NAME"> or the content attribute of an EPUB 2
+// <meta name="NAME" content="...">, like calibre:series.
+func translateOPFMetadata(source []byte, cfg *Config) ([]byte, []blockFailure, error) {
+	fields := make(map[string]bool, len(cfg.MetadataFields))
+	for _, f := range cfg.MetadataFields {
+		fields[f] = true
+	}
+
+	return translateXML(source, func(path []string, start xml.StartElement) (string, bool) {
+		if len(path) < 2 || path[len(path)-2] != "metadata" {
+			return "", false
+		}
+		name := path[len(path)-1]
+		if name != "meta" {
+			return "", fields["dc:"+name]
+		}
+		if fields[xmlAttr(start, "property")] {
+			return "", true
+		}
+		if fields[xmlAttr(start, "name")] {
+			return "content", true
+		}
+		return "", false
+	}, metadataContext, cfg)
+}
+
+// metadataContext is sent with the metadata fields.
+const metadataContext = "This is a field of the book's metadata, such as its title, description, subject or series."
+
+func xmlAttr(start xml.StartElement, local string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// defaultMetadataFields are translated by -toc-only unless
+// -translate-metadata says otherwise.
+var defaultMetadataFields = []string{"dc:title", "dc:description"}
+
+// nonTextualMetadata are metadata fields -translate-metadata refuses, since
+// translating them would break the book: identifiers, dates, codes and the
+// refining metas that qualify other fields.
+var nonTextualMetadata = map[string]bool{
+	"dc:identifier": true, "dc:date": true, "dc:language": true, "dc:format": true,
+	"dcterms:modified": true, "dcterms:identifier": true, "dcterms:date": true, "dcterms:language": true,
+	"identifier-type": true, "title-type": true, "collection-type": true, "group-position": true,
+	"display-seq": true, "role": true, "file-as": true, "source-of": true, "meta-auth": true,
+	"calibre:series_index": true, "calibre:timestamp": true, "calibre:title_sort": true, "cover": true,
+}
+
+// parseMetadataFields parses a -translate-metadata list such as
+// "dc:title,dc:subject,belongs-to-collection,calibre:series".
+func parseMetadataFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if nonTextualMetadata[f] {
+			return nil, fmt.Errorf("-translate-metadata can't translate %q, it isn't text", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
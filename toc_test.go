@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const seriesOPF = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+<dc:identifier id="id">urn:isbn:9780000000000</dc:identifier><dc:title>Test Book</dc:title><dc:language>en</dc:language><dc:date>2020-01-01</dc:date>
+<dc:subject>Detective stories</dc:subject>
+<meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+<meta property="belongs-to-collection" id="series">The Harbour Mysteries</meta><meta refines="#series" property="collection-type">series</meta><meta refines="#series" property="group-position">2</meta>
+<meta name="calibre:series" content="The Harbour Mysteries"/><meta name="calibre:series_index" content="2"/>
+</metadata>
+<manifest><item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/><item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/><item id="c1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/><item id="img" href="img/a.png" media-type="image/png"/></manifest>
+<spine toc="ncx"><itemref idref="c1"/></spine></package>`
+
+func TestTranslateMetadata(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	fields, err := parseMetadataFields("dc:subject, belongs-to-collection,calibre:series")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.MetadataFields = fields
+	out, err := translate(t, replaceEntry(testBook(`<p>Text.</p>`), "OEBPS/content.opf", seriesOPF), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opf := out["OEBPS/content.opf"]
+	for _, want := range []string{
+		"<dc:subject>[T]Detective stories</dc:subject>",
+		`<meta property="belongs-to-collection" id="series">[T]The Harbour Mysteries</meta>`,
+		`<meta name="calibre:series" content="[T]The Harbour Mysteries"/>`,
+		// Not configured, or not text
+		"<dc:title>Test Book</dc:title>",
+		`<dc:identifier id="id">urn:isbn:9780000000000</dc:identifier>`,
+		"<dc:language>en</dc:language>",
+		"<dc:date>2020-01-01</dc:date>",
+		`<meta refines="#series" property="collection-type">series</meta>`,
+		`<meta refines="#series" property="group-position">2</meta>`,
+		`<meta name="calibre:series_index" content="2"/>`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("OPF lacks %s:\n%s", want, opf)
+		}
+	}
+	if n := strings.Count(opf, "[T]"); n != 3 {
+		t.Errorf("translated %d metadata fields, want 3:\n%s", n, opf)
+	}
+}
+
+func TestParseMetadataFields(t *testing.T) {
+	fields, err := parseMetadataFields(" dc:title,,calibre:series ")
+	if err != nil || len(fields) != 2 || fields[0] != "dc:title" || fields[1] != "calibre:series" {
+		t.Errorf("got %q, %v", fields, err)
+	}
+	for _, field := range []string{"dc:identifier", "dc:date", "group-position", "calibre:series_index"} {
+		if _, err := parseMetadataFields("dc:title," + field); err == nil {
+			t.Errorf("%s: no error", field)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// tocFromHeadings rewrites the output EPUB at path (-toc-from-headings) so
+// the entries of its tables of contents, the nav document's toc and the NCX,
+// read like the translated headings of the chapters they link to, which the
+// model may have worded differently from the separately translated labels.
+// Only the headings of the files in translated, those translated in this
+// run, are used; entries whose target has no heading keep their label.
+func tocFromHeadings(path string, translated map[string]bool, cfg *Config) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+	}
+	defer reader.Close()
+	files := safeEntries(reader.File)
+
+	pkg, err := readPackage(files)
+	if err != nil {
+		return fmt.Errorf("could not read the spine: %w", err)
+	}
+
+	headings := &chapterHeadings{files: files, translated: translated, docs: make(map[string]*goquery.Document), cfg: cfg}
+	changed := make(map[string][]byte)
+	labels := 0
+	for _, file := range files {
+		var data []byte
+		var n int
+		switch {
+		case isNavDocument(file.Name, pkg):
+			data, n, err = navLabelsFromHeadings(file, headings)
+		case isNCX(file.Name):
+			data, n, err = ncxLabelsFromHeadings(file, headings)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error processing file %s: %w", file.Name, err)
+		}
+		if n > 0 {
+			changed[file.Name] = data
+			labels += n
+		}
+	}
+	if len(changed) == 0 {
+		log.Printf("The table of contents already matches the chapter headings")
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".toc-*.epub")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := newEntryWriter(tmp, cfg)
+	defer writer.Close()
+
+	for _, file := range files {
+		if data, ok := changed[file.Name]; ok {
+			err = writeEntry(writer, file.Name, data)
+		} else {
+			err = copyFile(file, writer)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	log.Printf("Took %d table of contents labels from the chapter headings", labels)
+	return nil
+}
+
+// chapterHeadings finds the headings that table of contents entries link
+// to, parsing each chapter once.
+type chapterHeadings struct {
+	files      []*zip.File
+	translated map[string]bool
+	docs       map[string]*goquery.Document
+	cfg        *Config
+}
+
+// forLink returns the heading the link href, relative to the file base,
+// points to, or "" if there is none: with a fragment, the element it names
+// if that is a heading, or else its first <h1> or <h2>; without one, the
+// first <h1> of the file, or else its first <h2>.
+func (c *chapterHeadings) forLink(base, href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Path == "" {
+		return ""
+	}
+	doc := c.document(resolveHref(base, href))
+	if doc == nil {
+		return ""
+	}
+
+	scope := doc.Find("body")
+	if u.Fragment != "" {
+		scope = doc.Find("[id]").FilterFunction(func(i int, s *goquery.Selection) bool {
+			return s.AttrOr("id", "") == u.Fragment
+		}).First()
+		if s := scope.Filter("h1, h2, h3, h4, h5, h6"); s.Length() > 0 {
+			return c.headingText(s)
+		}
+	}
+	for _, selector := range []string{"h1", "h2"} {
+		var text string
+		scope.Find(selector).EachWithBreak(func(i int, s *goquery.Selection) bool {
+			text = c.headingText(s)
+			return text == ""
+		})
+		if text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+func (c *chapterHeadings) document(name string) *goquery.Document {
+	if !c.translated[name] {
+		return nil
+	}
+	if doc, ok := c.docs[name]; ok {
+		return doc
+	}
+
+	var doc *goquery.Document
+	if file := findZipFile(c.files, name); file != nil {
+		if source, err := readZipFile(file); err == nil {
+			source, _ = stripBOM(source)
+			doc, _ = goquery.NewDocumentFromReader(bytes.NewReader(source))
+		}
+	}
+	c.docs[name] = doc
+	return doc
+}
+
+// headingText is the text of a heading, or "" for one that kept its original
+// text, as a failed block or the original of bilingual content.
+func (c *chapterHeadings) headingText(s *goquery.Selection) string {
+	if c.cfg.OriginalClass != "" {
+		for n := s.Get(0); n != nil; n = n.Parent {
+			if n.Type == html.ElementNode && hasClass(n, c.cfg.OriginalClass) {
+				return ""
+			}
+		}
+	}
+	text := s.Text()
+	if strings.Contains(text, "(⚠️ Translation failed)") {
+		return ""
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// navLabelsFromHeadings sets the labels of the links in the toc nav of an
+// EPUB 3 navigation document and returns how many it changed. Other navs,
+// such as the landmarks, are left alone.
+func navLabelsFromHeadings(file *zip.File, headings *chapterHeadings) ([]byte, int, error) {
+	source, err := readZipFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	source, hadBOM := stripBOM(source)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(source))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	changed := 0
+	doc.Find("nav").Each(func(i int, nav *goquery.Selection) {
+		if !strings.Contains(" "+nav.AttrOr("epub:type", "")+" ", " toc ") {
+			return
+		}
+		nav.Find("a[href]").Each(func(i int, a *goquery.Selection) {
+			label := headings.forLink(file.Name, a.AttrOr("href", ""))
+			if label != "" && label != strings.Join(strings.Fields(a.Text()), " ") {
+				a.SetText(label)
+				changed++
+			}
+		})
+	})
+	if changed == 0 {
+		return nil, 0, nil
+	}
+
+	out, err := renderDocument(doc, source)
+	return restoreBOM([]byte(out), hadBOM, headings.cfg.BOM), changed, err
+}
+
+// ncxLabelsFromHeadings sets the navLabel of every navPoint of an EPUB 2
+// NCX to the heading its content links to and returns how many it changed.
+func ncxLabelsFromHeadings(file *zip.File, headings *chapterHeadings) ([]byte, int, error) {
+	source, err := readZipFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	type navPoint struct {
+		from, to int64 // the text of its navLabel
+		src      string
+	}
+	var points []*navPoint
+	var stack []*navPoint
+
+	dec := xml.NewDecoder(bytes.NewReader(source))
+	var path []string
+	var labelStart int64 = -1
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			n := len(path)
+			switch {
+			case t.Name.Local == "navPoint":
+				p := &navPoint{from: -1}
+				stack = append(stack, p)
+				points = append(points, p)
+			case len(stack) == 0:
+			case t.Name.Local == "text" && n >= 3 && path[n-2] == "navLabel" && path[n-3] == "navPoint":
+				if stack[len(stack)-1].from < 0 {
+					labelStart = dec.InputOffset()
+				}
+			case t.Name.Local == "content" && n >= 2 && path[n-2] == "navPoint":
+				stack[len(stack)-1].src = xmlAttr(t, "src")
+			}
+		case xml.EndElement:
+			if t.Name.Local == "text" && labelStart >= 0 {
+				p := stack[len(stack)-1]
+				p.from, p.to = labelStart, before
+				labelStart = -1
+			}
+			if t.Name.Local == "navPoint" && len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			path = path[:len(path)-1]
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].from < points[j].from })
+	var out bytes.Buffer
+	last := int64(0)
+	changed := 0
+	for _, p := range points {
+		if p.from < 0 || p.src == "" {
+			continue
+		}
+		label := headings.forLink(file.Name, p.src)
+		current := strings.Join(strings.Fields(html.UnescapeString(string(source[p.from:p.to]))), " ")
+		if label == "" || label == current {
+			continue
+		}
+		out.Write(source[last:p.from])
+		xml.EscapeText(&out, []byte(label))
+		last = p.to
+		changed++
+	}
+	out.Write(source[last:])
+	return out.Bytes(), changed, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestTOCFromHeadings(t *testing.T) {
+	// The labels are translated apart from the headings, and worded differently
+	reply := func(content string) (int, string) {
+		switch {
+		case strings.Contains(content, "Broken"):
+			return http.StatusInternalServerError, ""
+		case strings.Contains(content, "Chapter"):
+			return http.StatusOK, strings.ReplaceAll(content, "Chapter", "Abschnitt")
+		}
+		return prefixReply(content)
+	}
+	book := testBook(
+		`<h1>The Storm</h1><p>Text one.</p>`,
+		`<h2>The Calm</h2><p>Text two.</p>`,
+		`<p>No heading.</p>`,
+		`<h1>Broken heading</h1><p>Text four.</p>`,
+	)
+	ncxLabel := regexp.MustCompile(`<navLabel><text>([^<]*)</text></navLabel><content src="text/(ch\d)\.xhtml"/>`)
+	labels := func(out map[string]string) (nav, ncx map[string]string) {
+		nav, ncx = make(map[string]string), make(map[string]string)
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(out["OEBPS/nav.xhtml"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.Find("nav a").Each(func(i int, a *goquery.Selection) {
+			nav[strings.TrimSuffix(strings.TrimPrefix(a.AttrOr("href", ""), "text/"), ".xhtml")] = a.Text()
+		})
+		for _, m := range ncxLabel.FindAllStringSubmatch(out["OEBPS/toc.ncx"], -1) {
+			ncx[m[2]] = m[1]
+		}
+		return nav, ncx
+	}
+	heading := func(chapter string) string {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc.Find("h1, h2").First().Text()
+	}
+
+	cfg := testConfig(newStubAPI(t, reply).URL)
+	cfg.TOCFromHeadings = true
+	out, err := translate(t, book, cfg)
+	if out == nil {
+		t.Fatal(err)
+	}
+	nav, ncx := labels(out)
+	for i, ch := range []string{"ch1", "ch2"} {
+		want := heading(out[chapterName(i+1)])
+		if !strings.HasPrefix(want, "[T]") {
+			t.Fatalf("%s has no translated heading:\n%s", ch, out[chapterName(i+1)])
+		}
+		if nav[ch] != want {
+			t.Errorf("nav label of %s is %q, want its heading %q", ch, nav[ch], want)
+		}
+		if ncx[ch] != want {
+			t.Errorf("NCX label of %s is %q, want its heading %q", ch, ncx[ch], want)
+		}
+	}
+	// Without a heading, or with one that wasn't translated, the label stays
+	for ch, want := range map[string]string{"ch3": "Abschnitt 3", "ch4": "Abschnitt 4"} {
+		if nav[ch] != want || ncx[ch] != want {
+			t.Errorf("labels of %s are %q and %q, want the translated label %q", ch, nav[ch], ncx[ch], want)
+		}
+	}
+
+	cfg = testConfig(newStubAPI(t, reply).URL)
+	out, _ = translate(t, book, cfg)
+	nav, ncx = labels(out)
+	if nav["ch1"] != "Abschnitt 1" || ncx["ch1"] != "Abschnitt 1" {
+		t.Errorf("without -toc-from-headings, the labels of ch1 are %q and %q", nav["ch1"], ncx["ch1"])
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "unicode"
+
+// estimateTokens guesses the number of tokens s takes without a tokenizer:
+// about four characters per token for alphabetic scripts, and one per
+// character for CJK, which tokenizers split much finer. It is used for
+// batching and cost estimates, where being off by a few percent is fine.
+func estimateTokens(s string) int {
+	other, wide := 0, 0
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			wide++
+		} else {
+			other++
+		}
+	}
+	return wide + (other+3)/4
+}
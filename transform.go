@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// validateSelector reports a CSS selector goquery would silently treat as
+// matching nothing.
+func validateSelector(flagName, selector string) error {
+	if selector == "" {
+		return nil
+	}
+	if _, err := cascadia.ParseGroup(selector); err != nil {
+		return fmt.Errorf("invalid %s selector %q: %w", flagName, selector, err)
+	}
+	return nil
+}
+
+// applyTransforms cleans up the source before blocks are selected:
+// -remove deletes matching elements with their content, -unwrap replaces
+// matching elements inside a block by their content. Fewer, simpler tags (e.g. tracking or
+// leftover formatting spans) make for better translations and fewer requests.
+func applyTransforms(doc *goquery.Document, cfg *Config) {
+	if cfg.RemoveSelector != "" {
+		doc.Find(cfg.RemoveSelector).Remove()
+	}
+	if cfg.UnwrapSelector != "" {
+		doc.Find(cfg.UnwrapSelector).Each(func(i int, s *goquery.Selection) {
+			// A <span> that isn't inside another block is the block; without
+			// it, its text would not be translated at all
+			if s.Is(cfg.blockSelector()) && s.ParentsFiltered(cfg.blockSelector()).Length() == 0 {
+				return
+			}
+			s.ReplaceWithSelection(s.Contents())
+		})
+	}
+}
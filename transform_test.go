@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	cfg.UnwrapSelector = "span.c1, span.c2"
+	cfg.RemoveSelector = "span.track"
+	out, err := translate(t, testBook(
+		`<p>Some <span class="c1"><span class="c2">nested</span></span> text.<span class="track" data-id="42">x</span></p>`+
+			`<span class="c1">A span as the block.</span>`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if api.requested("Some nested text.") != 1 {
+		t.Errorf("the spans weren't unwrapped and removed before translation: %q", api.requests())
+	}
+	chapter := out[chapterName(1)]
+	for _, want := range []string{"<p>[T]Some nested text.</p>", `<span class="c1">[T]A span as the block.</span>`} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, chapter)
+		}
+	}
+	if strings.Contains(chapter, "track") {
+		t.Errorf("the removed span is still there:\n%s", chapter)
+	}
+}
+
+func TestValidateSelector(t *testing.T) {
+	if err := validateSelector("-unwrap", "span.c1, font"); err != nil {
+		t.Errorf("valid selector: %v", err)
+	}
+	if err := validateSelector("-unwrap", "span[["); err == nil || !strings.Contains(err.Error(), "-unwrap") {
+		t.Errorf("got %v for an invalid selector, want an error naming the flag", err)
+	}
+}
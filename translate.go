@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type OpenAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Content messageContent `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// messageContent is the content of a response message: a string, or an
+// array of parts as some providers return it, whose text parts are joined.
+type messageContent string
+
+func (c *messageContent) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = messageContent(s)
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content is neither a string nor an array of parts: %w", err)
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type == "text" || p.Type == "" {
+			b.WriteString(p.Text)
+		}
+	}
+	*c = messageContent(b.String())
+	return nil
+}
+
+// toneInstructions maps the supported -tone values to the sentence added to
+// the system prompt.
+var toneInstructions = map[string]string{
+	"formal":    "Use a formal, polite register.",
+	"casual":    "Use a casual, conversational register.",
+	"literary":  "Use a literary register that preserves the author's voice, rhythm and imagery.",
+	"technical": "Use a precise technical register and keep established technical terms.",
+}
+
+func buildSystemPrompt(cfg *Config) string {
+	if cfg.LanguagePrompt != "" {
+		prompt := strings.ReplaceAll(cfg.LanguagePrompt, "{language}", cfg.TargetLang)
+		if cfg.SourceLang != "" {
+			prompt += fmt.Sprintf("\n\nThe source text is in %s.", cfg.SourceLang)
+		}
+		if instruction, ok := toneInstructions[cfg.Tone]; ok {
+			prompt += "\n\n" + instruction
+		}
+		if cfg.LanguageProfile != "" {
+			prompt += "\n\n" + profilePrompt(cfg.TargetLang, cfg.LanguageProfile)
+		}
+		return prompt
+	}
+
+	prompt := fmt.Sprintf("You are a professional translator. Translate to %s.", cfg.TargetLang)
+	if cfg.SourceLang != "" {
+		prompt = fmt.Sprintf("You are a professional translator. Translate from %s to %s.", cfg.SourceLang, cfg.TargetLang)
+	}
+	if instruction, ok := toneInstructions[cfg.Tone]; ok {
+		prompt += " " + instruction
+	}
+	if cfg.LanguageProfile != "" {
+		prompt += " " + profilePrompt(cfg.TargetLang, cfg.LanguageProfile)
+	}
+	return prompt + " Keep all HTML tags exactly as they are. Output ONLY the translated content."
+}
+
+// blockPrompt is the system prompt for translating content: the base prompt
+// plus the glossary terms it uses and optional context.
+func blockPrompt(content, context string, cfg *Config) string {
+	systemPrompt := buildSystemPrompt(cfg)
+	if terms := cfg.Glossary.promptFor(content); terms != "" {
+		systemPrompt += " " + terms
+	}
+	if cfg.TokenizeTags && tagTokenPattern.MatchString(content) {
+		systemPrompt += tagTokenPrompt
+	}
+	if context != "" {
+		systemPrompt += " Context (for reference only, do not translate or output it): " + context
+	}
+	return systemPrompt
+}
+
+// translateNode translates one block. context is optional background
+// information for the model that is not part of the text itself. If the block
+// can't be translated, the original content with a failure marker is returned
+// together with an error wrapping ErrAuth, ErrRateLimited or ErrTranslation.
+func translateNode(htmlContent, context string, cfg *Config) (string, error) {
+	if cfg.PivotLang != "" {
+		return translateViaPivot(htmlContent, context, cfg)
+	}
+
+	systemPrompt := blockPrompt(htmlContent, context, cfg)
+
+	key := cacheKey(htmlContent, cfg.TargetLang, cfg.Model, systemPrompt+cfg.Examples.cacheSuffix())
+	if cfg.Cache != nil {
+		if cached, ok := cfg.Cache.Get(key); ok {
+			cfg.remember(htmlContent, cached)
+			return cached, nil
+		}
+	}
+
+	if known, ok := cfg.ImportedMemory[htmlContent]; ok {
+		if cfg.Cache != nil {
+			cfg.Cache.Put(key, known)
+		}
+		cfg.remember(htmlContent, known)
+		return known, nil
+	}
+
+	if cfg.CacheOnly {
+		return htmlContent + failureMarker, errNotCached
+	}
+
+	// A block repeated across files (a running header) is requested once,
+	// concurrent workers wait for that request instead of sending their own
+	result, err, _ := inFlight.Do(key, func() (interface{}, error) {
+		if cfg.Cache != nil {
+			if cached, ok := cfg.Cache.Get(key); ok {
+				return cached, nil
+			}
+		}
+
+		// The response is checked after stripping tags not allowed by
+		// -keep-tags-list, and the last checked version is the result
+		var translated string
+		_, err := requestTranslation(systemPrompt, htmlContent, func(response string) error {
+			translated = cfg.restrictTags(htmlContent, response)
+			return checkFragment(htmlContent, translated)
+		}, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Cache != nil {
+			cfg.Cache.Put(key, translated)
+		}
+		return translated, nil
+	})
+	if err != nil {
+		return htmlContent + failureMarker, err
+	}
+
+	translated := result.(string)
+	cfg.remember(htmlContent, translated)
+	return translated, nil
+}
+
+// inFlight deduplicates concurrent requests for the same cache key.
+var inFlight singleflight.Group
+
+// remember adds a translated segment to the -export-tmx memory, the word
+// counts and the -file-session, if any.
+func (cfg *Config) remember(source, translated string) {
+	cfg.Session.add(source, translated)
+	if cfg.Stats != nil {
+		cfg.Stats.add(source, translated)
+	}
+	if cfg.ExportMemory != nil {
+		cfg.ExportMemory.Add(source, translated)
+	}
+}
+
+// requestSpacing is the pause before every request.
+var requestSpacing = 200 * time.Millisecond
+
+// requestTranslation sends content to the model and returns its answer.
+// Failed requests are retried with a growing delay. A response rejected by
+// check (if not nil) is retried right away, but only once.
+func requestTranslation(systemPrompt, content string, check func(string) error, cfg *Config) (translated string, err error) {
+	maxRetries := 5
+
+	// A malformed response is retried right away, but only this often
+	maxMalformedRetries := 1
+	malformed := 0
+
+	// Start delay for retries (will increase exponentially)
+	retryDelay := cfg.RetryDelay
+
+	// -block-timeout bounds the requests and the waits between them
+	ctx := context.Background()
+	if cfg.BlockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.BlockTimeout)
+		defer cancel()
+	}
+
+	if err := cfg.Abort.get(); err != nil {
+		return "", err
+	}
+
+	if cfg.Provider == providerIdentity {
+		translated := identityResponse(content, cfg)
+		if check != nil {
+			if err := check(translated); err != nil {
+				return "", fmt.Errorf("%w: malformed HTML in response: %v", ErrTranslation, err)
+			}
+		}
+		return translated, nil
+	}
+
+	// Add a small delay to avoid hitting rate limits too quickly
+	cfg.RampUp.wait()
+	time.Sleep(requestSpacing)
+
+	body, _ := json.Marshal(requestPayload(systemPrompt, content, cfg))
+
+	lastStatus := 0
+	lastInfo := ""
+
+	// -audit-log gets a line once the call is over, however it ended
+	start := time.Now()
+	attempts, inputTokens, outputTokens := 0, 0, 0
+	defer func() {
+		record := AuditRecord{
+			Time: start, RunID: cfg.RunID, File: cfg.AuditFile, Blocks: cfg.AuditBlocks, Model: cfg.Model,
+			Status: lastStatus, Attempts: attempts, Retries: max(attempts-1, 0), LatencyMs: time.Since(start).Milliseconds(),
+			InputTokens: inputTokens, OutputTokens: outputTokens,
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		cfg.Audit.record(record, body, translated)
+	}()
+
+	for i := 0; i <= maxRetries; i++ {
+		attempts++
+		attemptStart := time.Now()
+		req, err := newAPIRequest(ctx, body, cfg)
+		if err != nil {
+			cfg.logf("  -> Error creating request: %v", err)
+			return "", fmt.Errorf("%w: %w", ErrTranslation, err)
+		}
+
+		resp, err := cfg.doRequest(req)
+
+		status := 0
+		statusInfo := "network error"
+		if err == nil {
+			// The body is read once, for the translation or for the log, and
+			// closed before the next attempt
+			respBody, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			status = resp.StatusCode
+			lastStatus = status
+			statusInfo = fmt.Sprintf("status %d", status)
+			in, out := responseUsage(respBody)
+			inputTokens += in
+			outputTokens += out
+
+			if status == http.StatusOK && readErr == nil {
+				translated, ok := responseText(respBody, content, cfg)
+				translated = cfg.ReasoningFilter.strip(translated, content)
+
+				// An empty answer would blank the block; it's retried like a missing one
+				if ok && strings.TrimSpace(translated) != "" {
+					if check != nil {
+						if err := check(translated); err != nil {
+							malformed++
+							cfg.logf("  -> Model returned malformed HTML (%v)", err)
+							if malformed > maxMalformedRetries {
+								cfg.logf("Giving up on a block with malformed translations. Keeping original text.")
+								return "", fmt.Errorf("%w: malformed HTML in response: %v", ErrTranslation, err)
+							}
+							continue
+						}
+					}
+
+					return translated, nil
+				}
+				statusInfo += " without a usable translation"
+			}
+
+			if len(respBody) > maxLoggedBody {
+				respBody = respBody[:maxLoggedBody]
+			}
+			if len(respBody) > 0 {
+				statusInfo += " - " + logSnippet(respBody, cfg)
+			}
+		}
+		lastInfo = statusInfo
+
+		// Retrying won't fix the credentials, and neither will any other block
+		if (status == http.StatusUnauthorized || status == http.StatusForbidden) && !cfg.ContinueOnAuthError {
+			err := failureError(status, statusInfo)
+			cfg.logf("  -> The API rejected the credentials (%s), aborting the run", statusInfo)
+			cfg.Abort.set(err)
+			return "", err
+		}
+
+		if ctx.Err() != nil {
+			return "", blockTimeoutError(cfg, lastStatus, lastInfo)
+		}
+
+		if i < maxRetries {
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(retryDelay).After(deadline) {
+				cfg.logf("  -> Translation failed (%s), no time left for a retry", statusInfo)
+				return "", blockTimeoutError(cfg, lastStatus, lastInfo)
+			}
+			if !cfg.RetryBudget.take(time.Since(attemptStart) + retryDelay) {
+				cfg.logf("  -> Translation failed (%s), no retry budget left for the run. Keeping original text.", statusInfo)
+				return "", failureError(lastStatus, lastInfo)
+			}
+			cfg.logf("  -> Translation failed (%s). Retry %d/%d in %v...", statusInfo, i+1, maxRetries, retryDelay)
+			time.Sleep(retryDelay)
+
+			if status == http.StatusTooManyRequests {
+				retryDelay *= 3
+			} else {
+				retryDelay *= 2
+			}
+		}
+	}
+
+	// Final fallback if all retries failed
+	cfg.logf("All retries failed for a block. Keeping original text.")
+
+	return "", failureError(lastStatus, lastInfo)
+}
+
+// newAPIRequest creates the POST of body to the API, with its headers.
+func newAPIRequest(ctx context.Context, body []byte, cfg *Config) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.APIURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	setAuthHeaders(req, cfg)
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	if cfg.RequestIDHeader != "" {
+		req.Header.Set(cfg.RequestIDHeader, cfg.RunID)
+	}
+	return req, nil
+}
+
+// maxLoggedBody caps how much of an error response is logged.
+const maxLoggedBody = 512
+
+// blockTimeoutError is the failure of a block that ran out of -block-timeout.
+func blockTimeoutError(cfg *Config, status int, info string) error {
+	cfg.logf("Block exceeded the block timeout of %v. Keeping original text.", cfg.BlockTimeout)
+	if info == "" {
+		info = "no response"
+	}
+	return failureError(status, fmt.Sprintf("%s; gave up after -block-timeout %v", info, cfg.BlockTimeout))
+}
+
+// failureMarker is appended to blocks that kept their original text.
+const failureMarker = " <span style='color: gray; font-size: 0.8em;'>(⚠️ Translation failed)</span>"
+
+// failureError classifies a block that failed after all retries by the last
+// HTTP status seen.
+func failureError(status int, info string) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("%w (%s)", ErrAuth, info)
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w (%s)", ErrRateLimited, info)
+	case info != "":
+		return fmt.Errorf("%w (%s)", ErrTranslation, info)
+	default:
+		return ErrTranslation
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResponse is one answer of a fakeAPI: a status and body, a transport
+// error, or, with hang, no answer until the request is canceled.
+type fakeResponse struct {
+	status int
+	body   string
+	err    error
+	hang   bool
+}
+
+func okResponse(text string) fakeResponse {
+	return fakeResponse{status: http.StatusOK, body: `{"choices":[{"message":{"content":"` + text + `"}}]}`}
+}
+
+// fakeAPI answers the calls through cfg.DoRequest with responses, in order;
+// the last one is repeated.
+func fakeAPI(responses []fakeResponse, calls *int) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		r := responses[min(*calls, len(responses)-1)]
+		*calls++
+		switch {
+		case r.hang:
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		case r.err != nil:
+			return nil, r.err
+		}
+		return &http.Response{StatusCode: r.status, Body: io.NopCloser(strings.NewReader(r.body))}, nil
+	}
+}
+
+func TestRequestTranslationRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []fakeResponse
+		blockTimeout time.Duration
+		want         string
+		wantErr      error
+		wantCalls    int
+	}{
+		{"success", []fakeResponse{okResponse("Hallo")}, 0, "Hallo", nil, 1},
+		{"429 then 200", []fakeResponse{{status: 429}, okResponse("Hallo")}, 0, "Hallo", nil, 2},
+		{"429 every time", []fakeResponse{{status: 429, body: "slow down"}}, 0, "", ErrRateLimited, 6},
+		{"500 twice then 200", []fakeResponse{{status: 500}, {status: 500}, okResponse("Hallo")}, 0, "Hallo", nil, 3},
+		{"500 every time", []fakeResponse{{status: 500, body: "oops"}}, 0, "", ErrTranslation, 6},
+		{"network error then 200", []fakeResponse{{err: errors.New("connection reset")}, okResponse("Hallo")}, 0, "Hallo", nil, 2},
+		{"malformed JSON then 200", []fakeResponse{{status: 200, body: `{"choices": [`}, okResponse("Hallo")}, 0, "Hallo", nil, 2},
+		{"no translation in the JSON", []fakeResponse{{status: 200, body: `{"error":"quota"}`}}, 0, "", ErrTranslation, 6},
+		{"401", []fakeResponse{{status: 401}}, 0, "", ErrAuth, 1},
+		{"timeout", []fakeResponse{{hang: true}}, 50 * time.Millisecond, "", ErrTranslation, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			cfg := testConfig("http://api.invalid/v1/chat/completions")
+			cfg.DoRequest = fakeAPI(tt.responses, &calls)
+			cfg.BlockTimeout = tt.blockTimeout
+
+			got, err := requestTranslation("Translate.", "Hello", nil, cfg)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRequestTranslationAuthErrorAbortsRun(t *testing.T) {
+	calls := 0
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.DoRequest = fakeAPI([]fakeResponse{{status: 403}}, &calls)
+
+	if _, err := requestTranslation("Translate.", "Hello", nil, cfg); !errors.Is(err, ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	if _, err := requestTranslation("Translate.", "World", nil, cfg); !errors.Is(err, ErrAuth) {
+		t.Fatalf("second block: got %v, want ErrAuth", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1: the run should stop after the first rejection", calls)
+	}
+}
+
+func TestTonePrompt(t *testing.T) {
+	for tone, instruction := range toneInstructions {
+		t.Run(tone, func(t *testing.T) {
+			api := newStubAPI(t, nil)
+			cfg := testConfig(api.URL)
+			cfg.Tone = tone
+			if _, err := translate(t, testBook(`<p>Text.</p>`), cfg); err != nil {
+				t.Fatal(err)
+			}
+			if prompt := api.systemPrompt(0); !strings.Contains(prompt, instruction) {
+				t.Errorf("system prompt lacks %q: %s", instruction, prompt)
+			}
+		})
+	}
+
+	api := newStubAPI(t, nil)
+	if _, err := translate(t, testBook(`<p>Text.</p>`), testConfig(api.URL)); err != nil {
+		t.Fatal(err)
+	}
+	prompt := api.systemPrompt(0)
+	for _, instruction := range toneInstructions {
+		if strings.Contains(prompt, instruction) {
+			t.Errorf("system prompt without -tone has %q", instruction)
+		}
+	}
+}
+
+func TestSourceLangPrompt(t *testing.T) {
+	tests := []struct {
+		name, sourceLang, languagePrompt, want string
+	}{
+		{"auto-detect", "", "", "Translate to German."},
+		{"source language", "Finnish", "", "Translate from Finnish to German."},
+		{"language prompt", "Finnish", "Translate into {language}.", "The source text is in Finnish."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStubAPI(t, nil)
+			cfg := testConfig(api.URL)
+			cfg.SourceLang = tt.sourceLang
+			cfg.LanguagePrompt = tt.languagePrompt
+			if _, err := translate(t, testBook(`<p>Text.</p>`), cfg); err != nil {
+				t.Fatal(err)
+			}
+			prompt := api.systemPrompt(0)
+			if !strings.Contains(prompt, tt.want) {
+				t.Errorf("system prompt lacks %q: %s", tt.want, prompt)
+			}
+			if tt.sourceLang == "" && strings.Contains(prompt, "Translate from") {
+				t.Errorf("system prompt names a source language without -source-lang: %s", prompt)
+			}
+		})
+	}
+}
+
+func TestBlockTimeoutFallsBack(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func() (int, string)
+	}{
+		{"retryable errors", func() (int, string) { return http.StatusServiceUnavailable, "" }},
+		{"slow answer", func() (int, string) {
+			time.Sleep(300 * time.Millisecond)
+			return http.StatusOK, "too late"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStubAPI(t, func(content string) (int, string) {
+				if strings.Contains(content, "Slow") {
+					return tt.reply()
+				}
+				return prefixReply(content)
+			})
+			cfg := testConfig(api.URL)
+			// Without -block-timeout, the retries alone would take many seconds
+			cfg.RetryDelay = 2 * time.Second
+			cfg.BlockTimeout = 100 * time.Millisecond
+
+			start := time.Now()
+			out, err := translate(t, testBook(`<p>Slow block.</p><p>Fast block.</p>`), cfg)
+			if !errors.Is(err, ErrIncomplete) {
+				t.Fatalf("got %v, want ErrIncomplete", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("the block took %v despite -block-timeout", elapsed)
+			}
+			chapter := out[chapterName(1)]
+			if !strings.Contains(chapter, "<p>Slow block. <span") || !strings.Contains(chapter, "Translation failed") {
+				t.Errorf("the slow block didn't fall back to its original text:\n%s", chapter)
+			}
+			if !strings.Contains(chapter, "<p>[T]Fast block.</p>") {
+				t.Errorf("the other block wasn't translated:\n%s", chapter)
+			}
+		})
+	}
+}
+
+// trackedBody is a response body that counts how often it was closed.
+type trackedBody struct {
+	io.Reader
+	closed *int
+}
+
+func (b trackedBody) Close() error {
+	*b.closed++
+	return nil
+}
+
+func TestUnusableResponseIsLoggedAndClosed(t *testing.T) {
+	bodies := []string{`{"error":"model overloaded"}`, `{"choices": [`, `{"choices":[{"message":{"content":"Hallo"}}]}`}
+	calls, closed := 0, 0
+	var logged strings.Builder
+	cfg := testConfig("http://api.invalid/v1/chat/completions")
+	cfg.Logger = log.New(&logged, "", 0)
+	cfg.DoRequest = func(req *http.Request) (*http.Response, error) {
+		body := bodies[calls]
+		calls++
+		if closed != calls-1 {
+			t.Errorf("request %d was sent with %d of the earlier bodies still open", calls, calls-1-closed)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: trackedBody{strings.NewReader(body), &closed}}, nil
+	}
+
+	got, err := requestTranslation("Translate.", "Hello", nil, cfg)
+	if err != nil || got != "Hallo" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if closed != 3 {
+		t.Errorf("closed %d of 3 bodies", closed)
+	}
+	for _, want := range []string{
+		`status 200 without a usable translation - {"error":"model overloaded"}`,
+		`status 200 without a usable translation - {"choices": [`,
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log lacks %s:\n%s", want, logged.String())
+		}
+	}
+}
+
+func TestAuthErrorAbortsTheRun(t *testing.T) {
+	unauthorized := func(string) (int, string) { return http.StatusUnauthorized, "" }
+	book := testBook(`<p>First.</p><p>Second.</p>`, `<p>Third.</p>`)
+
+	api := newStubAPI(t, unauthorized)
+	if _, err := translate(t, book, testConfig(api.URL)); !errors.Is(err, ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	if n := len(api.requests()); n != 1 {
+		t.Errorf("sent %d requests, want the run to stop after the first", n)
+	}
+
+	api = newStubAPI(t, unauthorized)
+	cfg := testConfig(api.URL)
+	cfg.ContinueOnAuthError = true
+	if _, err := translate(t, book, cfg); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("with -continue-on-auth-error: got %v, want ErrIncomplete", err)
+	}
+	for _, block := range []string{"First.", "Second.", "Third."} {
+		if api.requested(block) == 0 {
+			t.Errorf("with -continue-on-auth-error, %s wasn't requested", block)
+		}
+	}
+}
+
+func TestResponseContentShapes(t *testing.T) {
+	for name, body := range map[string]string{
+		"string":         `{"choices":[{"message":{"content":"Hallo Welt"}}]}`,
+		"array of parts": `{"choices":[{"message":{"content":[{"type":"text","text":"Hallo "},{"type":"image_url","image_url":{"url":"x"}},{"type":"text","text":"Welt"}]}}]}`,
+		"untyped parts":  `{"choices":[{"message":{"content":[{"text":"Hallo Welt"}]}}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			cfg := testConfig("http://api.invalid/v1/chat/completions")
+			cfg.DoRequest = fakeAPI([]fakeResponse{{status: http.StatusOK, body: body}}, &calls)
+			got, err := requestTranslation("Translate.", "Hello world", nil, cfg)
+			if err != nil || got != "Hallo Welt" {
+				t.Errorf("got %q, %v, want %q", got, err, "Hallo Welt")
+			}
+			if calls != 1 {
+				t.Errorf("got %d calls, want the first answer used", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	api := newStubAPI(t, nil)
+	cfg := testConfig(api.URL)
+	if _, err := translate(t, testBook(`<p>Text.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range api.headers {
+		if ua := h.Get("User-Agent"); !strings.HasPrefix(ua, "epub-translator/") {
+			t.Errorf("got User-Agent %q, want epub-translator/<version>", ua)
+		}
+		if h.Get("X-Request-Id") != "" {
+			t.Error("sent a request ID without -request-id-header")
+		}
+	}
+
+	api = newStubAPI(t, nil)
+	cfg = testConfig(api.URL)
+	cfg.UserAgent = "my-pipeline/2.0"
+	cfg.RequestIDHeader = "X-Request-Id"
+	cfg.RunID = newRunID()
+	if _, err := translate(t, testBook(`<p>Text.</p>`), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range api.headers {
+		if ua := h.Get("User-Agent"); ua != "my-pipeline/2.0" {
+			t.Errorf("got User-Agent %q, want the -user-agent", ua)
+		}
+		if id := h.Get("X-Request-Id"); id != cfg.RunID {
+			t.Errorf("got request ID %q, want the run ID %q", id, cfg.RunID)
+		}
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := newRunID(), newRunID()
+	if !uuid.MatchString(a) {
+		t.Errorf("%q is not a version 4 UUID", a)
+	}
+	if a == b {
+		t.Error("two runs got the same ID")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockBoundaries are the elements whose start and end break the text of a
+// translated block, so spaces next to them are stray.
+var blockBoundaries = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true,
+	"dd": true, "dt": true, "dl": true, "blockquote": true, "figure": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// isCollapsible reports whether r is whitespace that HTML collapses anyway.
+// A no-break space is content and is kept.
+func isCollapsible(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// normalizeWhitespace cleans up the spacing of the translated block n: runs
+// of whitespace become one space, also across inline tags ("word <em>
+// emphasis</em>"), and spaces at the start and end of the block or next to a
+// nested block are removed. Code keeps its spacing.
+func normalizeWhitespace(n *html.Node) {
+	// The text node holding the last space written, to drop it at a boundary
+	var pending *html.Node
+	afterSpace := true
+
+	trim := func() {
+		if pending != nil {
+			pending.Data = strings.TrimRightFunc(pending.Data, isCollapsible)
+			pending = nil
+		}
+		afterSpace = true
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				var b strings.Builder
+				for _, r := range c.Data {
+					if !isCollapsible(r) {
+						b.WriteRune(r)
+						afterSpace = false
+						continue
+					}
+					if !afterSpace {
+						b.WriteByte(' ')
+						afterSpace = true
+					}
+				}
+				c.Data = b.String()
+				if strings.HasSuffix(c.Data, " ") {
+					pending = c
+				} else if c.Data != "" {
+					pending = nil
+				}
+			case c.Type == html.ElementNode && isCodeElement(c.Data):
+				pending, afterSpace = nil, false
+			case c.Type == html.ElementNode && blockBoundaries[c.Data]:
+				trim()
+				walk(c)
+				trim()
+			case c.Type == html.ElementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	trim()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"runs of spaces", "A  lot   of\n\tspace.", "A lot of space."},
+		{"leading and trailing", "  Trimmed.  ", "Trimmed."},
+		{"across inline tags", "word <em> emphasis </em> more", "word <em>emphasis </em>more"},
+		{"missing space is left alone", "word<em>emphasis</em>", "word<em>emphasis</em>"},
+		{"end of the block inside a tag", "Some <strong>text </strong>", "Some <strong>text</strong>"},
+		{"next to a nested block", "Intro <br/> next line", "Intro<br/>next line"},
+		{"around a nested paragraph", "Quote: <p> inner </p> after", "Quote:<p>inner</p>after"},
+		{"no-break spaces are kept", "10\u00a0\u00a0km  away", "10\u00a0\u00a0km away"},
+		{"code keeps its spacing", "Run <code>a  =  b</code>  now", "Run <code>a  =  b</code> now"},
+		{"pre keeps its spacing", "<pre>  x\n    y</pre>", "<pre>  x\n    y</pre>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+			nodes, err := html.ParseFragment(strings.NewReader(tt.in), block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range nodes {
+				block.AppendChild(n)
+			}
+
+			normalizeWhitespace(block)
+
+			var out strings.Builder
+			for c := block.FirstChild; c != nil; c = c.NextSibling {
+				html.Render(&out, c)
+			}
+			got := strings.ReplaceAll(out.String(), "&nbsp;", "\u00a0")
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+var (
+	htmlTagPattern = regexp.MustCompile(`(?i)<html[\s>]`)
+	headTagPattern = regexp.MustCompile(`(?i)<head[\s/>]`)
+	bodyTagPattern = regexp.MustCompile(`(?i)<body[\s/>]`)
+)
+
+// renderDocument serializes doc without the wrappers the HTML parser adds
+// to incomplete documents: a fragment comes out as a fragment again, and a
+// document without <head> doesn't get an empty one.
+func renderDocument(doc *goquery.Document, source []byte) (string, error) {
+	hasHTML := htmlTagPattern.Match(source)
+	hasHead := headTagPattern.Match(source)
+	hasBody := bodyTagPattern.Match(source)
+
+	root := doc.Get(0)
+	var htmlNode, head, body *html.Node
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "html" {
+			htmlNode = c
+		}
+	}
+	if htmlNode == nil {
+		return doc.Html()
+	}
+	for c := htmlNode.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "head" {
+			head = c
+		} else if c.Type == html.ElementNode && c.Data == "body" {
+			body = c
+		}
+	}
+
+	if head != nil && !hasHead && head.FirstChild == nil {
+		htmlNode.RemoveChild(head)
+		head = nil
+	}
+
+	if hasHTML {
+		return doc.Html()
+	}
+
+	// Without <html>, everything the parser put around the content goes;
+	// only a <body> that was in the source stays
+	var nodes []*html.Node
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if c != htmlNode {
+			nodes = append(nodes, c)
+			continue
+		}
+		if head != nil {
+			if hasHead {
+				nodes = append(nodes, head)
+			} else {
+				nodes = append(nodes, children(head)...)
+			}
+		}
+		if body != nil {
+			if hasBody {
+				nodes = append(nodes, body)
+			} else {
+				nodes = append(nodes, children(body)...)
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		if err := html.Render(&b, n); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func children(n *html.Node) []*html.Node {
+	var nodes []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
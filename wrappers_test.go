@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoSpuriousWrappers(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     []string
+		unwanted []string
+	}{
+		{"fragment", `<section><h1>Title</h1><p>Some text.</p></section>`,
+			[]string{"<section><h1>[T]Title</h1><p>[T]Some text.</p></section>"},
+			[]string{"<html", "<head", "<body"}},
+		{"body without html", `<body><p>Some text.</p></body>`,
+			[]string{"<body><p>[T]Some text.</p></body>"},
+			[]string{"<html", "<head"}},
+		{"document without head", `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Some text.</p></body></html>`,
+			[]string{`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>[T]Some text.</p></body></html>`},
+			[]string{"<head"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newStubAPI(t, nil)
+			entries := replaceEntry(testBook(`<p>Other.</p>`), chapterName(1), tt.source)
+			out, err := translate(t, entries, testConfig(api.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			chapter := out[chapterName(1)]
+			for _, want := range tt.want {
+				if !strings.Contains(chapter, want) {
+					t.Errorf("output lacks %s:\n%s", want, chapter)
+				}
+			}
+			for _, tag := range tt.unwanted {
+				if strings.Contains(chapter, tag) {
+					t.Errorf("output has a %s> the source didn't have:\n%s", tag, chapter)
+				}
+			}
+		})
+	}
+}
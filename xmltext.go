@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// translateXMLText translates the text content of the elements of an XML
+// file selected by match, which is given the local names of the element and
+// its ancestors, outermost first. Only elements containing nothing but text
+// are translated. Everything else is copied byte for byte, since encoding/xml
+// would rewrite namespace prefixes and formatting on the way out.
+func translateXMLText(source []byte, match func(path []string) bool, context string, cfg *Config) ([]byte, []blockFailure, error) {
+	return translateXML(source, func(path []string, start xml.StartElement) (string, bool) {
+		return "", match(path)
+	}, context, cfg)
+}
+
+// translateXML is translateXMLText for matchers that also look at the
+// attributes of an element, and can select the value of one of them (attr)
+// instead of the element's text.
+func translateXML(source []byte, match func(path []string, start xml.StartElement) (attr string, ok bool), context string, cfg *Config) ([]byte, []blockFailure, error) {
+	type span struct {
+		start, end int64
+		path       string
+	}
+	var spans []span
+
+	dec := xml.NewDecoder(bytes.NewReader(source))
+	var path []string
+	candidate := -1 // depth of the matched element whose text is collected
+	var start int64
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidEpub, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			candidate = -1
+			attr, ok := match(path, t)
+			switch {
+			case ok && attr == "":
+				candidate = len(path)
+				start = dec.InputOffset()
+			case ok:
+				if from, to, found := attrValue(source, before, dec.InputOffset(), attr); found {
+					spans = append(spans, span{from, to, strings.Join(path, "/") + "@" + attr})
+				}
+			}
+		case xml.EndElement:
+			if candidate == len(path) && before > start {
+				spans = append(spans, span{start, before, strings.Join(path, "/")})
+			}
+			candidate = -1
+			path = path[:len(path)-1]
+		}
+	}
+
+	var failures []blockFailure
+	var out bytes.Buffer
+	last := int64(0)
+	for i, sp := range spans {
+		spanPath := fmt.Sprintf("%s #%d", sp.path, i+1)
+		if cfg.RepairXMLPaths != nil {
+			if _, listed := cfg.RepairXMLPaths[spanPath]; !listed {
+				continue
+			}
+			cfg.RepairXMLPaths[spanPath] = true
+		}
+
+		text := html.UnescapeString(string(source[sp.start:sp.end]))
+		if !hasLetters(text) {
+			continue
+		}
+
+		translated, err := translateNode(strings.TrimSpace(text), context, cfg)
+		if err != nil {
+			failures = append(failures, blockFailure{Path: spanPath, Err: err})
+			continue
+		}
+
+		out.Write(source[last:sp.start])
+		xml.EscapeText(&out, []byte(translated))
+		last = sp.end
+	}
+	out.Write(source[last:])
+
+	return out.Bytes(), failures, nil
+}
+
+// attrValue locates the value of the attribute attr in the start tag at
+// source[from:to].
+func attrValue(source []byte, from, to int64, attr string) (int64, int64, bool) {
+	pattern := regexp.MustCompile(`\s` + regexp.QuoteMeta(attr) + `\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	m := pattern.FindSubmatchIndex(source[from:to])
+	switch {
+	case m == nil:
+		return 0, 0, false
+	case m[2] >= 0:
+		return from + int64(m[2]), from + int64(m[3]), true
+	default:
+		return from + int64(m[4]), from + int64(m[5]), true
+	}
+}
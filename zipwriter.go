@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// reproducibleLevel is the deflate level of -reproducible output, fixed so
+// it doesn't follow changes of the library default.
+const reproducibleLevel = flate.BestCompression
+
+// entryWriter writes the entries of an output EPUB, giving all of them the
+// same header settings.
+type entryWriter struct {
+	*zip.Writer
+	modified time.Time
+	method   uint16
+	created  int // entries added with Create
+}
+
+// newEntryWriter creates the zip writer for an output. With -reproducible,
+// entries get a fixed timestamp and compression level; -compression-level
+// sets the level or stores the entries uncompressed.
+func newEntryWriter(w io.Writer, cfg *Config) *entryWriter {
+	ew := &entryWriter{Writer: zip.NewWriter(w), method: zip.Deflate}
+	level := flate.DefaultCompression
+	if cfg.Reproducible {
+		ew.modified = reproducibleTime()
+		level = reproducibleLevel
+	}
+	if cfg.CompressionLevel != "" {
+		ew.method, level, _ = compressionLevel(cfg.CompressionLevel)
+	}
+	if level != flate.DefaultCompression {
+		ew.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return ew
+}
+
+// Create adds an entry like zip.Writer.Create, with the writer's timestamp
+// and compression. The mimetype is always stored, as the EPUB container
+// format requires.
+func (w *entryWriter) Create(name string) (io.Writer, error) {
+	method := w.method
+	if name == "mimetype" {
+		method = zip.Store
+	}
+	w.created++
+	return w.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: w.modified})
+}
+
+// compressionLevel parses a -compression-level value: "store" for no
+// compression, or a deflate level from 0 (none) to 9 (best).
+func compressionLevel(s string) (method uint16, level int, err error) {
+	if s == "store" {
+		return zip.Store, flate.DefaultCompression, nil
+	}
+	level, err = strconv.Atoi(s)
+	if err != nil || level < flate.NoCompression || level > flate.BestCompression {
+		return 0, 0, fmt.Errorf("unknown -compression-level %q, expected 0 to 9 or store", s)
+	}
+	return zip.Deflate, level, nil
+}
+
+// reproducibleTime is SOURCE_DATE_EPOCH if set, the common convention of
+// reproducible builds, and otherwise the earliest date a zip can hold.
+func reproducibleTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// mimetypeFirst moves the mimetype entry to the front.
+func mimetypeFirst(files []*zip.File) []*zip.File {
+	ordered := make([]*zip.File, 0, len(files))
+	for _, f := range files {
+		if f.Name == "mimetype" {
+			ordered = append(ordered, f)
+		}
+	}
+	for _, f := range files {
+		if f.Name != "mimetype" {
+			ordered = append(ordered, f)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReproducibleOutput(t *testing.T) {
+	dir := t.TempDir()
+	// mimetype isn't the first entry of the input
+	book := testBook(`<p>First.</p>`, `<p>Second.</p>`)
+	input := writeZip(t, dir, "book.epub", append(book[1:], book[0]))
+	cache := newMemoryCache()
+
+	run := func(name string, reply func(string) (int, string)) []byte {
+		t.Helper()
+		api := newStubAPI(t, reply)
+		cfg := testConfig(api.URL)
+		cfg.Reproducible = true
+		cfg.Cache = cache
+		output := filepath.Join(dir, name)
+		if err := processEpub(input, output, cfg); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	first := run("first.epub", nil)
+	// A second later, the time can't make the output the same by accident
+	time.Sleep(time.Second)
+	second := run("second.epub", func(string) (int, string) { return http.StatusInternalServerError, "" })
+	if !bytes.Equal(first, second) {
+		t.Errorf("two runs with the same cache wrote different bytes")
+	}
+
+	path := filepath.Join(dir, "first.epub")
+	if names := entryNames(t, path); names[0] != "mimetype" {
+		t.Errorf("mimetype isn't the first entry: %q", names)
+	}
+	if opf := readEntries(t, path)["OEBPS/content.opf"]; !strings.Contains(opf, `<meta property="dcterms:modified">1980-01-01T00:00:00Z</meta>`) {
+		t.Errorf("the modification date isn't the fixed one:\n%s", opf)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	run("epoch.epub", nil)
+	if opf := readEntries(t, filepath.Join(dir, "epoch.epub"))["OEBPS/content.opf"]; !strings.Contains(opf, `<meta property="dcterms:modified">2023-11-14T22:13:20Z</meta>`) {
+		t.Errorf("the modification date isn't SOURCE_DATE_EPOCH:\n%s", opf)
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	book := testBook(strings.Repeat(`<p>The same sentence, again and again, so there is something to compress.</p>`, 200))
+	sizes := make(map[string]uint64)
+	for _, tt := range []struct {
+		level  string
+		method uint16
+	}{
+		{"store", zip.Store},
+		{"0", zip.Deflate},
+		{"1", zip.Deflate},
+		{"9", zip.Deflate},
+	} {
+		api := newStubAPI(t, nil)
+		cfg := testConfig(api.URL)
+		cfg.CompressionLevel = tt.level
+		dir := t.TempDir()
+		output := filepath.Join(dir, "out.epub")
+		if err := processEpub(writeZip(t, dir, "book.epub", book), output, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := zip.OpenReader(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.File {
+			switch f.Name {
+			case "mimetype":
+				if f.Method != zip.Store {
+					t.Errorf("-compression-level %s: mimetype is compressed", tt.level)
+				}
+			case chapterName(1):
+				if f.Method != tt.method {
+					t.Errorf("-compression-level %s: got method %d, want %d", tt.level, f.Method, tt.method)
+				}
+				sizes[tt.level] = f.CompressedSize64
+			}
+		}
+		r.Close()
+	}
+
+	if !(sizes["9"] < sizes["0"] && sizes["1"] < sizes["0"] && sizes["0"] >= sizes["store"]) {
+		t.Errorf("the compressed sizes don't follow the levels: %v", sizes)
+	}
+}
+
+func TestParseCompressionLevel(t *testing.T) {
+	for _, invalid := range []string{"10", "-1", "fast", ""} {
+		if _, _, err := compressionLevel(invalid); err == nil {
+			t.Errorf("%q: no error", invalid)
+		}
+	}
+}